@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// export ics writes a calendar per traveler for the trip the group picked, out
+// of a kept run, so everybody can import their flights
+func runExport(args []string) {
+	usage := "usage: flight-finder export ics [-db file] [-run id] [-destination place] [-out dir]"
+	if len(args) == 0 || args[0] != "ics" {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("export ics", flag.ExitOnError)
+	airports := fs.String("airports", defaultAirportsJSON, "path to the location catalog, for the airports' time zones")
+	db := fs.String("db", util.DefaultStorePath(), "where runs are kept")
+	runID := fs.String("run", "", "run the trip is from, default the latest search")
+	destination := fs.String("destination", "", "PlaceId or name of the picked destination, default the run's best trip")
+	out := fs.String("out", ".", "dir the .ics files go in, one per traveler")
+	fs.Parse(args[1:])
+	if fs.NArg() > 0 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	store, err := util.OpenStore(*db)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	run := pickRun(store, *runID)
+	trips, err := store.Trips(run.ID)
+	if err != nil {
+		fmt.Printf("err reading trips: %v\n", err)
+		os.Exit(1)
+	}
+	trip := pickTrip(trips, *destination)
+	if trip == nil {
+		if *destination != "" {
+			fmt.Printf("run %s has no trip to %q\n", shortID(run.ID), *destination)
+		} else {
+			fmt.Printf("run %s has no trips\n", shortID(run.ID))
+		}
+		os.Exit(1)
+	}
+
+	catalog, err := util.LoadCatalog(*airports)
+	if err != nil {
+		fmt.Printf("err loading %s, flight times won't have time zones: %v\n", *airports, err)
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("%s (%s), %s to %s, from run %s\n", trip.Destination.PlaceName, trip.Destination.PlaceID,
+		trip.Dates.Outbound, trip.Dates.Inbound, shortID(run.ID))
+	names := append(trip.Travelers(), trip.Missing...)
+	for _, name := range names {
+		b := &bytes.Buffer{}
+		if err := util.TripCalendar(b, trip, name, catalog); err != nil {
+			fmt.Printf("err making %s's calendar: %v\n", name, err)
+			os.Exit(1)
+		}
+		path := filepath.Join(*out, icsFileName(name))
+		if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		fare, ok := trip.Legs[name]
+		switch {
+		case !ok:
+			fmt.Printf("  %s: %s, no fare so only the trip dates\n", name, path)
+		case fare.Local:
+			fmt.Printf("  %s: %s, already there so only the trip dates\n", name, path)
+		case fare.Outbound == nil && fare.Inbound == nil:
+			fmt.Printf("  %s: %s, the run didn't keep their flights so only the trip dates\n", name, path)
+		default:
+			fmt.Printf("  %s: %s\n", name, path)
+			if floating := util.FloatingAirports(trip, name, catalog); len(floating) > 0 {
+				fmt.Printf("    warning: no time zone for %s in %s, so their flights are in floating time, the clock time wherever the calendar is\n", strings.Join(floating, ", "), *airports)
+			}
+		}
+	}
+}
+
+// pickRun is the run asked for, or the latest one that wasn't a watch check
+func pickRun(store *util.Store, id string) *util.Run {
+	if id != "" {
+		run, err := store.Run(id)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return run
+	}
+	runs, err := store.Runs()
+	if err != nil {
+		fmt.Printf("err reading runs: %v\n", err)
+		os.Exit(1)
+	}
+	for _, run := range runs {
+		if run.Watch == "" {
+			return run
+		}
+	}
+	fmt.Printf("no searches in %s yet, run one first or give -run\n", store.Path())
+	os.Exit(1)
+	return nil
+}
+
+// pickTrip finds destination by PlaceId or name, or with none given the best
+// trip, which is first
+func pickTrip(trips []*util.Trip, destination string) *util.Trip {
+	for _, t := range trips {
+		if destination == "" ||
+			strings.EqualFold(t.Destination.PlaceID, destination) ||
+			strings.EqualFold(t.Destination.PlaceName, destination) {
+			return t
+		}
+	}
+	return nil
+}
+
+func icsFileName(traveler string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' {
+			return '-'
+		}
+		return r
+	}, strings.ToLower(traveler))
+	return name + ".ics"
+}
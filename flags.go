@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// listFlag can be repeated or comma separated: -country MX -country US,CA
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// repeatFlag can be repeated but isn't split on commas, for values that
+// have them in, like urls
+type repeatFlag []string
+
+func (r *repeatFlag) String() string {
+	return strings.Join(*r, " ")
+}
+
+func (r *repeatFlag) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+// destination selection flags shared by every command that picks destinations
+func destinationFlags(fs *flag.FlagSet) *util.DestinationFilter {
+	f := &util.DestinationFilter{}
+	fs.Var((*listFlag)(&f.Countries), "country", "include destinations in these countries (id or name)")
+	fs.Var((*listFlag)(&f.Regions), "region", "include destinations in these regions, by id or US state or Canadian province name")
+	fs.Var((*listFlag)(&f.Cities), "city", "include destinations in these cities")
+	fs.Var((*listFlag)(&f.Places), "place", "include these places")
+	fs.Var((*listFlag)(&f.ExcludeCountries), "exclude-country", "drop destinations in these countries")
+	fs.Var((*listFlag)(&f.ExcludeRegions), "exclude-region", "drop destinations in these regions")
+	fs.Var((*listFlag)(&f.ExcludeCities), "exclude-city", "drop destinations in these cities")
+	fs.Var((*listFlag)(&f.ExcludePlaces), "exclude-place", "drop these places")
+	fs.Var((*listFlag)(&f.Deny), "deny", "never fly into these places, by place or city id or whole name")
+	fs.StringVar(&f.Where, "where", "", `filter expression, ie 'country in [MX, US] and region != "Alaska"'`)
+	return f
+}
+
+// apiKeyFlag is the rapidapi key flag for every command that calls the api.
+// It's never defaulted from the environment here so usage doesn't print it
+func apiKeyFlag(fs *flag.FlagSet) *string {
+	return fs.String("api-key", "", "rapidapi key, default $"+util.APIKeyEnv)
+}
+
+// filterSet is whether any destination flag was given on the command line
+func filterSet(fs *flag.FlagSet) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "country", "region", "city", "place", "exclude-country", "exclude-region", "exclude-city", "exclude-place", "deny", "where", "max-miles":
+			set = true
+		}
+	})
+	return set
+}
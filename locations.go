@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+const (
+	defaultGeoCSV    = "./util/airports-geo.csv"
+	defaultNamesFile = "./util/airports"
+)
+
+// locations [search <name> | show <id> | import-geo]
+func runLocations(args []string) {
+	fs := flag.NewFlagSet("locations", flag.ExitOnError)
+	airports := fs.String("airports", defaultAirportsJSON, "path to the location catalog")
+	limit := fs.Int("limit", 10, "max results for search")
+	geo := fs.String("geo", defaultGeoCSV, "airports csv (OurAirports format) for import-geo")
+	out := fs.String("out", "", "where import-geo and build write the catalog, defaults to -airports. merge has to be given one")
+	names := fs.String("names", defaultNamesFile, "place names for build, one per line")
+	delay := fs.Duration("delay", 1250*time.Millisecond, "pause between lookups for build")
+	fresh := fs.Bool("fresh", false, "build from scratch instead of resuming")
+	fixtures := fs.String("fixtures", "", "answer build lookups from saved responses in this dir instead of the API")
+	apiKey := apiKeyFlag(fs)
+	fs.Parse(args)
+
+	usage := "usage: flight-finder locations [-airports file] [search <name> | show <id> | info | import-geo [-geo csv] [-out file] | build [-names file] [-out file] [-fresh] [-fixtures dir] | diff <old> <new> | merge -out <file> <base> <other>]"
+	if fs.NArg() < 1 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	// flags can go after the command too
+	cmd := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	rest := fs.Args()
+
+	// merge writes somewhere new or not at all, it's too easy to clobber the
+	// real catalog otherwise
+	if cmd == "merge" && *out == "" {
+		fmt.Println("merge needs -out, the file to write the merged catalog to")
+		os.Exit(2)
+	}
+	if *out == "" {
+		*out = *airports
+	}
+
+	// these work on their own files rather than -airports
+	switch cmd {
+	case "build":
+		buildLocations(*names, *out, *delay, *fresh, *fixtures, *apiKey)
+		return
+	case "diff", "merge":
+		if len(rest) != 2 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		a, b := loadCatalogOrExit(rest[0]), loadCatalogOrExit(rest[1])
+		if cmd == "diff" {
+			printCatalogDiff(a, b)
+			return
+		}
+
+		merged := util.MergeCatalogs(a, b)
+		if err := util.WriteCatalog(*out, merged); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("merged %d + %d places into %d, wrote %s\n", a.Len(), b.Len(), merged.Len(), *out)
+		return
+	}
+
+	catalog := loadCatalogOrExit(*airports)
+
+	query := strings.Join(rest, " ")
+	switch cmd {
+	case "search":
+		printLocations(catalog.Search(query, *limit))
+	case "show":
+		printLocations(catalog.Lookup(query))
+	case "info":
+		printCatalogInfo(*airports, catalog)
+	case "import-geo":
+		records, err := util.LoadGeoCSV(*geo)
+		if err != nil {
+			fmt.Printf("err loading geo data: %v\n", err)
+			os.Exit(1)
+		}
+
+		merged, n := catalog.MergeGeo(records, filepath.Base(*geo))
+		if err := util.WriteCatalog(*out, merged); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("added geo data to %d of %d places from %d airports, wrote %s\n", n, merged.Len(), len(records), *out)
+	default:
+		fmt.Printf("unknown locations command %q\n", cmd)
+		os.Exit(2)
+	}
+}
+
+func buildLocations(names, out string, delay time.Duration, fresh bool, fixtures, apiKey string) {
+	var ss util.SkyScanner
+	var err error
+	source := "rapidapi autosuggest"
+	if fixtures != "" {
+		ss, err = util.NewFixtureSkyScanner("", fixtures)
+		source = "fixtures in " + fixtures
+		delay = 0
+	} else {
+		ss, err = util.NewSkyScanner("", apiConfig(apiKey))
+	}
+	if err != nil {
+		fmt.Printf("err instantiating API client: %v\n", err)
+		os.Exit(1)
+	}
+
+	builder := &util.CatalogBuilder{
+		Provider:  ss,
+		NamesPath: names,
+		OutPath:   out,
+		Delay:     delay,
+		Fresh:     fresh,
+		Source:    source,
+	}
+
+	// ctrl-c just stops early, everything so far is already on disk
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := builder.Build(ctx)
+	if report != nil {
+		fmt.Printf("names: %d looked up: %d resumed: %d places added: %d duplicates: %d\n", report.Names, report.Looked, report.Resumed, report.Added, report.Duplicates)
+		if len(report.Unresolved) > 0 {
+			fmt.Printf("unresolved (%d): %s\n", len(report.Unresolved), strings.Join(report.Unresolved, ", "))
+		}
+		if len(report.Failed) > 0 {
+			fmt.Printf("failed (%d): %s\n", len(report.Failed), strings.Join(report.Failed, ", "))
+		}
+	}
+	if err == context.Canceled {
+		fmt.Printf("interrupted; progress saved to %s, run build again to resume\n", out)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("err building catalog: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(report.Failed) > 0 {
+		fmt.Println("run build again to retry the failed ones")
+		return
+	}
+	if err := builder.Finish(); err != nil {
+		fmt.Printf("err cleaning up build progress: %v\n", err)
+	}
+	fmt.Println("wrote", out)
+}
+
+func printLocations(locations []util.Location) {
+	if len(locations) == 0 {
+		fmt.Println("no matching locations")
+		return
+	}
+	for _, l := range locations {
+		fmt.Printf("[ %s ] ID [ %s ] CityID [ %s ] RegionID [ %s ] Country [ %s / %s ]\n", l.PlaceName, l.PlaceID, l.CityID, l.RegionID, l.CountryID, l.CountryName)
+		if l.HasCoords() {
+			fmt.Printf("\t%.4f, %.4f  %dft  %s\n", l.Latitude, l.Longitude, l.ElevationFt, l.TimeZone)
+		}
+	}
+}
+
+func loadCatalogOrExit(path string) *util.Catalog {
+	catalog, err := util.LoadCatalog(path)
+	if err != nil {
+		fmt.Printf("err loading catalog: %v\n", err)
+		os.Exit(1)
+	}
+	return catalog
+}
+
+func printCatalogInfo(path string, c *util.Catalog) {
+	fmt.Printf("%s: schema v%d, %d places\n", path, c.Meta.SchemaVersion, c.Len())
+	if !c.Meta.BuiltAt.IsZero() {
+		fmt.Printf("built: %s\n", c.Meta.BuiltAt.Format(time.RFC3339))
+	}
+	if c.Meta.Source != "" {
+		fmt.Printf("source: %s\n", c.Meta.Source)
+	}
+}
+
+func printCatalogDiff(before, after *util.Catalog) {
+	d := util.DiffCatalogs(before, after)
+	if d.Empty() {
+		fmt.Println("no differences")
+		return
+	}
+
+	for _, l := range d.Added {
+		fmt.Printf("+ %s %s (%s)\n", l.PlaceID, l.PlaceName, l.CountryName)
+	}
+	for _, l := range d.Removed {
+		fmt.Printf("- %s %s (%s)\n", l.PlaceID, l.PlaceName, l.CountryName)
+	}
+	for _, c := range d.Changed {
+		fmt.Printf("~ %s\n", c.PlaceID)
+		for _, f := range c.Changes {
+			fmt.Printf("\t%s: %q -> %q\n", f.Field, f.Old, f.New)
+		}
+	}
+	fmt.Printf("%d added, %d removed, %d changed\n", len(d.Added), len(d.Removed), len(d.Changed))
+}
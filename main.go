@@ -1,19 +1,19 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/abgordon/flight-finder/util"
 )
 
-const (
-	skyScanHost   = "skyscanner-skyscanner-flight-search-v1.p.rapidapi.com"
-	skyScanAPIKey = "GJcM6vJ5FImshFOb5Gfv0Ccq4COSp15iGqnjsnsyF2kQrqfXDu"
-)
-
 /*
 	plan:
 	 - let's find all locations and save them to disk. Need a list of every town with an airport and save
@@ -25,23 +25,285 @@ const (
 	  - need to get more clever
 */
 
+const defaultAirportsJSON = "./util/airports.json"
+
 func main() {
+	cmd, args := "search", []string{}
+	if len(os.Args) > 1 {
+		cmd, args = os.Args[1], os.Args[2:]
+	}
+
+	switch cmd {
+	case "search":
+		runSearch(args)
+	case "locations":
+		runLocations(args)
+	case "retry-failed":
+		runRetryFailed(args)
+	case "serve":
+		runServe(args)
+	case "runs":
+		runRuns(args)
+	case "watch":
+		runWatch(args)
+	case "export":
+		runExport(args)
+	case "vote":
+		runVote(args)
+	case "share":
+		runShare(args)
+	default:
+		fmt.Printf("unknown command %q\n", cmd)
+		fmt.Println("usage: flight-finder [search | locations | retry-failed | serve | runs | watch | export | vote | share]")
+		os.Exit(2)
+	}
+}
+
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	airports := fs.String("airports", defaultAirportsJSON, "path to the location catalog")
+	dryRun := fs.Bool("dry-run", false, "print the destinations that would be searched and exit without calling the API")
+	maxMiles := fs.Float64("max-miles", 0, "only destinations within this many miles of the middle of the group (needs geo data, see locations import-geo)")
+	outboundDate := fs.String("outbound", "2020-01-01", "outbound date, 2020-01-01")
+	inboundDate := fs.String("inbound", "2020-01-05", "return date, 2020-01-05")
+	anywhere := fs.Bool("anywhere", false, "price everywhere with cached browse quotes first and only live search the best -top destinations")
+	topK := fs.Int("top", 20, "how many destinations -anywhere live searches")
+	batchBy := fs.String("batch-by", "", "share one session per traveler between destinations in the same city or country: city|country")
+	batchSize := fs.Int("batch-size", 10, "most airports polled in one -batch-by session")
+	budget := fs.Int("budget", 0, "most api calls the search can make, spent on the destinations the estimates like best. 0 is no limit")
+	slack := fs.Float64("slack", 0.25, "with -budget, skip destinations estimated more than this fraction over the cheapest trip found")
+	groundCost := fs.Float64("ground-cost", 0, "what it costs someone to get to another airport in their own city")
+	costsPath := fs.String("costs", "", "csv or json of lodging, airport transfer and daily spend by city or country, added to every trip's total")
+	reportFormat := fs.String("report", "", "also write a report of every trip found: "+strings.Join(util.ReportFormats(), "|"))
+	reportOut := fs.String("report-out", "", "file for -report, default stdout")
+	fixtures := fs.String("fixtures", "", "answer every request from saved responses in this dir instead of the API")
+	apiKey := apiKeyFlag(fs)
+	db := fs.String("db", util.DefaultStorePath(), "keep the run here for runs list/show. empty to not keep it")
+	notify := repeatFlag{}
+	fs.Var(&notify, "notify", "send the best trips here when the search is over, repeatable: "+strings.Join(util.NotifierSchemes(), "|")+" urls")
+	notifyTop := fs.Int("notify-top", 5, "how many trips -notify sends")
+	progress := fs.Bool("progress", isTerminal(os.Stderr), "draw a progress bar on stderr instead of logging every call. on by default on a terminal")
+	filter := destinationFlags(fs)
+	fs.Parse(args)
+
+	ss := newProvider(*airports, *fixtures, *apiKey)
+
+	// ss.PrettyPrint()
+
+	travelers := defaultTravelers()
+
+	if !*anywhere && !filterSet(fs) {
+		filter.Countries = []string{"Cuba", "Dominican Republic", "United States"}
+	}
 
-	ss, err := util.NewSkyScanner("./util/airports.json")
+	destinations, err := filter.Select(ss.Catalog())
 	if err != nil {
-		fmt.Printf("err instantiating API client: %v\n", err)
+		fmt.Printf("err selecting destinations: %v\n", err)
 		os.Exit(1)
 	}
+	// anywhere with no filter means anywhere, not just what's in the catalog
+	if *anywhere && !filterSet(fs) {
+		destinations = nil
+	}
 
-	// filtered := util.FilterJSON(ss.List(), "Canada", "Cuba", "Dominican Republic", "United States", "Mexico")
-	filtered := util.FilterJSON(ss.List(), "Cuba", "Dominican Republic", "United States")
-	for i, l := range filtered.Places {
+	homes := []util.Location{}
+	for _, traveler := range travelers {
+		if l, ok := ss.Catalog().Place(traveler.LocationCode); ok && l.HasCoords() {
+			homes = append(homes, l)
+		}
+	}
+	if *maxMiles > 0 {
+		if len(homes) == 0 {
+			fmt.Println("no traveler airports have coordinates; run locations import-geo first")
+			os.Exit(1)
+		}
+		lat, lon := util.Centroid(homes)
+		destinations = util.WithinMiles(destinations, lat, lon, *maxMiles)
+	}
+
+	for i, l := range destinations {
 		fmt.Printf("%d: %+v\n", i, l)
+		if *dryRun {
+			printTravelerMiles(ss.Catalog(), travelers, l)
+		}
 	}
 
-	// ss.PrettyPrint()
+	if *dryRun {
+		if *anywhere {
+			fmt.Printf("dry run: would browse quotes from %d home airports, then live search the best %d destinations\n", len(homeAirports(travelers)), *topK)
+		}
+		if *budget > 0 {
+			fmt.Printf("dry run: would spend at most %d api calls, the first %d on browse quotes\n", *budget, len(homeAirports(travelers)))
+		}
+		fmt.Printf("dry run: %d destinations selected, nothing searched\n", len(destinations))
+		return
+	}
+
+	if *reportFormat != "" && !contains(util.ReportFormats(), *reportFormat) {
+		fmt.Printf("-report must be one of %s\n", strings.Join(util.ReportFormats(), ", "))
+		os.Exit(2)
+	}
+	if *batchBy != "" && *batchBy != "city" && *batchBy != "country" {
+		fmt.Printf("-batch-by must be city or country, not %q\n", *batchBy)
+		os.Exit(2)
+	}
+	notifier := newNotifier(notify)
+	costs, costTable := loadCosts(*costsPath)
+
+	engine := newEngine(ss, *fixtures)
+	// sort and write EVERY time bc this thing takes forever, and a write is cheap
+	engine.Checkpoint = func(r *util.SearchResult) {
+		util.WriteResultsToFile(r.Trips)
+	}
+	subscribers := util.Subscribers{}
+	if *progress {
+		subscribers = append(subscribers, newProgressBar(os.Stderr))
+		engine.Log = ioutil.Discard
+	}
+
+	// todo: most expensivest
+	spec := &util.SearchSpec{
+		Travelers:    travelers,
+		OutboundDate: *outboundDate,
+		InboundDate:  *inboundDate,
+		Destinations: destinations,
+		Anywhere:     *anywhere,
+		TopK:         *topK,
+		BatchBy:      *batchBy,
+		BatchSize:    *batchSize,
+		Budget:       *budget,
+		PlanSlack:    *slack,
+		GroundCost:   *groundCost,
+		Costs:        costs,
+		CostTable:    costTable,
+	}
+
+	var rec *util.RunRecorder
+	if *db != "" {
+		rec = beginRun(*db, "search", spec)
+		subscribers = append(subscribers, rec)
+		writeResults := engine.Checkpoint
+		engine.Checkpoint = func(r *util.SearchResult) {
+			writeResults(r)
+			rec.Checkpoint(r)
+		}
+	}
+	engine.Subscriber = subscribers
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := engine.Run(ctx, spec)
+	if err != nil {
+		fmt.Printf("search stopped early: %v\n", err)
+	}
+	if rec != nil {
+		if err := rec.Finish(ctx, result, err); err != nil {
+			fmt.Printf("err saving run: %v\n", err)
+		}
+		fmt.Printf("saved as run %s, see runs show %s\n", rec.ID(), shortID(rec.ID()))
+	}
+
+	fmt.Printf("%d api calls, %d destinations pruned part way, %d skipped on estimates\n", result.Calls, len(result.Pruned), len(result.Skipped))
+	if result.OutOfBudget {
+		fmt.Println("ran out of -budget before everything was searched")
+	}
+	fmt.Printf("RESULTS: cheapest trip: [ %s ] cheapest cost: [ %f ] \n", result.CheapestKey, result.Cheapest)
+	if trip, ok := result.Trips[result.CheapestKey]; ok {
+		util.IterTripsAndPrint([]*util.Trip{trip})
+	}
+
+	report := util.NewReport(travelers, result.Trips, *outboundDate, *inboundDate)
+	if notifier != nil {
+		runID := ""
+		if rec != nil {
+			runID = rec.ID()
+		}
+		// ctx may be done already if the search was interrupted, the
+		// results are still worth sending
+		n := util.NewResultsNotification(report, runID, util.FinishedStatus(ctx, err), *notifyTop)
+		sendCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := notifier.Notify(sendCtx, n); err != nil {
+			fmt.Printf("err sending results: %v\n", err)
+		}
+		cancel()
+	}
 
-	travelers := map[string]*util.Traveler{
+	if *reportFormat != "" {
+		if err := writeReport(*reportFormat, *reportOut, report); err != nil {
+			fmt.Printf("err writing report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// newNotifier is every -notify url as one notifier, nil if there aren't any
+func newNotifier(urls []string) util.Notifier {
+	if len(urls) == 0 {
+		return nil
+	}
+	notifiers := util.Notifiers{}
+	for _, u := range urls {
+		n, err := util.NewNotifier(u)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(2)
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers
+}
+
+// loadCosts reads a -costs table. The path comes back absolute for the run to
+// keep, so watch finds it from wherever it runs
+func loadCosts(path string) ([]util.CostComponent, string) {
+	if path == "" {
+		return nil, ""
+	}
+	table, err := util.LoadCostTable(path)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return table.Components(), path
+}
+
+// beginRun starts keeping a run in the store at path
+func beginRun(path, command string, spec *util.SearchSpec) *util.RunRecorder {
+	store, err := util.OpenStore(path)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	rec, err := store.Begin("", command, spec)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return rec
+}
+
+func writeReport(format, path string, report *util.Report) error {
+	if path == "" {
+		return util.WriteReport(os.Stdout, format, report)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := util.WriteReport(f, format, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// everybody on the trip and where they fly from
+func defaultTravelers() map[string]*util.Traveler {
+	return map[string]*util.Traveler{
 		"andrew": util.NewTraveler("andrew", "DEN-sky"),
 		"graham": util.NewTraveler("graham", "DEN-sky"),
 		"john":   util.NewTraveler("john", "PIT-sky"),
@@ -54,114 +316,73 @@ func main() {
 		"zta":    util.NewTraveler("zta", "PHX-sky"),
 		"sow":    util.NewTraveler("sow", "JFK-sky"),
 	}
+}
 
-	outboundDate := "2020-01-01"
-	inboundDate := "2020-01-05"
-
-	// rate limit thread
-	requests := 0
-	var rateLimitExceeded bool
-	go func() {
-		minuteTicker := time.NewTicker(60 * time.Second)
-		for {
-			select {
-			case _ = <-minuteTicker.C:
-				requests = 0
-				rateLimitExceeded = false
-			case _ = <-time.After(100 * time.Millisecond):
-				if requests > 60 { // what is the fucking rate limit
-					rateLimitExceeded = true
-				}
-			}
-		}
-	}()
+// newProvider is the real api, or the saved responses in fixtures if set
+func newProvider(airports, fixtures, apiKey string) util.SkyScanner {
+	var ss util.SkyScanner
+	var err error
+	if fixtures != "" {
+		ss, err = util.NewFixtureSkyScanner(airports, fixtures)
+	} else {
+		ss, err = util.NewSkyScanner(airports, apiConfig(apiKey))
+	}
+	if err != nil {
+		fmt.Printf("err instantiating API client: %v\n", err)
+		os.Exit(1)
+	}
+	return ss
+}
 
-	// todo: most expensivest
-	//
-	var cheapest float64
-	var tripCostTotal float64
-	var cheapestTripKey string
-	cheapest = 99999999.00 // arbitrary big number
-	itineraries := map[string][]*util.PricingOption{}
-	for _, location := range filtered.Places {
-		tripCostTotal = 0
-		fmt.Println("initiating session for", location)
-
-		for _, traveler := range travelers {
-			var bestPrice *util.PricingOption
-			// person already lives here
-			if traveler.LocationCode == location.PlaceID {
-				bestPrice = &util.PricingOption{
-					Price:    0,
-					Deeplink: "This person already lives here",
-				}
-				break
-			}
-			fmt.Println("searching flights for", traveler.Name)
-			attempts := 0
-			noLegsFound := 0
-			for {
-				if rateLimitExceeded {
-					fmt.Println("rate limit exceeded, sleeping 1s")
-					time.Sleep(1 * time.Second)
-					continue
-				}
-				attempts++
-				if attempts > 10 {
-					fmt.Println("exceeded 10 attempts. Skipping this destination")
-					break
-				}
-				sessionKey, err := ss.InitSession(outboundDate, inboundDate, traveler.LocationCode, location.PlaceID)
-				requests++
-				if err != nil {
-					// try again. this shouldn't happen
-					fmt.Println("error initiating session:", err.Error())
-					continue
-				}
-
-				bestPrice, err = ss.PollSession(sessionKey, traveler.LocationCode, location.PlaceID, location.PlaceName)
-				requests++
-				if err != nil {
-					// try again. fake error
-					if strings.Contains(err.Error(), "Rate limit has been exceeded") {
-						continue
-					} else if strings.Contains(err.Error(), "no pricing option") {
-						fmt.Println("no legs found, trying again....")
-						noLegsFound++
-						if noLegsFound > 5 {
-							fmt.Println("no legs found limit exceeded; breaking")
-							break
-						}
-					} else {
-						fmt.Println("error polling session:", err.Error())
-						break
-					}
-				}
-				if bestPrice != nil {
-					fmt.Printf("best price: %f to %s\n", bestPrice.Price, bestPrice.Deeplink)
-
-					tripCostTotal += bestPrice.Price
-					itineraries[location.PlaceID] = append(itineraries[location.PlaceID], bestPrice)
-					break
-				}
-				// ease up on rate limiting for testing
-				time.Sleep(1 * time.Second)
-			}
-		}
+// apiConfig is the client config for -api-key, falling back to $RAPIDAPI_KEY
+func apiConfig(apiKey string) util.Config {
+	if apiKey == "" {
+		apiKey = os.Getenv(util.APIKeyEnv)
+	}
+	return util.Config{APIKey: apiKey}
+}
+
+func newEngine(ss util.SkyScanner, fixtures string) *util.Engine {
+	limiter := util.NewRateLimiter(util.DefaultRequestsPerMinute)
+	if fixtures != "" {
+		// no api on the other end to be polite to
+		limiter = util.NewRateLimiter(0)
+	}
+	engine := util.NewEngine(ss, limiter)
+	if fixtures != "" {
+		engine.RetryDelay = 0
+	}
+	return engine
+}
 
-		// determine if it was cheaper and save the key
-		fmt.Println("comparing:", tripCostTotal, cheapest)
-		if tripCostTotal != 0 && tripCostTotal < cheapest {
-			cheapestTripKey = location.PlaceID
-			cheapest = tripCostTotal
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
 		}
+	}
+	return false
+}
 
-		// sort and write EVERY time bc this thing takes forever, and a write is cheap
-		util.WriteResultsToFile(travelers, itineraries)
+func homeAirports(travelers map[string]*util.Traveler) map[string]bool {
+	homes := map[string]bool{}
+	for _, traveler := range travelers {
+		homes[traveler.LocationCode] = true
 	}
+	return homes
+}
 
-	fmt.Printf("RESULTS: cheapest trip: [ %s ] cheapest cost: [ %f ] \n", cheapestTripKey, cheapest)
-	for i, p := range itineraries[cheapestTripKey] {
-		fmt.Printf("Itinerary %d: %+v\n", i, p)
+// great circle miles from each traveler's home to the destination, when we
+// have coords for both
+func printTravelerMiles(catalog *util.Catalog, travelers map[string]*util.Traveler, destination util.Location) {
+	if !destination.HasCoords() {
+		return
+	}
+	for _, traveler := range travelers {
+		home, ok := catalog.Place(traveler.LocationCode)
+		if !ok || !home.HasCoords() {
+			continue
+		}
+		fmt.Printf("\t%s: %.0f mi from %s\n", traveler.Name, util.GreatCircleMiles(home, destination), traveler.LocationCode)
 	}
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+const progressWidth = 30
+
+// progressBar draws a search's events as one line on a terminal:
+//
+//	[##########--------------------] 12/36 destinations, eta 4m10s, best Cancun $1843.20
+type progressBar struct {
+	w       io.Writer
+	started time.Time
+	last    util.Event
+	best    string
+	note    string
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w, started: time.Now()}
+}
+
+// isTerminal is true when f is a terminal and not a pipe or a file, near
+// enough without pulling in x/term
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progressBar) Event(ev util.Event) {
+	p.last = ev
+	p.note = ""
+	switch ev.Type {
+	case util.EventNewBest:
+		p.best = fmt.Sprintf("best %s $%.2f", ev.PlaceName, ev.Total)
+	case util.EventRateLimitPause:
+		p.note = fmt.Sprintf("rate limited, waiting %s", ev.Wait().Round(time.Second))
+	case util.EventDestinationStarted:
+		p.note = ev.PlaceName
+	}
+	p.draw()
+	if ev.Type == util.EventSearchDone {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *progressBar) draw() {
+	ev := p.last
+	filled := 0
+	if ev.Planned > 0 {
+		filled = progressWidth * ev.Searched / ev.Planned
+		if filled > progressWidth {
+			filled = progressWidth
+		}
+	}
+	parts := []string{fmt.Sprintf("[%s%s] %d/%d destinations",
+		strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled), ev.Searched, ev.Planned)}
+	if eta, ok := p.eta(); ok {
+		parts = append(parts, "eta "+eta.String())
+	}
+	if p.best != "" {
+		parts = append(parts, p.best)
+	}
+	if p.note != "" {
+		parts = append(parts, p.note)
+	}
+	// back to the start of the line and clear it
+	fmt.Fprintf(p.w, "\r\x1b[K%s", strings.Join(parts, ", "))
+}
+
+// eta assumes the rest go as fast as the ones so far did
+func (p *progressBar) eta() (time.Duration, bool) {
+	ev := p.last
+	if ev.Searched == 0 || ev.Searched >= ev.Planned {
+		return 0, false
+	}
+	per := time.Since(p.started) / time.Duration(ev.Searched)
+	return (per * time.Duration(ev.Planned-ev.Searched)).Round(time.Second), true
+}
@@ -1,9 +1,61 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/abgordon/flight-finder/util"
 )
 
+// prints the trips from the latest search, or the run given. runs are kept in
+// one place so this works from anywhere, unlike the results files
 func main() {
-	util.OutputResults()
+	db := flag.String("db", util.DefaultStorePath(), "where runs are kept")
+	flag.Parse()
+
+	store, err := util.OpenStore(*db)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	var run *util.Run
+	if flag.NArg() > 0 {
+		run, err = store.Run(flag.Arg(0))
+	} else {
+		var runs []*util.Run
+		runs, err = store.Runs()
+		if err == nil && len(runs) == 0 {
+			err = fmt.Errorf("no runs in %s yet", store.Path())
+		}
+		if err == nil {
+			run = runs[0]
+		}
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	trips, err := store.Trips(run.ID)
+	if err != nil {
+		fmt.Println("error reading results:", err.Error())
+		os.Exit(1)
+	}
+	viable, nonViable := []*util.Trip{}, []*util.Trip{}
+	for _, t := range trips {
+		if t.Complete() {
+			viable = append(viable, t)
+		} else {
+			nonViable = append(nonViable, t)
+		}
+	}
+
+	fmt.Printf("run %s, %s\n", run.ID, run.StartedAt.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("\n\n====================================\n===== VIABLE TRIPS\n====================================\n\n")
+	util.IterTripsAndPrint(viable)
+
+	fmt.Printf("\n\n====================================\n===== NON VIABLE TRIPS\n====================================\n\n")
+	util.IterTripsAndPrint(nonViable)
 }
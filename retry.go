@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// retry-failed goes back over the last search's results and re-queries only
+// the missing legs of the trips that could still come out cheapest
+func runRetryFailed(args []string) {
+	fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+	airports := fs.String("airports", defaultAirportsJSON, "path to the location catalog")
+	dir := fs.String("dir", ".", "where the results files are")
+	maxMissing := fs.Int("max-missing", 2, "only retry trips missing at most this many legs")
+	top := fs.Int("top", 10, "most trips to retry, 0 for all of them")
+	budget := fs.Int("budget", 0, "most api calls to spend, 0 is no limit")
+	dryRun := fs.Bool("dry-run", false, "list the legs that would be retried and exit")
+	fixtures := fs.String("fixtures", "", "answer every request from saved responses in this dir instead of the API")
+	apiKey := apiKeyFlag(fs)
+	costsPath := fs.String("costs", "", "csv or json of ground costs by city or country, to work the retried trips' totals out with. without it they keep the costs they had")
+	groundCost := fs.Float64("ground-cost", 0, "what it costs someone to get to another airport in their own city")
+	db := fs.String("db", util.DefaultStorePath(), "keep the retry as a run for runs list/show and watch. empty to not keep it")
+	fs.Parse(args)
+
+	viable, nonViable, err := util.ReadResults(*dir)
+	if err != nil {
+		fmt.Printf("err reading results: %v\n", err)
+		os.Exit(1)
+	}
+
+	retry := util.PromisingTrips(viable, nonViable, *maxMissing)
+	if *top > 0 && len(retry) > *top {
+		retry = retry[:*top]
+	}
+	for _, trip := range retry {
+		fmt.Printf("%s ($%.2f so far):\n", trip.Destination.PlaceID, trip.Cost())
+		for _, name := range trip.Missing {
+			f := trip.Failures[name]
+			fmt.Printf("  %s: %s, %d attempts\n", name, f.Reason, f.Attempts)
+		}
+	}
+	if *dryRun || len(retry) == 0 {
+		fmt.Printf("%d of %d non viable trips worth retrying\n", len(retry), len(nonViable))
+		return
+	}
+
+	ss := newProvider(*airports, *fixtures, *apiKey)
+	engine := newEngine(ss, *fixtures)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	costs, costTable := loadCosts(*costsPath)
+	spec := &util.SearchSpec{
+		Travelers:    defaultTravelers(),
+		OutboundDate: retry[0].Dates.Outbound,
+		InboundDate:  retry[0].Dates.Inbound,
+		Destinations: []util.Location{},
+		Budget:       *budget,
+		GroundCost:   *groundCost,
+		Costs:        costs,
+		CostTable:    costTable,
+	}
+	for _, trip := range retry {
+		spec.Destinations = append(spec.Destinations, trip.Destination)
+	}
+
+	var rec *util.RunRecorder
+	if *db != "" {
+		rec = beginRun(*db, "retry-failed", spec)
+		engine.Subscriber = rec
+	}
+
+	result, err := engine.RetryFailed(ctx, spec, retry)
+	if err != nil {
+		fmt.Printf("retry stopped early: %v\n", err)
+	}
+
+	// retried trips are the same pointers, so writing everything back
+	// picks them up
+	trips := map[string]*util.Trip{}
+	fixed := 0
+	for _, trip := range append(viable, nonViable...) {
+		trips[trip.Destination.PlaceID] = trip
+	}
+	for _, trip := range retry {
+		if trip.Complete() {
+			fixed++
+		}
+	}
+	util.WriteResultsToDir(*dir, trips)
+
+	if rec != nil {
+		// the run keeps every trip, not just the retried ones, so runs show
+		// and watch rank it like the search it picks up from
+		result.Trips = trips
+		for id, trip := range trips {
+			if trip.Complete() && trip.Cost() < result.Cheapest {
+				result.CheapestKey, result.Cheapest = id, trip.Cost()
+			}
+		}
+		// running out of -budget is a finished retry, same as a search
+		if err == util.ErrBudgetSpent {
+			err = nil
+		}
+		if err := rec.Finish(ctx, result, err); err != nil {
+			fmt.Printf("err saving run: %v\n", err)
+		}
+		fmt.Printf("saved as run %s, see runs show %s\n", rec.ID(), shortID(rec.ID()))
+	}
+	fmt.Printf("%d of %d retried trips are now viable\n", fixed, len(retry))
+}
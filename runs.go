@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// runs [list | show <id>] looks back over searches kept in the run store
+func runRuns(args []string) {
+	fs := flag.NewFlagSet("runs", flag.ExitOnError)
+	db := fs.String("db", util.DefaultStorePath(), "where runs are kept")
+	limit := fs.Int("limit", 20, "most runs for list, 0 for all of them")
+	top := fs.Int("top", 10, "most trips for show, 0 for all of them")
+	legs := fs.Bool("legs", false, "show also lists every leg searched, in order, with when")
+	fs.Parse(args)
+
+	usage := "usage: flight-finder runs [-db file] [list [-limit n] | show [-top n] [-legs] <id>]"
+	cmd := "list"
+	if fs.NArg() > 0 {
+		cmd = fs.Arg(0)
+		// flags can go after the command too
+		fs.Parse(fs.Args()[1:])
+	}
+	rest := fs.Args()
+
+	store, err := util.OpenStore(*db)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	switch cmd {
+	case "list":
+		listRuns(store, *limit)
+	case "show":
+		if len(rest) != 1 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		showRun(store, rest[0], *top, *legs)
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
+
+func listRuns(store *util.Store, limit int) {
+	runs, err := store.Runs()
+	if err != nil {
+		fmt.Printf("err reading runs: %v\n", err)
+		os.Exit(1)
+	}
+	if len(runs) == 0 {
+		fmt.Printf("no runs in %s yet\n", store.Path())
+		return
+	}
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	for _, run := range runs {
+		cheapest := "-"
+		if run.Cheapest != nil {
+			cheapest = fmt.Sprintf("%s $%.2f", run.Cheapest.PlaceName, run.Cheapest.Total)
+		}
+		fmt.Printf("%s  %s  %-12s  %-8s  %s to %s  %d people  %d/%d searched  %d calls  %s\n",
+			shortID(run.ID), run.StartedAt.Local().Format("2006-01-02 15:04"), run.Command, run.Status,
+			run.Spec.Outbound, run.Spec.Inbound, len(run.Spec.Travelers), run.Searched, run.Planned, run.Calls, cheapest)
+	}
+}
+
+func showRun(store *util.Store, id string, top int, showLegs bool) {
+	run, err := store.Run(id)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	trips, err := store.Trips(run.ID)
+	if err != nil {
+		fmt.Printf("err reading trips: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("run %s, %s %s\n", run.ID, run.Command, run.Status)
+	fmt.Printf("started %s", run.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	if run.FinishedAt != nil {
+		fmt.Printf(", took %s", run.FinishedAt.Sub(run.StartedAt).Round(time.Second))
+	}
+	fmt.Printf(", %d/%d destinations searched, %d api calls\n", run.Searched, run.Planned, run.Calls)
+	if run.Error != "" {
+		fmt.Printf("error: %s\n", run.Error)
+	}
+	names := []string{}
+	for name, home := range run.Spec.Travelers {
+		names = append(names, name+" ("+home+")")
+	}
+	sort.Strings(names)
+	fmt.Printf("%s to %s for %s\n", run.Spec.Outbound, run.Spec.Inbound, strings.Join(names, ", "))
+
+	// what the same trip cost last time it was searched
+	before := map[string]*util.Trip{}
+	prev, err := store.Previous(run)
+	if err != nil {
+		fmt.Printf("err reading runs: %v\n", err)
+		os.Exit(1)
+	}
+	if prev != nil {
+		prevTrips, err := store.Trips(prev.ID)
+		if err != nil {
+			fmt.Printf("err reading trips: %v\n", err)
+			os.Exit(1)
+		}
+		for _, t := range prevTrips {
+			before[t.Destination.PlaceID] = t
+		}
+		fmt.Printf("compared to run %s from %s\n", shortID(prev.ID), prev.StartedAt.Local().Format("2006-01-02 15:04"))
+	}
+
+	if top > 0 && len(trips) > top {
+		trips = trips[:top]
+	}
+	for i, trip := range trips {
+		fmt.Printf("\n%d. %s (%s): $%.2f", i+1, trip.Destination.PlaceName, trip.Destination.PlaceID, trip.Cost())
+		if !trip.Complete() {
+			fmt.Printf(" without %s", strings.Join(trip.Missing, ", "))
+		}
+		if was, ok := before[trip.Destination.PlaceID]; ok && was.Complete() == trip.Complete() {
+			fmt.Printf(" (%+.2f since then)", trip.Cost()-was.Cost())
+		}
+		fmt.Println()
+		for _, name := range trip.Travelers() {
+			leg := trip.Legs[name]
+			fmt.Printf("  %s: $%.2f %s -> %s\n", name, leg.Price, leg.From, leg.To)
+		}
+		for _, c := range trip.Costs {
+			fmt.Printf("  %s: $%.2f, %s\n", c.Name, c.Amount, c.Detail)
+		}
+	}
+
+	if !showLegs {
+		return
+	}
+	records, err := store.Legs(run.ID)
+	if err != nil {
+		fmt.Printf("err reading legs: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n%d legs searched:\n", len(records))
+	for _, leg := range records {
+		what := ""
+		switch {
+		case leg.Fare != nil:
+			what = fmt.Sprintf("$%.2f %s -> %s", leg.Fare.Price, leg.Fare.From, leg.Fare.To)
+		case leg.Failure != nil:
+			what = "no fare: " + string(leg.Failure.Reason)
+		}
+		fmt.Printf("  %s  %-12s %-10s %s\n", leg.Time.Local().Format("15:04:05"), leg.Destination, leg.Traveler, what)
+	}
+}
+
+// the first bit of a uuid is plenty to tell runs apart, and show takes it
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// serve runs the json api. every search started through it shares the one
+// rate limiter, so running a few at once doesn't get us throttled any faster
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	airports := fs.String("airports", defaultAirportsJSON, "path to the location catalog")
+	perMinute := fs.Int("rpm", util.DefaultRequestsPerMinute, "api requests a minute across every search")
+	fixtures := fs.String("fixtures", "", "answer every request from saved responses in this dir instead of the API")
+	apiKey := apiKeyFlag(fs)
+	notify := repeatFlag{}
+	fs.Var(&notify, "notify", "send each search's best trips here when it's over, repeatable: "+strings.Join(util.NotifierSchemes(), "|")+" urls")
+	notifyTop := fs.Int("notify-top", 5, "how many trips -notify sends")
+	db := fs.String("db", util.DefaultStorePath(), "keep every search here for runs list/show. empty to not keep them")
+	costsPath := fs.String("costs", "", "csv or json of lodging, airport transfer and daily spend by city or country, added to every trip's total")
+	fs.Parse(args)
+
+	ss := newProvider(*airports, *fixtures, *apiKey)
+	limiter := util.NewRateLimiter(*perMinute)
+	if *fixtures != "" {
+		// no api on the other end to be polite to
+		limiter = util.NewRateLimiter(0)
+	}
+
+	jobs := util.NewJobManager(ss, limiter)
+	jobs.Notifier = newNotifier(notify)
+	jobs.NotifyTop = *notifyTop
+	jobs.Costs, jobs.CostTable = loadCosts(*costsPath)
+	if *db != "" {
+		store, err := util.OpenStore(*db)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		jobs.Store = store
+	}
+	if *fixtures != "" {
+		jobs.Configure = func(e *util.Engine) {
+			e.RetryDelay = 0
+		}
+	}
+
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: util.NewServer(jobs),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		// jobs first: their event streams only end once they do, and the
+		// server waits on those. each gets its own time so a slow one doesn't
+		// leave the other none
+		jobsDone, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := jobs.Shutdown(jobsDone); err != nil {
+			fmt.Println("searches still running at exit, their runs are left as they were")
+		}
+		srvDone, cancelSrv := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelSrv()
+		srv.Shutdown(srvDone)
+	}()
+
+	fmt.Printf("listening on http://%s\n", *addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("err serving: %v\n", err)
+		os.Exit(1)
+	}
+	<-stopped
+}
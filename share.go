@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// share works out who pays what for the picked trip under one of the cost
+// sharing policies, and the payments that settle it, since everybody books
+// their own flight
+func runShare(args []string) {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	db := fs.String("db", util.DefaultStorePath(), "where runs are kept")
+	runID := fs.String("run", "", "run the trip is from, default the latest search")
+	destination := fs.String("destination", "", "PlaceId or name of the picked destination, default the run's best trip")
+	policy := fs.String("policy", "equal", "how to split it: "+strings.Join(util.SharePolicies(), "|"))
+	most := fs.Float64("cap", 0, "for capped, the most anybody pays")
+	weights := fs.String("weights", "", "for weighted, ie aj=2,dan=1.5, anybody left out is 1")
+	fs.Parse(args)
+
+	w, err := util.ParseWeights(*weights)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(2)
+	}
+	p, err := util.NewSharePolicy(*policy, *most, w)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(2)
+	}
+
+	store, err := util.OpenStore(*db)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	run := pickRun(store, *runID)
+	trips, err := store.Trips(run.ID)
+	if err != nil {
+		fmt.Printf("err reading trips: %v\n", err)
+		os.Exit(1)
+	}
+	trip := pickTrip(trips, *destination)
+	if trip == nil {
+		fmt.Printf("run %s has no trip to %q\n", shortID(run.ID), *destination)
+		os.Exit(1)
+	}
+
+	cs, err := util.ShareCosts(trip, p)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("%s (%s), $%.2f for everybody, from run %s\n", cs.PlaceName, cs.Destination, cs.Total, shortID(run.ID))
+	fmt.Printf("%s: %s\n\n", cs.Policy, cs.Describe)
+	fmt.Printf("  %-12s %10s %10s %10s\n", "", "fare", "pays", "net")
+	for _, ps := range cs.People {
+		fmt.Printf("  %-12s %10.2f %10.2f %+10.2f\n", ps.Traveler, ps.Fare, ps.Pays, ps.Net)
+	}
+	if len(cs.Transfers) == 0 {
+		fmt.Println("\nnobody owes anybody")
+		return
+	}
+	fmt.Println("\nto settle up:")
+	for _, t := range cs.Transfers {
+		fmt.Printf("  %s pays %s $%.2f\n", t.From, t.To, t.Amount)
+	}
+}
@@ -0,0 +1,197 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AnywhereDestination is what the browse endpoints take for "everywhere"
+const AnywhereDestination = "anywhere"
+
+// Quote is a cached cheapest price out of the browse endpoints. It's cheap to
+// get (one call covers every destination) but it can be stale or missing, so
+// it's only good for deciding where to spend live searches
+type Quote struct {
+	Origin      string    `json:"origin"`
+	Destination string    `json:"destination"`
+	PlaceName   string    `json:"place_name"`
+	CityName    string    `json:"city_name"`
+	CountryName string    `json:"country_name"`
+	MinPrice    float64   `json:"min_price"`
+	Direct      bool      `json:"direct"`
+	QuotedAt    time.Time `json:"quoted_at"`
+}
+
+type browseResponse struct {
+	Quotes []struct {
+		MinPrice      float64 `json:"MinPrice"`
+		Direct        bool    `json:"Direct"`
+		QuoteDateTime string  `json:"QuoteDateTime"`
+		OutboundLeg   struct {
+			OriginID      int `json:"OriginId"`
+			DestinationID int `json:"DestinationId"`
+		} `json:"OutboundLeg"`
+	} `json:"Quotes"`
+	Places []struct {
+		PlaceID        int    `json:"PlaceId"`
+		SkyscannerCode string `json:"SkyscannerCode"`
+		Name           string `json:"Name"`
+		Type           string `json:"Type"`
+		CityName       string `json:"CityName"`
+		CountryName    string `json:"CountryName"`
+	} `json:"Places"`
+}
+
+// ParseBrowseQuotes turns a browsequotes response into the cheapest quote per
+// destination, keyed the same way as the catalog ("CUN-sky"). origin is the
+// place the search was made from
+func ParseBrowseQuotes(body []byte, origin string) ([]Quote, error) {
+	res := &browseResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, fmt.Errorf("error unmarshaling browse response: %s", err.Error())
+	}
+
+	type place struct {
+		id, name, city, country string
+	}
+	places := map[int]place{}
+	for _, p := range res.Places {
+		if p.SkyscannerCode == "" {
+			continue
+		}
+		places[p.PlaceID] = place{
+			id:      strings.ToUpper(p.SkyscannerCode) + "-sky",
+			name:    p.Name,
+			city:    p.CityName,
+			country: p.CountryName,
+		}
+	}
+
+	best := map[string]Quote{}
+	for _, q := range res.Quotes {
+		dst, ok := places[q.OutboundLeg.DestinationID]
+		if !ok || q.MinPrice <= 0 || dst.id == origin {
+			continue
+		}
+		if prev, ok := best[dst.id]; ok && prev.MinPrice <= q.MinPrice {
+			continue
+		}
+		quotedAt, _ := time.Parse("2006-01-02T15:04:05", q.QuoteDateTime)
+		best[dst.id] = Quote{
+			Origin:      origin,
+			Destination: dst.id,
+			PlaceName:   dst.name,
+			CityName:    dst.city,
+			CountryName: dst.country,
+			MinPrice:    q.MinPrice,
+			Direct:      q.Direct,
+			QuotedAt:    quotedAt,
+		}
+	}
+
+	quotes := make([]Quote, 0, len(best))
+	for _, q := range best {
+		quotes = append(quotes, q)
+	}
+	sort.Slice(quotes, func(i, j int) bool {
+		return quotes[i].MinPrice < quotes[j].MinPrice
+	})
+
+	return quotes, nil
+}
+
+// Estimate is a destination's rough group total from cached quotes
+type Estimate struct {
+	Destination Location           `json:"destination"`
+	Total       float64            `json:"total"`
+	Fares       map[string]float64 `json:"fares"`
+	Missing     []string           `json:"missing"`
+}
+
+// EstimateDestinations sums cached quotes per destination across travelers.
+// quotes is keyed by origin. A traveler with no quote to a destination is
+// listed as missing rather than counted as free, unless they live there: then
+// it's their LocalFare, same as the search would price it. When only is non
+// empty the estimates are limited to those places, otherwise anything quoted
+// counts and places we don't have in the catalog are made up from the quote
+func EstimateDestinations(travelers map[string]*Traveler, quotes map[string][]Quote, catalog *Catalog, only []Location, groundCost float64) []*Estimate {
+	allowed := map[string]Location{}
+	for _, l := range only {
+		allowed[l.PlaceID] = l
+	}
+
+	estimates := map[string]*Estimate{}
+	for _, traveler := range travelers {
+		for _, q := range quotes[traveler.LocationCode] {
+			dst, ok := allowed[q.Destination]
+			if len(only) > 0 && !ok {
+				continue
+			}
+			if !ok {
+				dst, ok = catalog.Place(q.Destination)
+			}
+			if !ok {
+				dst = Location{
+					PlaceID:     q.Destination,
+					PlaceName:   q.PlaceName,
+					CountryName: q.CountryName,
+				}
+			}
+
+			e, ok := estimates[dst.PlaceID]
+			if !ok {
+				e = &Estimate{
+					Destination: dst,
+					Fares:       map[string]float64{},
+				}
+				estimates[dst.PlaceID] = e
+			}
+			// a quote to somewhere you can get to on the ground doesn't count,
+			// they're priced below like the search would
+			if _, local := LocalFare(catalog, traveler, dst, groundCost); local {
+				continue
+			}
+			e.Fares[traveler.Name] = q.MinPrice
+			e.Total += q.MinPrice
+		}
+	}
+
+	res := make([]*Estimate, 0, len(estimates))
+	for _, e := range estimates {
+		e.Missing = []string{}
+		for _, traveler := range travelers {
+			if _, ok := e.Fares[traveler.Name]; ok {
+				continue
+			}
+			// locals get there on the ground, free or for the ground cost
+			if fare, ok := LocalFare(catalog, traveler, e.Destination, groundCost); ok {
+				e.Fares[traveler.Name] = fare.Price
+				e.Total += fare.Price
+				continue
+			}
+			e.Missing = append(e.Missing, traveler.Name)
+		}
+		sort.Strings(e.Missing)
+		res = append(res, e)
+	}
+
+	sortEstimates(res)
+	return res
+}
+
+// complete estimates first, cheapest first. a partial total is an undercount
+// so it can't be compared straight against a complete one
+func sortEstimates(res []*Estimate) {
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i].Missing) != len(res[j].Missing) {
+			return len(res[i].Missing) < len(res[j].Missing)
+		}
+		if res[i].Total != res[j].Total {
+			return res[i].Total < res[j].Total
+		}
+		return res[i].Destination.PlaceID < res[j].Destination.PlaceID
+	})
+}
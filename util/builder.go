@@ -0,0 +1,247 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CatalogBuilder turns the hand curated list of place names (util/airports)
+// into a location catalog by asking the provider about each one. Progress is
+// saved after every name, so a build that gets killed or rate limited into the
+// ground picks up where it left off next time
+type CatalogBuilder struct {
+	Provider SkyScanner
+
+	// NamesPath is the list of names to look up, one per line. blank lines
+	// and # comments are skipped
+	NamesPath string
+	// OutPath is where the catalog goes. the progress file sits next to it
+	OutPath string
+	// Delay between lookups, the autosuggest endpoint gets cranky otherwise
+	Delay time.Duration
+	// Fresh throws away any previous progress instead of resuming
+	Fresh bool
+	// Source is recorded on the catalog and every entry, ie "rapidapi autosuggest"
+	Source string
+}
+
+// BuildReport is what a build did. Unresolved is names the provider had
+// nothing for, Failed is names it errored on, which get tried again next run
+type BuildReport struct {
+	Names      int      `json:"names"`
+	Looked     int      `json:"looked"`
+	Resumed    int      `json:"resumed"`
+	Added      int      `json:"added"`
+	Duplicates int      `json:"duplicates"`
+	Unresolved []string `json:"unresolved"`
+	Failed     []string `json:"failed"`
+}
+
+// buildProgress is persisted next to the output so a build can resume
+type buildProgress struct {
+	Done       []string `json:"done"`
+	Unresolved []string `json:"unresolved"`
+}
+
+func (b *CatalogBuilder) progressPath() string {
+	return b.OutPath + ".progress"
+}
+
+// Build looks up every name not already done. It stops early, with the work so
+// far saved, when ctx is cancelled
+func (b *CatalogBuilder) Build(ctx context.Context) (*BuildReport, error) {
+	f, err := os.Open(b.NamesPath)
+	if err != nil {
+		return nil, fmt.Errorf("err opening names: %s", err.Error())
+	}
+	names, err := ReadLocationNames(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	places := []Location{}
+	progress := &buildProgress{}
+	if !b.Fresh {
+		places, progress, err = b.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &BuildReport{
+		Names:      len(names),
+		Unresolved: []string{},
+		Failed:     []string{},
+	}
+
+	seen := map[string]bool{}
+	for _, l := range places {
+		seen[l.PlaceID] = true
+	}
+	done := map[string]bool{}
+	for _, n := range progress.Done {
+		done[n] = true
+	}
+
+	for _, name := range names {
+		if done[name] {
+			report.Resumed++
+			continue
+		}
+
+		wait := time.Duration(0)
+		if report.Looked > 0 {
+			wait = b.Delay
+		}
+		if err := backOff(ctx, wait); err != nil {
+			report.Unresolved = append(report.Unresolved, progress.Unresolved...)
+			return report, err
+		}
+		report.Looked++
+
+		found, err := b.Provider.GetLocation(name)
+		if err != nil {
+			// rate limits and the like aren't an answer, leave it for next run
+			fmt.Printf("[ ERROR ] could not find location %s: %v\n", name, err)
+			report.Failed = append(report.Failed, name)
+			continue
+		}
+		resolved := false
+		for _, l := range found {
+			if l.PlaceID == "" {
+				continue
+			}
+			resolved = true
+			if seen[l.PlaceID] {
+				report.Duplicates++
+				continue
+			}
+			seen[l.PlaceID] = true
+			l.Provenance = &Provenance{
+				Source:  b.Source,
+				Query:   name,
+				AddedAt: time.Now().UTC(),
+			}
+			places = append(places, l)
+			report.Added++
+		}
+		if !resolved {
+			progress.Unresolved = append(progress.Unresolved, name)
+		}
+
+		progress.Done = append(progress.Done, name)
+		if err := b.save(places, progress); err != nil {
+			return report, err
+		}
+	}
+
+	report.Unresolved = append(report.Unresolved, progress.Unresolved...)
+	return report, nil
+}
+
+func (b *CatalogBuilder) load() ([]Location, *buildProgress, error) {
+	progress := &buildProgress{}
+	raw, err := ioutil.ReadFile(b.progressPath())
+	if os.IsNotExist(err) {
+		// nothing to resume, so every name gets looked up again and the
+		// first save replaces whatever catalog is already at OutPath
+		return []Location{}, progress, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(raw, progress); err != nil {
+		return nil, nil, fmt.Errorf("err reading build progress: %s", err.Error())
+	}
+
+	catalog, err := LoadCatalog(b.OutPath)
+	if os.IsNotExist(err) {
+		return []Location{}, &buildProgress{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return catalog.List(), progress, nil
+}
+
+func (b *CatalogBuilder) save(places []Location, progress *buildProgress) error {
+	catalog := NewCatalog(places)
+	catalog.Meta.BuiltAt = time.Now().UTC()
+	catalog.Meta.Source = fmt.Sprintf("%s from %s", b.Source, filepath.Base(b.NamesPath))
+	if err := WriteCatalog(b.OutPath, catalog); err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.progressPath(), raw)
+}
+
+// Finish removes the progress file once a build has gone all the way through
+func (b *CatalogBuilder) Finish() error {
+	err := os.Remove(b.progressPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ReadLocationNames reads a names file: one name per line, trimmed, blank
+// lines and # comments skipped, and repeats dropped
+func ReadLocationNames(r io.Reader) ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		key := FoldName(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("err reading names: %s", err.Error())
+	}
+
+	return names, nil
+}
+
+// write to a temp file and rename over, so a kill mid write can't leave half a
+// file behind
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lookupProvider answers GetLocation out of a map, erroring on names in errs
+type lookupProvider struct {
+	SkyScanner
+	answers map[string][]Location
+	errs    map[string]bool
+}
+
+func (p *lookupProvider) GetLocation(name string) ([]Location, error) {
+	if p.errs[name] {
+		return nil, fmt.Errorf("rate limited")
+	}
+	return p.answers[name], nil
+}
+
+func TestBuildRetriesFailedLookups(t *testing.T) {
+	dir := t.TempDir()
+	names := filepath.Join(dir, "names")
+	if err := ioutil.WriteFile(names, []byte("denver\ncancun\nnowhere\nblank\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	provider := &lookupProvider{
+		answers: map[string][]Location{
+			"denver": {{PlaceID: "DEN-sky", PlaceName: "Denver International"}},
+			"cancun": {{PlaceID: "CUN-sky", PlaceName: "Cancún"}},
+			"blank":  {{PlaceName: "no id"}},
+		},
+		errs: map[string]bool{"cancun": true},
+	}
+	b := &CatalogBuilder{Provider: provider, NamesPath: names, OutPath: filepath.Join(dir, "airports.json"), Source: "test"}
+
+	report, err := b.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Added != 1 || report.Duplicates != 0 {
+		t.Errorf("first run: added %d, duplicates %d, want 1 and 0", report.Added, report.Duplicates)
+	}
+	if len(report.Failed) != 1 || report.Failed[0] != "cancun" {
+		t.Errorf("first run: failed %v, want [cancun]", report.Failed)
+	}
+	if len(report.Unresolved) != 2 || report.Unresolved[0] != "nowhere" || report.Unresolved[1] != "blank" {
+		t.Errorf("first run: unresolved %v, want [nowhere blank]", report.Unresolved)
+	}
+
+	// the rate limit's over, only cancun should get looked up again
+	provider.errs = nil
+	report, err = b.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Looked != 1 || report.Resumed != 3 || report.Added != 1 || len(report.Failed) != 0 {
+		t.Errorf("second run: %+v, want 1 looked, 3 resumed, 1 added, none failed", report)
+	}
+	catalog, err := LoadCatalog(b.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if catalog.Len() != 2 {
+		t.Errorf("catalog has %d places, want 2", catalog.Len())
+	}
+}
+
+func TestBuildStopsDuringTheDelay(t *testing.T) {
+	dir := t.TempDir()
+	names := filepath.Join(dir, "names")
+	if err := ioutil.WriteFile(names, []byte("denver\ncancun\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	provider := &lookupProvider{answers: map[string][]Location{
+		"denver": {{PlaceID: "DEN-sky", PlaceName: "Denver International"}},
+		"cancun": {{PlaceID: "CUN-sky", PlaceName: "Cancún"}},
+	}}
+	b := &CatalogBuilder{Provider: provider, NamesPath: names, OutPath: filepath.Join(dir, "airports.json"), Source: "test", Delay: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	report, err := b.Build(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the deadline", err)
+	}
+	if time.Since(began) > 5*time.Second {
+		t.Errorf("took %v to notice the context was done", time.Since(began))
+	}
+	// denver went in before the wait, cancun is left for next time
+	if report.Looked != 1 || report.Added != 1 {
+		t.Errorf("got %+v, want denver looked up and added", report)
+	}
+}
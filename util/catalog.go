@@ -0,0 +1,342 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CatalogSchemaVersion is bumped whenever the catalog file changes shape.
+// Files from before there was a version load as 0
+const CatalogSchemaVersion = 1
+
+// CatalogMeta says what a catalog file is and how it got made
+type CatalogMeta struct {
+	SchemaVersion int
+	BuiltAt       time.Time
+	Source        string
+}
+
+// Catalog is the local copy of every place we know about, indexed the ways we
+// actually look them up. It's read only once built, so it's safe to share
+// between the CLI and the search engine
+type Catalog struct {
+	Meta CatalogMeta
+
+	places []Location
+	folded []string // folded PlaceName, same order as places
+
+	byPlace   map[string]int
+	byCity    map[string][]int
+	byCountry map[string][]int
+	byRegion  map[string][]int
+}
+
+// NewCatalog indexes places. Duplicate PlaceIDs keep the first one seen
+func NewCatalog(places []Location) *Catalog {
+	c := &Catalog{
+		Meta: CatalogMeta{
+			SchemaVersion: CatalogSchemaVersion,
+		},
+		byPlace:   map[string]int{},
+		byCity:    map[string][]int{},
+		byCountry: map[string][]int{},
+		byRegion:  map[string][]int{},
+	}
+
+	for _, l := range places {
+		if _, ok := c.byPlace[l.PlaceID]; ok {
+			continue
+		}
+
+		i := len(c.places)
+		c.places = append(c.places, l)
+		c.folded = append(c.folded, FoldName(l.PlaceName))
+
+		c.byPlace[l.PlaceID] = i
+		if l.CityID != "" {
+			c.byCity[l.CityID] = append(c.byCity[l.CityID], i)
+		}
+		if l.CountryID != "" {
+			c.byCountry[l.CountryID] = append(c.byCountry[l.CountryID], i)
+		}
+		if l.RegionID != "" {
+			c.byRegion[l.RegionID] = append(c.byRegion[l.RegionID], i)
+		}
+	}
+
+	return c
+}
+
+// LoadCatalog reads a catalog out of an airports.json style file
+func LoadCatalog(path string) (*Catalog, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := &LocationWrapper{}
+	err = json.Unmarshal(b, locations)
+	if err != nil {
+		return nil, fmt.Errorf("err reading catalog %s: %s", path, err.Error())
+	}
+
+	if locations.SchemaVersion > CatalogSchemaVersion {
+		return nil, fmt.Errorf("catalog %s is schema version %d, this build only knows up to %d", path, locations.SchemaVersion, CatalogSchemaVersion)
+	}
+
+	c := NewCatalog(locations.Places)
+	c.Meta = CatalogMeta{
+		SchemaVersion: locations.SchemaVersion,
+		BuiltAt:       locations.BuiltAt,
+		Source:        locations.Source,
+	}
+	return c, nil
+}
+
+// withPlaces is a new catalog over places that keeps our meta
+func (c *Catalog) withPlaces(places []Location) *Catalog {
+	res := NewCatalog(places)
+	res.Meta = c.Meta
+	return res
+}
+
+func (c *Catalog) Len() int {
+	return len(c.places)
+}
+
+// List returns every place, in the order they were loaded
+func (c *Catalog) List() []Location {
+	res := make([]Location, len(c.places))
+	copy(res, c.places)
+	return res
+}
+
+func (c *Catalog) Place(placeID string) (Location, bool) {
+	i, ok := c.byPlace[placeID]
+	if !ok {
+		return Location{}, false
+	}
+	return c.places[i], true
+}
+
+func (c *Catalog) City(cityID string) []Location {
+	return c.pick(c.byCity[cityID])
+}
+
+func (c *Catalog) Region(regionID string) []Location {
+	return c.pick(c.byRegion[regionID])
+}
+
+// Country takes either a CountryID ("MX-sky") or a country name, which is
+// matched loosely ("mexico" finds "México")
+func (c *Catalog) Country(country string) []Location {
+	if idx, ok := c.byCountry[country]; ok {
+		return c.pick(idx)
+	}
+
+	want := FoldName(country)
+	res := []Location{}
+	for _, l := range c.places {
+		if FoldName(l.CountryName) == want {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// Lookup resolves whatever a person typed into places: a PlaceID, a CityID,
+// or failing that the best name matches
+func (c *Catalog) Lookup(query string) []Location {
+	if l, ok := c.Place(query); ok {
+		return []Location{l}
+	}
+	if ls := c.City(query); len(ls) > 0 {
+		return ls
+	}
+	return c.Search(query, 5)
+}
+
+type searchHit struct {
+	idx   int
+	score int
+}
+
+// Search is a fuzzy, case and accent insensitive name search: "cancun" finds
+// "Cancún". Best matches come first; limit <= 0 means no limit
+func (c *Catalog) Search(query string, limit int) []Location {
+	q := FoldName(query)
+	if q == "" {
+		return []Location{}
+	}
+
+	hits := []searchHit{}
+	for i, name := range c.folded {
+		score, ok := matchScore(q, name, FoldName(strings.TrimSuffix(c.places[i].PlaceID, "-sky")))
+		if ok {
+			hits = append(hits, searchHit{idx: i, score: score})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score < hits[j].score
+		}
+		return c.folded[hits[i].idx] < c.folded[hits[j].idx]
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	res := make([]Location, 0, len(hits))
+	for _, h := range hits {
+		res = append(res, c.places[h.idx])
+	}
+	return res
+}
+
+// lower is better. exact code/name, then prefixes, then substrings, then typos
+func matchScore(q, name, code string) (int, bool) {
+	switch {
+	case q == code || q == name:
+		return 0, true
+	case strings.HasPrefix(name, q):
+		return 1, true
+	}
+
+	words := strings.Fields(name)
+	for _, w := range words {
+		if strings.HasPrefix(w, q) {
+			return 2, true
+		}
+	}
+
+	if strings.Contains(name, q) {
+		return 3, true
+	}
+
+	// allow roughly one typo per four letters
+	maxDist := len(q) / 4
+	if maxDist == 0 {
+		return 0, false
+	}
+	best := -1
+	for _, w := range append(words, name) {
+		if d := editDistance(q, w); d <= maxDist && (best < 0 || d < best) {
+			best = d
+		}
+	}
+	if best >= 0 {
+		return 4 + best, true
+	}
+
+	return 0, false
+}
+
+func (c *Catalog) pick(idx []int) []Location {
+	res := make([]Location, 0, len(idx))
+	for _, i := range idx {
+		res = append(res, c.places[i])
+	}
+	return res
+}
+
+// accented latin letters we see in place names, mapped to plain ascii
+var foldTable = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ı': "i",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'œ': "oe", 'ß': "ss", 'ś': "s", 'š': "s", 'ş': "s",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ž': "z", 'ź': "z", 'ż': "z", 'ł': "l", 'đ': "d", 'ř': "r", 'ť': "t",
+}
+
+// FoldName normalizes a name for comparison: lowercased, accents stripped,
+// punctuation turned into single spaces
+func FoldName(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if f, ok := foldTable[r]; ok {
+			b.WriteString(f)
+			space = false
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+			continue
+		}
+		if !space && b.Len() > 0 {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// plain levenshtein, names are short
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// WriteCatalog saves the catalog in the same shape LoadCatalog reads. It's
+// always written as the current schema version, and stamped with the time if
+// nobody set a build time
+func WriteCatalog(path string, c *Catalog) error {
+	builtAt := c.Meta.BuiltAt
+	if builtAt.IsZero() {
+		builtAt = time.Now().UTC()
+	}
+
+	b, err := json.MarshalIndent(&LocationWrapper{
+		SchemaVersion: CatalogSchemaVersion,
+		BuiltAt:       builtAt,
+		Source:        c.Meta.Source,
+		Places:        c.List(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("err marshaling catalog: %s", err.Error())
+	}
+
+	err = writeFileAtomic(path, b)
+	if err != nil {
+		return fmt.Errorf("err writing catalog %s: %s", path, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func testCatalog() *Catalog {
+	return NewCatalog([]Location{
+		{PlaceID: "CUN-sky", PlaceName: "Cancún International", CityID: "CUNA-sky", CountryID: "MX-sky", CountryName: "México"},
+		{PlaceID: "MEX-sky", PlaceName: "Mexico City Juarez International", CityID: "MEXA-sky", CountryID: "MX-sky", CountryName: "México"},
+		{PlaceID: "DEN-sky", PlaceName: "Denver International", CityID: "DENA-sky", RegionID: "CO-sky", CountryID: "US-sky", CountryName: "United States"},
+		{PlaceID: "COS-sky", PlaceName: "Colorado Springs", CityID: "COSA-sky", RegionID: "CO-sky", CountryID: "US-sky", CountryName: "United States"},
+		// a second DEN-sky is dropped
+		{PlaceID: "DEN-sky", PlaceName: "Stapleton", CityID: "DENA-sky", RegionID: "CO-sky", CountryID: "US-sky"},
+	})
+}
+
+// placeIDs is the PlaceIDs of ls, comma separated
+func placeIDs(ls []Location) string {
+	res := []string{}
+	for _, l := range ls {
+		res = append(res, l.PlaceID)
+	}
+	return strings.Join(res, ",")
+}
+
+func TestFoldName(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"Cancún", "cancun"},
+		{"ZÜRICH", "zurich"},
+		{"  São Paulo–Guarulhos ", "sao paulo guarulhos"},
+		{"Kraków (Balice)", "krakow balice"},
+		{"Straße", "strasse"},
+		{"--", ""},
+	}
+	for _, c := range cases {
+		if got := FoldName(c.in); got != c.want {
+			t.Errorf("FoldName(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestCatalogSearch(t *testing.T) {
+	c := testCatalog()
+	cases := []struct {
+		query string
+		limit int
+		want  string
+	}{
+		// accents and case don't matter either way round
+		{"cancun", 0, "CUN-sky"},
+		{"CANCÚN", 0, "CUN-sky"},
+		// the code is an exact match
+		{"den", 0, "DEN-sky"},
+		// start of a word
+		{"springs", 0, "COS-sky"},
+		// one letter off
+		{"cancum", 0, "CUN-sky"},
+		// all three tie, so alphabetical, and the limit cuts Mexico City
+		{"international", 2, "CUN-sky,DEN-sky"},
+		{"nowhere", 0, ""},
+		{"", 0, ""},
+	}
+	for _, tc := range cases {
+		if got := placeIDs(c.Search(tc.query, tc.limit)); got != tc.want {
+			t.Errorf("Search(%q, %d) = %s, want %s", tc.query, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestCatalogIndexes(t *testing.T) {
+	c := testCatalog()
+	if c.Len() != 4 {
+		t.Errorf("%d places, want the duplicate DEN-sky dropped", c.Len())
+	}
+	if l, ok := c.Place("DEN-sky"); !ok || l.PlaceName != "Denver International" {
+		t.Errorf("DEN-sky is %+v, want the first one", l)
+	}
+	if _, ok := c.Place("XXX-sky"); ok {
+		t.Errorf("found a place that isn't there")
+	}
+
+	cases := []struct {
+		name string
+		got  []Location
+		want string
+	}{
+		{"city", c.City("DENA-sky"), "DEN-sky"},
+		{"region", c.Region("CO-sky"), "DEN-sky,COS-sky"},
+		{"country id", c.Country("MX-sky"), "CUN-sky,MEX-sky"},
+		{"country name", c.Country("mexico"), "CUN-sky,MEX-sky"},
+		{"unknown city", c.City("XXXA-sky"), ""},
+		{"lookup place", c.Lookup("COS-sky"), "COS-sky"},
+		{"lookup city", c.Lookup("CUNA-sky"), "CUN-sky"},
+		{"lookup name", c.Lookup("colorado"), "COS-sky"},
+	}
+	for _, tc := range cases {
+		if got := placeIDs(tc.got); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldChange is one field that differs between two versions of a place
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// PlaceChange is a place in both catalogs whose data moved
+type PlaceChange struct {
+	PlaceID string
+	Changes []FieldChange
+}
+
+// CatalogDiff is what changed going from one catalog to another. Provenance
+// isn't compared, a refresh re-stamps every entry
+type CatalogDiff struct {
+	Added   []Location
+	Removed []Location
+	Changed []PlaceChange
+}
+
+func (d *CatalogDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// DiffCatalogs compares before to after by PlaceID. Everything comes back
+// sorted by PlaceID so the output is stable
+func DiffCatalogs(before, after *Catalog) *CatalogDiff {
+	d := &CatalogDiff{
+		Added:   []Location{},
+		Removed: []Location{},
+		Changed: []PlaceChange{},
+	}
+
+	for _, l := range after.places {
+		prev, ok := before.Place(l.PlaceID)
+		if !ok {
+			d.Added = append(d.Added, l)
+			continue
+		}
+		if changes := locationChanges(prev, l); len(changes) > 0 {
+			d.Changed = append(d.Changed, PlaceChange{PlaceID: l.PlaceID, Changes: changes})
+		}
+	}
+
+	for _, l := range before.places {
+		if _, ok := after.Place(l.PlaceID); !ok {
+			d.Removed = append(d.Removed, l)
+		}
+	}
+
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].PlaceID < d.Added[j].PlaceID })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].PlaceID < d.Removed[j].PlaceID })
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].PlaceID < d.Changed[j].PlaceID })
+
+	return d
+}
+
+// every field we care about, as strings so they can be shown side by side
+func locationFields(l Location) [][2]string {
+	coords := ""
+	if l.HasCoords() {
+		coords = fmt.Sprintf("%.4f,%.4f", l.Latitude, l.Longitude)
+	}
+	elevation := ""
+	if l.ElevationFt != 0 {
+		elevation = fmt.Sprintf("%d", l.ElevationFt)
+	}
+	return [][2]string{
+		{"PlaceName", l.PlaceName},
+		{"CountryId", l.CountryID},
+		{"RegionId", l.RegionID},
+		{"CityId", l.CityID},
+		{"CountryName", l.CountryName},
+		{"Coordinates", coords},
+		{"ElevationFt", elevation},
+		{"TimeZone", l.TimeZone},
+	}
+}
+
+func locationChanges(old, new Location) []FieldChange {
+	changes := []FieldChange{}
+	oldFields, newFields := locationFields(old), locationFields(new)
+	for i := range oldFields {
+		if oldFields[i][1] != newFields[i][1] {
+			changes = append(changes, FieldChange{
+				Field: oldFields[i][0],
+				Old:   oldFields[i][1],
+				New:   newFields[i][1],
+			})
+		}
+	}
+	return changes
+}
+
+// MergeCatalogs combines two catalogs. Places only in one side are kept as
+// is. For places in both, base is the starting point and any field other has
+// a value for wins, so a fresh build can be laid over an older catalog
+// without losing geo data the build doesn't know about
+func MergeCatalogs(base, other *Catalog) *Catalog {
+	places := base.List()
+	for i, l := range places {
+		o, ok := other.Place(l.PlaceID)
+		if !ok {
+			continue
+		}
+		places[i] = mergeLocation(l, o)
+	}
+	for _, o := range other.places {
+		if _, ok := base.Place(o.PlaceID); !ok {
+			places = append(places, o)
+		}
+	}
+
+	res := NewCatalog(places)
+	res.Meta.BuiltAt = time.Now().UTC()
+	res.Meta.Source = fmt.Sprintf("merge of [%s] and [%s]", describeSource(base), describeSource(other))
+	return res
+}
+
+func mergeLocation(base, other Location) Location {
+	res := base
+	if other.PlaceName != "" {
+		res.PlaceName = other.PlaceName
+	}
+	if other.CountryID != "" {
+		res.CountryID = other.CountryID
+	}
+	if other.RegionID != "" {
+		res.RegionID = other.RegionID
+	}
+	if other.CityID != "" {
+		res.CityID = other.CityID
+	}
+	if other.CountryName != "" {
+		res.CountryName = other.CountryName
+	}
+	if other.HasCoords() {
+		res.Latitude, res.Longitude = other.Latitude, other.Longitude
+	}
+	if other.ElevationFt != 0 {
+		res.ElevationFt = other.ElevationFt
+	}
+	if other.TimeZone != "" {
+		res.TimeZone = other.TimeZone
+	}
+	res.Provenance = mergeProvenance(base.Provenance, other.Provenance, other.PlaceName != "", other.HasCoords())
+	return res
+}
+
+// mergeProvenance keeps each part of where a place came from with the values
+// it's about: the lookup goes with the names, which other's win when it has
+// them, and GeoSource with the coordinates
+func mergeProvenance(base, other *Provenance, otherNames, otherCoords bool) *Provenance {
+	res := Provenance{}
+	if base != nil {
+		res = *base
+	}
+	if other != nil && otherNames && other.Source != "" {
+		res.Source, res.Query, res.AddedAt = other.Source, other.Query, other.AddedAt
+	}
+	if otherCoords {
+		// other's coordinates with no say where they're from aren't base's
+		res.GeoSource = ""
+		if other != nil {
+			res.GeoSource = other.GeoSource
+		}
+	}
+	if res == (Provenance{}) {
+		return nil
+	}
+	return &res
+}
+
+func describeSource(c *Catalog) string {
+	parts := []string{}
+	if c.Meta.Source != "" {
+		parts = append(parts, c.Meta.Source)
+	}
+	if !c.Meta.BuiltAt.IsZero() {
+		parts = append(parts, c.Meta.BuiltAt.Format(time.RFC3339))
+	}
+	if len(parts) == 0 {
+		return "unversioned"
+	}
+	return strings.Join(parts, " @ ")
+}
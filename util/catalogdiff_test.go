@@ -0,0 +1,61 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeCatalogsProvenance(t *testing.T) {
+	built := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rebuilt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := NewCatalog([]Location{
+		// geo data the fresh build doesn't have
+		{PlaceID: "CUN-sky", PlaceName: "Cancun", CountryID: "MX-sky", Latitude: 21.04, Longitude: -86.87, TimeZone: "America/Cancun",
+			Provenance: &Provenance{Source: "rapidapi autosuggest", Query: "cancun", AddedAt: built, GeoSource: "airports.csv"}},
+		{PlaceID: "HAV-sky", PlaceName: "Havana", CountryID: "CU-sky",
+			Provenance: &Provenance{Source: "rapidapi autosuggest", Query: "havana", AddedAt: built}},
+	})
+	other := NewCatalog([]Location{
+		{PlaceID: "CUN-sky", PlaceName: "Cancún", CountryID: "MX-sky",
+			Provenance: &Provenance{Source: "fixtures", Query: "cancún", AddedAt: rebuilt}},
+		{PlaceID: "HAV-sky", PlaceName: "Havana", CountryID: "CU-sky", Latitude: 22.99, Longitude: -82.41,
+			Provenance: &Provenance{Source: "fixtures", Query: "havana", AddedAt: rebuilt, GeoSource: "geo.csv"}},
+		{PlaceID: "PUJ-sky", PlaceName: "Punta Cana", CountryID: "DO-sky"},
+	})
+
+	merged := MergeCatalogs(base, other)
+	if merged.Len() != 3 {
+		t.Fatalf("merged has %d places, want 3", merged.Len())
+	}
+
+	cun, _ := merged.Place("CUN-sky")
+	if cun.PlaceName != "Cancún" || cun.Latitude != 21.04 || cun.TimeZone != "America/Cancun" {
+		t.Errorf("CUN-sky is %+v, want other's name and base's geo", cun)
+	}
+	want := Provenance{Source: "fixtures", Query: "cancún", AddedAt: rebuilt, GeoSource: "airports.csv"}
+	if cun.Provenance == nil || *cun.Provenance != want {
+		t.Errorf("CUN-sky provenance %+v, want %+v", cun.Provenance, want)
+	}
+
+	hav, _ := merged.Place("HAV-sky")
+	want = Provenance{Source: "fixtures", Query: "havana", AddedAt: rebuilt, GeoSource: "geo.csv"}
+	if hav.Latitude != 22.99 || hav.Provenance == nil || *hav.Provenance != want {
+		t.Errorf("HAV-sky is %+v with provenance %+v, want other's coordinates and %+v", hav, hav.Provenance, want)
+	}
+
+	if puj, ok := merged.Place("PUJ-sky"); !ok || puj.Provenance != nil {
+		t.Errorf("PUJ-sky should come over as is, got %+v", puj)
+	}
+}
+
+func TestMergeProvenanceCoordsWithoutASource(t *testing.T) {
+	base := &Provenance{Source: "rapidapi autosuggest", GeoSource: "airports.csv"}
+	// other's coordinates win but it can't say where they're from
+	got := mergeProvenance(base, nil, true, true)
+	if got == nil || got.Source != "rapidapi autosuggest" || got.GeoSource != "" {
+		t.Errorf("got %+v, want base's source and no GeoSource", got)
+	}
+	if got := mergeProvenance(nil, nil, true, false); got != nil {
+		t.Errorf("got %+v, want nil", got)
+	}
+}
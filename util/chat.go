@@ -0,0 +1,142 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// WebhookFormat lays a notification out as a webhook's body. nil is the
+// notification as is
+type WebhookFormat func(n Notification) interface{}
+
+// webhookFormats go by the prefix on the scheme, slack+https://hooks.slack.com/..
+// and so on. Hooks on slack's and discord's own hosts get theirs without it
+var webhookFormats = map[string]WebhookFormat{
+	"slack":   SlackPayload,
+	"discord": DiscordPayload,
+}
+
+func init() {
+	for name := range webhookFormats {
+		RegisterNotifier(name+"+http", newWebhookNotifier)
+		RegisterNotifier(name+"+https", newWebhookNotifier)
+	}
+}
+
+func newWebhookNotifier(u *url.URL) (Notifier, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("webhook %q needs a host", u.String())
+	}
+	n := &WebhookNotifier{Client: &http.Client{Timeout: 10 * time.Second}}
+	hook := *u
+	if i := strings.Index(hook.Scheme, "+"); i >= 0 {
+		n.Format = webhookFormats[hook.Scheme[:i]]
+		hook.Scheme = hook.Scheme[i+1:]
+	} else {
+		switch {
+		case hook.Host == "hooks.slack.com":
+			n.Format = SlackPayload
+		case (hook.Host == "discord.com" || hook.Host == "discordapp.com") && strings.HasPrefix(hook.Path, "/api/webhooks/"):
+			n.Format = DiscordPayload
+		}
+	}
+	n.URL = hook.String()
+	return n, nil
+}
+
+// slack and discord both cap how much goes in one message
+const (
+	slackMaxBlocks    = 50
+	discordMaxEmbeds  = 10
+	discordMaxContent = 2000
+)
+
+// SlackPayload is a notification as a slack incoming webhook message: a
+// header, the detail, then a section per trip with everybody's fare and a
+// book link. text is the fallback for notifications
+func SlackPayload(n Notification) interface{} {
+	blocks := []map[string]interface{}{
+		{"type": "header", "text": map[string]interface{}{"type": "plain_text", "text": n.Title}},
+	}
+	if n.Detail != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "context",
+			"elements": []map[string]interface{}{{"type": "mrkdwn", "text": slackEscape(n.Detail)}},
+		})
+	}
+	for i, trip := range n.Trips {
+		// a divider and a section each
+		if len(blocks)+2 > slackMaxBlocks {
+			break
+		}
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "*%s*\n", slackEscape(tripHeading(i, len(n.Trips), trip)))
+		for _, leg := range trip.Legs {
+			fmt.Fprintf(b, "%s: $%.2f %s", slackEscape(leg.Traveler), leg.Fare, slackEscape(leg.Route()))
+			if leg.Link != "" {
+				fmt.Fprintf(b, " <%s|book>", leg.Link)
+			}
+			b.WriteString("\n")
+		}
+		for _, f := range trip.Failures {
+			fmt.Fprintf(b, "%s: no fare, %s\n", slackEscape(f.Traveler), slackEscape(f.Describe()))
+		}
+		blocks = append(blocks,
+			map[string]interface{}{"type": "divider"},
+			map[string]interface{}{"type": "section", "text": map[string]interface{}{"type": "mrkdwn", "text": b.String()}},
+		)
+	}
+	return map[string]interface{}{
+		"text":   n.Title,
+		"blocks": blocks,
+	}
+}
+
+// slack wants &, < and > escaped in text, everything else goes as is
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// DiscordPayload is a notification as a discord webhook message: the title
+// as content and an embed per trip, everybody's fare and book link in the
+// description
+func DiscordPayload(n Notification) interface{} {
+	content := n.Title
+	if n.Detail != "" {
+		content += "\n" + n.Detail
+	}
+	// discord counts characters, and cutting through one isn't valid utf-8
+	if utf8.RuneCountInString(content) > discordMaxContent {
+		content = string([]rune(content)[:discordMaxContent])
+	}
+	embeds := []map[string]interface{}{}
+	for i, trip := range n.Trips {
+		if len(embeds) == discordMaxEmbeds {
+			break
+		}
+		b := &strings.Builder{}
+		for _, leg := range trip.Legs {
+			fmt.Fprintf(b, "%s: $%.2f %s", leg.Traveler, leg.Fare, leg.Route())
+			if leg.Link != "" {
+				// a ) in the link would end the markdown link early
+				fmt.Fprintf(b, " [book](%s)", strings.Replace(leg.Link, ")", "%29", -1))
+			}
+			b.WriteString("\n")
+		}
+		for _, f := range trip.Failures {
+			fmt.Fprintf(b, "%s: no fare, %s\n", f.Traveler, f.Describe())
+		}
+		embeds = append(embeds, map[string]interface{}{
+			"title":       tripHeading(i, len(n.Trips), trip),
+			"description": b.String(),
+		})
+	}
+	return map[string]interface{}{
+		"content": content,
+		"embeds":  embeds,
+	}
+}
@@ -0,0 +1,203 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testNotification() Notification {
+	return Notification{
+		Kind:     NotifySearchResults,
+		Title:    "Search done: Cancun at $300.00 for everybody",
+		Detail:   "2020-01-01 to 2020-01-05, 2 trips, best 2 here",
+		Time:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Outbound: "2020-01-01",
+		Inbound:  "2020-01-05",
+		Trips: []ReportTrip{
+			{
+				Destination: "CUN-sky",
+				PlaceName:   "Cancun",
+				Total:       300,
+				Cost:        300,
+				Complete:    true,
+				Legs: []ReportLeg{
+					{Traveler: "alice", From: "DEN-sky", To: "CUN-sky", Fare: 100, Link: "https://example.com/book?a=1&b=(2)"},
+					{Traveler: "bob", From: "JFK-sky", To: "CUN-sky", Fare: 200},
+				},
+			},
+			{
+				Destination: "HAV-sky",
+				PlaceName:   "Havana",
+				Total:       150,
+				Cost:        150,
+				Missing:     []string{"bob"},
+				Legs:        []ReportLeg{{Traveler: "alice", From: "DEN-sky", To: "HAV-sky", Fare: 150}},
+				Failures:    []ReportFailure{{Traveler: "bob", Reason: FailRateLimited, Attempts: 3}},
+			},
+		},
+	}
+}
+
+// hookServer answers every post with status and keeps what it got
+type hookServer struct {
+	*httptest.Server
+	status      int
+	body        []byte
+	contentType string
+}
+
+func newHookServer(t *testing.T, status int) *hookServer {
+	h := &hookServer{status: status}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("got a %s, want a POST", r.Method)
+		}
+		h.contentType = r.Header.Get("Content-Type")
+		h.body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(h.status)
+		w.Write([]byte("invalid_payload\n"))
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+// notify sends n through the notifier for scheme+ the server's url, and
+// decodes what the server got into v
+func (h *hookServer) notify(t *testing.T, scheme string, n Notification, v interface{}) {
+	notifier, err := NewNotifier(scheme + h.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if h.contentType != "application/json" {
+		t.Errorf("content type %q, want application/json", h.contentType)
+	}
+	if err := json.Unmarshal(h.body, v); err != nil {
+		t.Fatalf("body isn't json: %s\n%s", err, h.body)
+	}
+}
+
+func TestWebhookGeneric(t *testing.T) {
+	h := newHookServer(t, http.StatusNoContent)
+	got := Notification{}
+	h.notify(t, "", testNotification(), &got)
+
+	want := testNotification()
+	if got.Kind != want.Kind || got.Title != want.Title || !got.Time.Equal(want.Time) || len(got.Trips) != 2 {
+		t.Errorf("got %+v", got)
+	}
+	if got.Trips[0].Legs[0].Link != want.Trips[0].Legs[0].Link {
+		t.Errorf("link %q, want %q", got.Trips[0].Legs[0].Link, want.Trips[0].Legs[0].Link)
+	}
+}
+
+func TestWebhookSlack(t *testing.T) {
+	h := newHookServer(t, http.StatusOK)
+	got := struct {
+		Text   string `json:"text"`
+		Blocks []struct {
+			Type string `json:"type"`
+			Text struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"text"`
+		} `json:"blocks"`
+	}{}
+	h.notify(t, "slack+", testNotification(), &got)
+
+	if got.Text != testNotification().Title {
+		t.Errorf("text %q", got.Text)
+	}
+	// header, detail, then a divider and section per trip
+	types := []string{}
+	for _, b := range got.Blocks {
+		types = append(types, b.Type)
+	}
+	if strings.Join(types, ",") != "header,context,divider,section,divider,section" {
+		t.Fatalf("blocks %v", types)
+	}
+	cancun := got.Blocks[3].Text.Text
+	for _, want := range []string{"*1. Cancun (CUN-sky): $300.00*", "alice: $100.00 DEN-sky -&gt; CUN-sky <https://example.com/book?a=1&b=(2)|book>", "bob: $200.00"} {
+		if !strings.Contains(cancun, want) {
+			t.Errorf("cancun section %q is missing %q", cancun, want)
+		}
+	}
+	if havana := got.Blocks[5].Text.Text; !strings.Contains(havana, "without bob") || !strings.Contains(havana, "bob: no fare, rate_limited after 3 attempts") {
+		t.Errorf("havana section %q", havana)
+	}
+}
+
+func TestWebhookDiscord(t *testing.T) {
+	h := newHookServer(t, http.StatusNoContent)
+	got := struct {
+		Content string `json:"content"`
+		Embeds  []struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+		} `json:"embeds"`
+	}{}
+	h.notify(t, "discord+", testNotification(), &got)
+
+	n := testNotification()
+	if got.Content != n.Title+"\n"+n.Detail {
+		t.Errorf("content %q", got.Content)
+	}
+	if len(got.Embeds) != 2 || got.Embeds[0].Title != "1. Cancun (CUN-sky): $300.00" {
+		t.Fatalf("embeds %+v", got.Embeds)
+	}
+	if !strings.Contains(got.Embeds[0].Description, "[book](https://example.com/book?a=1&b=(2%29)") {
+		t.Errorf("description %q should have the link with its ) escaped", got.Embeds[0].Description)
+	}
+}
+
+func TestWebhookNon2xx(t *testing.T) {
+	for _, scheme := range []string{"", "slack+", "discord+"} {
+		h := newHookServer(t, http.StatusBadRequest)
+		notifier, err := NewNotifier(scheme + h.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = notifier.Notify(context.Background(), testNotification())
+		if err == nil || !strings.Contains(err.Error(), "400 Bad Request") || !strings.Contains(err.Error(), "invalid_payload") {
+			t.Errorf("%swebhook got %v, want the status and body back", scheme, err)
+		}
+	}
+}
+
+func TestDiscordContentCutsOnACharacter(t *testing.T) {
+	n := testNotification()
+	// 3 bytes each, so a byte cut at the limit lands mid character
+	n.Title = strings.Repeat("€", discordMaxContent+10)
+	content := DiscordPayload(n).(map[string]interface{})["content"].(string)
+	if !utf8.ValidString(content) {
+		t.Fatal("content isn't valid utf-8")
+	}
+	if got := utf8.RuneCountInString(content); got != discordMaxContent {
+		t.Errorf("content is %d characters, want %d", got, discordMaxContent)
+	}
+}
+
+func TestResultsNotificationCountsGroundCosts(t *testing.T) {
+	travelers := map[string]*Traveler{
+		"alice": NewTraveler("alice", "DEN-sky"),
+		"bob":   NewTraveler("bob", "JFK-sky"),
+	}
+	trips := map[string]*Trip{"CUN-sky": costTrip("CUN-sky", 100, map[string]float64{"alice": 50, "bob": 50})}
+	n := NewResultsNotification(NewReport(travelers, trips, "2020-01-01", "2020-01-05"), "run", JobDone, 3)
+
+	if n.Title != "Search done: CUN-sky at $200.00 for everybody" {
+		t.Errorf("title %q, want the fares and lodging", n.Title)
+	}
+	if text := n.Text(); !strings.Contains(text, "CUN-sky (CUN-sky): $200.00 ($100.00 in fares)") {
+		t.Errorf("text %q should have the cost with the fares beside it", text)
+	}
+}
@@ -0,0 +1,269 @@
+package util
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CostItem is one cost on the ground at a destination, for the whole group
+type CostItem struct {
+	Name   string  `json:"name"`
+	Detail string  `json:"detail"`
+	Amount float64 `json:"amount"`
+}
+
+// CostComponent is one kind of cost a trip has on top of the flights, ie
+// where everybody sleeps. Cost is false when it doesn't know the place
+type CostComponent interface {
+	Name() string
+	Cost(trip *Trip) (CostItem, bool)
+}
+
+// CostRates is what a place costs per person. nil is not known, so a city
+// can set lodging and leave the rest to its country
+type CostRates struct {
+	LodgingPerNight *float64 `json:"lodging_per_night,omitempty"`
+	Transfer        *float64 `json:"transfer,omitempty"`
+	DailySpend      *float64 `json:"daily_spend,omitempty"`
+}
+
+// CostTable is per person costs by CityID, falling back to CountryID. Keys
+// go either way, CUNA-sky or CUNA, any case
+type CostTable struct {
+	Cities    map[string]CostRates `json:"cities"`
+	Countries map[string]CostRates `json:"countries"`
+}
+
+// LoadCostTable reads a cost table, json like CostTable or a csv:
+//
+//	city_id,country_id,lodging_per_night,transfer,daily_spend
+//	CUNA-sky,,140,35,70
+//	,MX-sky,80,25,50
+//
+// a row is for its city if it has one, otherwise its country. Empty cells
+// aren't known
+func LoadCostTable(path string) (*CostTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &CostTable{}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		t, err = readCostCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("err reading cost table %s: %s", path, err.Error())
+	}
+	t.normalize()
+	return t, nil
+}
+
+func readCostCSV(r io.Reader) (*CostTable, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no header")
+	}
+	header := rows[0]
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	cityCol, countryCol := indexOf(header, "city_id"), indexOf(header, "country_id")
+	if cityCol < 0 && countryCol < 0 {
+		return nil, fmt.Errorf("need a city_id or country_id column")
+	}
+	get := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	rate := func(row []string, col string, line int) (*float64, error) {
+		v := get(row, indexOf(header, col))
+		if v == "" {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimPrefix(v, "$"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad %s %q", line, col, v)
+		}
+		return &f, nil
+	}
+
+	t := &CostTable{Cities: map[string]CostRates{}, Countries: map[string]CostRates{}}
+	for n, row := range rows[1:] {
+		line := n + 2
+		rates := CostRates{}
+		if rates.LodgingPerNight, err = rate(row, "lodging_per_night", line); err != nil {
+			return nil, err
+		}
+		if rates.Transfer, err = rate(row, "transfer", line); err != nil {
+			return nil, err
+		}
+		if rates.DailySpend, err = rate(row, "daily_spend", line); err != nil {
+			return nil, err
+		}
+		switch city, country := get(row, cityCol), get(row, countryCol); {
+		case city != "":
+			t.Cities[city] = rates
+		case country != "":
+			t.Countries[country] = rates
+		default:
+			return nil, fmt.Errorf("line %d has no city_id or country_id", line)
+		}
+	}
+	return t, nil
+}
+
+func costKey(id string) string {
+	return strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(id)), "-SKY")
+}
+
+func (t *CostTable) normalize() {
+	for _, m := range []*map[string]CostRates{&t.Cities, &t.Countries} {
+		norm := map[string]CostRates{}
+		for k, v := range *m {
+			norm[costKey(k)] = v
+		}
+		*m = norm
+	}
+}
+
+// Rates is what l costs, its city's rates where it has them and its
+// country's for the rest
+func (t *CostTable) Rates(l Location) CostRates {
+	city, country := t.Cities[costKey(l.CityID)], t.Countries[costKey(l.CountryID)]
+	if city.LodgingPerNight == nil {
+		city.LodgingPerNight = country.LodgingPerNight
+	}
+	if city.Transfer == nil {
+		city.Transfer = country.Transfer
+	}
+	if city.DailySpend == nil {
+		city.DailySpend = country.DailySpend
+	}
+	return city
+}
+
+// Components is lodging, airport transfers and daily spend out of the table
+func (t *CostTable) Components() []CostComponent {
+	return []CostComponent{Lodging{t}, AirportTransfer{t}, DailySpend{t}}
+}
+
+// Lodging is a bed a night for everybody who doesn't already live there
+type Lodging struct {
+	Table *CostTable
+}
+
+func (Lodging) Name() string { return "lodging" }
+
+func (c Lodging) Cost(trip *Trip) (CostItem, bool) {
+	rate := c.Table.Rates(trip.Destination).LodgingPerNight
+	if rate == nil {
+		return CostItem{}, false
+	}
+	// locals live in the city, even if not next to the airport, so they
+	// go home at night
+	nights, people := trip.Nights(), trip.flyers()
+	return CostItem{
+		Name:   c.Name(),
+		Detail: fmt.Sprintf("%d nights x %d people x $%.2f", nights, people, *rate),
+		Amount: cents(float64(nights*people) * *rate),
+	}, true
+}
+
+// AirportTransfer is getting from the airport into town and back, for
+// everybody flying in
+type AirportTransfer struct {
+	Table *CostTable
+}
+
+func (AirportTransfer) Name() string { return "airport transfer" }
+
+func (c AirportTransfer) Cost(trip *Trip) (CostItem, bool) {
+	rate := c.Table.Rates(trip.Destination).Transfer
+	if rate == nil {
+		return CostItem{}, false
+	}
+	people := trip.flyers()
+	return CostItem{
+		Name:   c.Name(),
+		Detail: fmt.Sprintf("%d people x $%.2f", people, *rate),
+		Amount: cents(float64(people) * *rate),
+	}, true
+}
+
+// DailySpend is food and getting around, every day of the trip for everybody
+type DailySpend struct {
+	Table *CostTable
+}
+
+func (DailySpend) Name() string { return "daily spend" }
+
+func (c DailySpend) Cost(trip *Trip) (CostItem, bool) {
+	rate := c.Table.Rates(trip.Destination).DailySpend
+	if rate == nil {
+		return CostItem{}, false
+	}
+	days, people := trip.Nights()+1, trip.people()
+	return CostItem{
+		Name:   c.Name(),
+		Detail: fmt.Sprintf("%d days x %d people x $%.2f", days, people, *rate),
+		Amount: cents(float64(days*people) * *rate),
+	}, true
+}
+
+// Nights is how many nights the trip is, 0 if the dates don't parse
+func (t *Trip) Nights() int {
+	out, err := time.Parse("2006-01-02", t.Dates.Outbound)
+	if err != nil {
+		return 0
+	}
+	in, err := time.Parse("2006-01-02", t.Dates.Inbound)
+	if err != nil || in.Before(out) {
+		return 0
+	}
+	return int(in.Sub(out).Hours() / 24)
+}
+
+// people is everybody going, fare or not
+func (t *Trip) people() int {
+	return len(t.Legs) + len(t.Missing)
+}
+
+// flyers is everybody who isn't already there. Anyone missing a fare is
+// counted, they'd be flying if they had one
+func (t *Trip) flyers() int {
+	n := len(t.Missing)
+	for _, f := range t.Legs {
+		if !f.Local {
+			n++
+		}
+	}
+	return n
+}
+
+// AddCosts works out the trip's ground costs from components, replacing any
+// it had, and rescores it
+func (t *Trip) AddCosts(components []CostComponent) {
+	t.Costs = nil
+	for _, c := range components {
+		if item, ok := c.Cost(t); ok {
+			t.Costs = append(t.Costs, item)
+		}
+	}
+	t.Score = t.Cost()
+}
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rateValue is *r, or -1 for not known
+func rateValue(r *float64) float64 {
+	if r == nil {
+		return -1
+	}
+	return *r
+}
+
+func TestLoadCostTable(t *testing.T) {
+	cases := []struct {
+		name, file, body string
+		// lodging for CUNA, or the error
+		lodging float64
+		err     string
+	}{
+		{"csv", "costs.csv", "city_id,country_id,lodging_per_night\nCUNA-sky,,140\n", 140, ""},
+		{"dollars", "costs.csv", "city_id,country_id,lodging_per_night\nCUNA-sky,,$140.50\n", 140.5, ""},
+		{"json any case", "costs.json", `{"cities": {"cuna-sky": {"lodging_per_night": 99}}}`, 99, ""},
+		{"no ids", "costs.csv", "city_id,country_id,lodging_per_night\nCUNA-sky,,140\n,,100\n", 0, "line 3 has no city_id or country_id"},
+		{"bad value", "costs.csv", "city_id,lodging_per_night\nCUNA-sky,cheap\n", 0, `line 2: bad lodging_per_night "cheap"`},
+		{"no id columns", "costs.csv", "lodging_per_night\n100\n", 0, "need a city_id or country_id column"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), c.file)
+			if err := ioutil.WriteFile(path, []byte(c.body), 0644); err != nil {
+				t.Fatal(err)
+			}
+			table, err := LoadCostTable(path)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("got %v, want an error with %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rateValue(table.Cities["CUNA"].LodgingPerNight); got != c.lodging {
+				t.Errorf("CUNA lodging %v, want %v", got, c.lodging)
+			}
+		})
+	}
+}
+
+func TestCostTableRatesFallBack(t *testing.T) {
+	table, err := LoadCostTable(filepath.Join("testdata", "costs.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name                      string
+		place                     Location
+		lodging, transfer, spends float64
+	}{
+		// Cancun's own lodging, Mexico's for the rest
+		{"city then country", Location{CityID: "CUNA-sky", CountryID: "MX-sky"}, 140, 25, 50},
+		{"city has it all", Location{CityID: "NYCA-sky", CountryID: "US-sky"}, 220, 40, 90},
+		{"country only", Location{CityID: "HAVA-sky", CountryID: "CU-sky"}, 60, 30, 40},
+		{"any case", Location{CityID: "cuna", CountryID: "mx"}, 140, 25, 50},
+		{"not known", Location{CityID: "LHRA-sky", CountryID: "UK-sky"}, -1, -1, -1},
+	}
+	for _, c := range cases {
+		r := table.Rates(c.place)
+		if rateValue(r.LodgingPerNight) != c.lodging || rateValue(r.Transfer) != c.transfer || rateValue(r.DailySpend) != c.spends {
+			t.Errorf("%s: got %v/%v/%v, want %v/%v/%v", c.name,
+				rateValue(r.LodgingPerNight), rateValue(r.Transfer), rateValue(r.DailySpend), c.lodging, c.transfer, c.spends)
+		}
+	}
+}
+
+func TestGroundCostsCountFlyersAndPeople(t *testing.T) {
+	table, err := LoadCostTable(filepath.Join("testdata", "costs.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 nights. alice flies in, bob lives there, carol has no fare yet but
+	// would be flying
+	trip := NewTrip(Location{PlaceID: "CUN-sky", CityID: "CUNA-sky", CountryID: "MX-sky"}, TripDates{Outbound: "2020-01-01", Inbound: "2020-01-05"})
+	trip.AddFare("alice", Fare{Price: 100})
+	trip.AddFare("bob", Fare{Local: true})
+	trip.Fail("carol", LegFailure{Reason: FailRateLimited})
+	trip.Missing = []string{"carol"}
+	trip.AddCosts(table.Components())
+
+	want := map[string]float64{
+		// 4 nights x 2 flyers x 140
+		"lodging": 1120,
+		// 2 flyers x 25
+		"airport transfer": 50,
+		// 5 days x all 3 x 50
+		"daily spend": 750,
+	}
+	if len(trip.Costs) != len(want) {
+		t.Fatalf("costs %+v, want %d of them", trip.Costs, len(want))
+	}
+	for _, item := range trip.Costs {
+		if item.Amount != want[item.Name] {
+			t.Errorf("%s is %v (%s), want %v", item.Name, item.Amount, item.Detail, want[item.Name])
+		}
+	}
+	if trip.Score != 100+1120+50+750 {
+		t.Errorf("score %v, want the fare and every ground cost", trip.Score)
+	}
+}
+
+func TestTripNights(t *testing.T) {
+	cases := []struct {
+		out, in string
+		want    int
+	}{
+		{"2020-01-01", "2020-01-05", 4},
+		{"2020-02-28", "2020-03-01", 2},
+		{"2020-01-01", "2020-01-01", 0},
+		{"2020-01-05", "2020-01-01", 0},
+		{"2020-13-01", "2020-01-05", 0},
+		{"2020-01-01", "someday", 0},
+		{"", "", 0},
+	}
+	for _, c := range cases {
+		trip := NewTrip(Location{PlaceID: "CUN-sky"}, TripDates{Outbound: c.out, Inbound: c.in})
+		if got := trip.Nights(); got != c.want {
+			t.Errorf("%q to %q is %d nights, want %d", c.out, c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"time"
+)
+
+// EventType is what happened in a search
+type EventType string
+
+const (
+	// a destination (or a batch of them) is about to be searched
+	EventDestinationStarted EventType = "destination_started"
+	// somebody got a fare, see Fare. Local ones too
+	EventLegPriced EventType = "leg_priced"
+	// somebody didn't, see Failure
+	EventLegFailed EventType = "leg_failed"
+	// a complete enough trip came in under the cheapest so far
+	EventNewBest EventType = "new_best"
+	// the rate limiter is holding everything up for Wait
+	EventRateLimitPause EventType = "rate_limit_pause"
+	// the search is over, one way or another
+	EventSearchDone EventType = "search_done"
+)
+
+// Event is one thing that happened during a search. Only the fields that go
+// with Type are set, plus the progress counters which always are
+type Event struct {
+	Type        EventType   `json:"type"`
+	Time        time.Time   `json:"time"`
+	Destination string      `json:"destination,omitempty"`
+	PlaceName   string      `json:"place_name,omitempty"`
+	Traveler    string      `json:"traveler,omitempty"`
+	Fare        *Fare       `json:"fare,omitempty"`
+	Failure     *LegFailure `json:"failure,omitempty"`
+	Total       float64     `json:"total,omitempty"`
+	WaitMs      int64       `json:"wait_ms,omitempty"`
+	Error       string      `json:"error,omitempty"`
+
+	Planned  int `json:"planned"`
+	Searched int `json:"searched"`
+	Calls    int `json:"calls"`
+}
+
+// Wait is WaitMs as a duration
+func (e Event) Wait() time.Duration {
+	return time.Duration(e.WaitMs) * time.Millisecond
+}
+
+// Subscriber gets a search's events as they happen. It's called from the
+// search's goroutine, so it shouldn't hang about
+type Subscriber interface {
+	Event(Event)
+}
+
+// SubscriberFunc lets a plain func be a Subscriber
+type SubscriberFunc func(Event)
+
+func (f SubscriberFunc) Event(e Event) {
+	f(e)
+}
+
+// Subscribers sends every event to each of them in turn
+type Subscribers []Subscriber
+
+func (s Subscribers) Event(e Event) {
+	for _, sub := range s {
+		sub.Event(e)
+	}
+}
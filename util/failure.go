@@ -0,0 +1,165 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// FailureReason is why a traveler has no fare to a destination
+type FailureReason string
+
+const (
+	// every attempt hit the rate limit
+	FailRateLimited FailureReason = "rate_limited"
+	// the api kept answering with no itineraries
+	FailNoItineraries FailureReason = "no_itineraries"
+	// every attempt failed to make a session
+	FailSessionFailed FailureReason = "session_failed"
+	// a mix of the above until we ran out of attempts
+	FailExceededAttempts FailureReason = "exceeded_attempts"
+	// the poll came back with an error that isn't worth retrying
+	FailPollError FailureReason = "poll_error"
+	// never searched, the trip already cost more than the cheapest one
+	FailPruned FailureReason = "pruned"
+	// never searched, the search stopped first
+	FailNotSearched FailureReason = "not_searched"
+)
+
+// LegFailure is what went wrong on one traveler's leg of a trip
+type LegFailure struct {
+	Reason   FailureReason `json:"reason"`
+	Attempts int           `json:"attempts"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// LegError is the error a leg search gives up with
+type LegError struct {
+	Reason   FailureReason
+	Attempts int
+	Err      error
+}
+
+func (e *LegError) Error() string {
+	return e.Err.Error()
+}
+
+// legFailure describes err for the results file. Anything that isn't a
+// LegError stopped the search rather than failing the leg
+func legFailure(err error) LegFailure {
+	var legErr *LegError
+	if errors.As(err, &legErr) {
+		return LegFailure{Reason: legErr.Reason, Attempts: legErr.Attempts, Error: legErr.Error()}
+	}
+	if errors.Is(err, ErrBudgetSpent) || errors.Is(err, context.Canceled) {
+		return LegFailure{Reason: FailNotSearched, Error: err.Error()}
+	}
+	return LegFailure{Reason: FailPollError, Error: err.Error()}
+}
+
+// Pruned is true when any leg went unsearched because the trip was already
+// too expensive. There's no point retrying those
+func (t *Trip) Pruned() bool {
+	for _, f := range t.Failures {
+		if f.Reason == FailPruned {
+			return true
+		}
+	}
+	return false
+}
+
+// PromisingTrips picks the non viable trips worth another go: at most
+// maxMissing legs short, not pruned, and with what they cost so far, ground
+// costs and all, still under the cheapest viable trip. Fewest missing first,
+// then cheapest
+func PromisingTrips(viable, nonViable []*Trip, maxMissing int) []*Trip {
+	best := -1.0
+	for _, t := range viable {
+		if best < 0 || t.Cost() < best {
+			best = t.Cost()
+		}
+	}
+
+	res := []*Trip{}
+	for _, t := range nonViable {
+		if t.Pruned() || len(t.Missing) == 0 || len(t.Missing) > maxMissing {
+			continue
+		}
+		if best >= 0 && t.Cost() >= best {
+			continue
+		}
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i].Missing) != len(res[j].Missing) {
+			return len(res[i].Missing) < len(res[j].Missing)
+		}
+		return Trips(res).Less(i, j)
+	})
+	return res
+}
+
+// RetryFailed searches just the missing legs of each trip, at the trip's own
+// dates, and fills in whatever turns up. spec supplies the travelers and the
+// call budget, shared across all the trips. The result has just the retried
+// trips, and the legs go to the Subscriber like a search's do
+func (e *Engine) RetryFailed(ctx context.Context, spec *SearchSpec, trips []*Trip) (_ *SearchResult, err error) {
+	result := &SearchResult{
+		Trips:    map[string]*Trip{},
+		Cheapest: 99999999.00, // arbitrary big number
+		Pruned:   []string{},
+		Skipped:  []string{},
+		Planned:  len(trips),
+	}
+	budget := &callBudget{limit: spec.Budget}
+	defer func() {
+		result.Calls = budget.used
+		done := Event{Type: EventSearchDone}
+		if err != nil {
+			done.Error = err.Error()
+		}
+		e.emit(result, budget, done)
+	}()
+
+	for _, trip := range trips {
+		legSpec := *spec
+		legSpec.OutboundDate, legSpec.InboundDate = trip.Dates.Outbound, trip.Dates.Inbound
+		result.Trips[trip.Destination.PlaceID] = trip
+		e.emit(result, budget, Event{Type: EventDestinationStarted, Destination: trip.Destination.PlaceID, PlaceName: trip.Destination.PlaceName})
+
+		e.logf("retrying %d legs to %s\n", len(trip.Missing), trip.Destination.PlaceID)
+		for _, name := range append([]string{}, trip.Missing...) {
+			traveler, ok := spec.Travelers[name]
+			if !ok {
+				continue
+			}
+			if fare, ok := LocalFare(e.ss.Catalog(), traveler, trip.Destination, spec.GroundCost); ok {
+				e.priced(result, budget, trip, name, fare)
+				continue
+			}
+			bestPrice, _, err := e.priceLeg(ctx, &legSpec, budget, traveler, trip.Destination)
+			if err == ErrBudgetSpent || ctx.Err() != nil {
+				trip.finish(spec)
+				if err == ErrBudgetSpent {
+					result.OutOfBudget = true
+					return result, err
+				}
+				return result, ctx.Err()
+			}
+			if err != nil {
+				e.logln(err.Error())
+				e.failed(result, budget, trip, name, legFailure(err))
+				continue
+			}
+			e.logf("best price: %f to %s\n", bestPrice.Price, bestPrice.Deeplink)
+			e.priced(result, budget, trip, name, fareFrom(bestPrice))
+		}
+		trip.finish(spec)
+		result.Searched++
+		if trip.Complete() && trip.Cost() < result.Cheapest {
+			result.CheapestKey = trip.Destination.PlaceID
+			result.Cheapest = trip.Cost()
+		}
+	}
+	return result, nil
+}
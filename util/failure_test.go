@@ -0,0 +1,69 @@
+package util
+
+import (
+	"context"
+	"testing"
+)
+
+// costTrip is a trip to id with fares for the travelers given and lodging on
+// top, everybody else missing
+func costTrip(id string, lodging float64, fares map[string]float64, missing ...string) *Trip {
+	t := NewTrip(Location{PlaceID: id, PlaceName: id}, TripDates{Outbound: "2020-01-01", Inbound: "2020-01-05"})
+	for name, price := range fares {
+		t.AddFare(name, Fare{Price: price, From: "DEN-sky", To: id})
+	}
+	for _, name := range missing {
+		t.Fail(name, LegFailure{Reason: FailRateLimited, Attempts: 3})
+	}
+	t.Missing = append([]string{}, missing...)
+	t.Costs = []CostItem{{Name: "lodging", Amount: lodging}}
+	t.Score = t.Cost()
+	return t
+}
+
+func TestPromisingTripsCountGroundCosts(t *testing.T) {
+	// 200 all in
+	viable := []*Trip{costTrip("CUN-sky", 100, map[string]float64{"alice": 50, "bob": 50})}
+	nonViable := []*Trip{
+		// 150 in fares is under the best trip's fares, but 350 all in isn't
+		costTrip("HAV-sky", 200, map[string]float64{"alice": 150}, "bob"),
+		// 180 all in could still win
+		costTrip("PUJ-sky", 80, map[string]float64{"alice": 100}, "bob"),
+	}
+
+	retry := PromisingTrips(viable, nonViable, 2)
+	if len(retry) != 1 || retry[0].Destination.PlaceID != "PUJ-sky" {
+		ids := []string{}
+		for _, t := range retry {
+			ids = append(ids, t.Destination.PlaceID)
+		}
+		t.Errorf("retrying %v, want just PUJ-sky", ids)
+	}
+}
+
+func TestRetryFailedKeepsCostsWithoutATable(t *testing.T) {
+	places := []Location{{PlaceID: "PUJ-sky", PlaceName: "Punta Cana", CountryID: "DO-sky", CityID: "PUJA-sky"}}
+	ss := newFareProvider(places, map[string]map[string]float64{
+		"JFK-sky": {"PUJ-sky": 120},
+	})
+	trip := costTrip("PUJ-sky", 80, map[string]float64{"alice": 100}, "bob")
+	spec := &SearchSpec{
+		Travelers: map[string]*Traveler{
+			"alice": NewTraveler("alice", "DEN-sky"),
+			"bob":   NewTraveler("bob", "JFK-sky"),
+		},
+	}
+
+	if _, err := testEngine(ss).RetryFailed(context.Background(), spec, []*Trip{trip}); err != nil {
+		t.Fatal(err)
+	}
+	if !trip.Complete() {
+		t.Fatalf("bob's leg should have been found, missing %v", trip.Missing)
+	}
+	if len(trip.Costs) != 1 || trip.Costs[0].Amount != 80 {
+		t.Errorf("costs %+v, want the lodging it had", trip.Costs)
+	}
+	if trip.Score != 300 {
+		t.Errorf("score %v, want 220 in fares and 80 lodging", trip.Score)
+	}
+}
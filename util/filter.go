@@ -0,0 +1,470 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// DestinationFilter picks which places in the catalog are worth searching.
+// Includes are unioned (no includes at all means everything), then excludes
+// and the deny list are taken out, then Where has the last word
+type DestinationFilter struct {
+	Countries []string `json:"countries,omitempty"`
+	Regions   []string `json:"regions,omitempty"`
+	Cities    []string `json:"cities,omitempty"`
+	Places    []string `json:"places,omitempty"`
+
+	ExcludeCountries []string `json:"exclude_countries,omitempty"`
+	ExcludeRegions   []string `json:"exclude_regions,omitempty"`
+	ExcludeCities    []string `json:"exclude_cities,omitempty"`
+	ExcludePlaces    []string `json:"exclude_places,omitempty"`
+
+	// Deny is for places nobody wants to fly into no matter what, by PlaceID,
+	// CityID or whole name ("New York Newark")
+	Deny []string `json:"deny,omitempty"`
+
+	// Where is a filter expression, see ParseFilterExpr
+	Where string `json:"where,omitempty"`
+}
+
+func (f *DestinationFilter) hasIncludes() bool {
+	return len(f.Countries)+len(f.Regions)+len(f.Cities)+len(f.Places) > 0
+}
+
+// Select runs the filter over the catalog, keeping catalog order
+func (f *DestinationFilter) Select(c *Catalog) ([]Location, error) {
+	where := func(Location) bool { return true }
+	regions := append(append([]string{}, f.Regions...), f.ExcludeRegions...)
+	if strings.TrimSpace(f.Where) != "" {
+		var err error
+		var p *filterParser
+		where, p, err = parseFilterExpr(f.Where)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, p.regions...)
+	}
+	// a region that isn't one quietly matches nothing, which for != or an
+	// exclude is everything
+	for _, r := range regions {
+		if !knownRegion(c, r) {
+			return nil, fmt.Errorf("don't know region %q, want a region id from the catalog or a US state or Canadian province", r)
+		}
+	}
+
+	res := []Location{}
+	for _, l := range c.List() {
+		if f.hasIncludes() && !f.included(l) {
+			continue
+		}
+		if f.excluded(l) || f.denied(l) {
+			continue
+		}
+		if !where(l) {
+			continue
+		}
+		res = append(res, l)
+	}
+
+	return res, nil
+}
+
+func (f *DestinationFilter) included(l Location) bool {
+	return matchAny(f.Countries, l, countryMatches) ||
+		matchAny(f.Regions, l, regionMatches) ||
+		matchAny(f.Cities, l, cityMatches) ||
+		matchAny(f.Places, l, placeMatches)
+}
+
+func (f *DestinationFilter) excluded(l Location) bool {
+	return matchAny(f.ExcludeCountries, l, countryMatches) ||
+		matchAny(f.ExcludeRegions, l, regionMatches) ||
+		matchAny(f.ExcludeCities, l, cityMatches) ||
+		matchAny(f.ExcludePlaces, l, placeMatches)
+}
+
+// denied is a whole name or an id, so "new" doesn't take out New York and
+// New Orleans with it
+func (f *DestinationFilter) denied(l Location) bool {
+	for _, d := range f.Deny {
+		if placeMatches(d, l) || cityMatches(d, l) {
+			return true
+		}
+		if name := FoldName(d); name != "" && name == FoldName(l.PlaceName) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(values []string, l Location, match func(string, Location) bool) bool {
+	for _, v := range values {
+		if match(v, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// ids can be given with or without the "-sky" suffix, any case
+func idMatches(want, id string) bool {
+	if id == "" {
+		return false
+	}
+	want = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(want)), "-SKY")
+	return want == strings.TrimSuffix(strings.ToUpper(id), "-SKY")
+}
+
+// country by id ("MX", "MX-sky") or name ("mexico")
+func countryMatches(want string, l Location) bool {
+	return idMatches(want, l.CountryID) || FoldName(want) == FoldName(l.CountryName)
+}
+
+// region by id ("AK") or, where we know it, name ("alaska"). The catalog only
+// has ids
+func regionMatches(want string, l Location) bool {
+	if idMatches(want, l.RegionID) {
+		return true
+	}
+	name, ok := regionNames[strings.ToUpper(l.RegionID)]
+	return ok && FoldName(want) == FoldName(name)
+}
+
+// knownRegion is whether want is a region in c, or one we know the name of
+func knownRegion(c *Catalog, want string) bool {
+	for id := range c.byRegion {
+		if idMatches(want, id) {
+			return true
+		}
+	}
+	for id, name := range regionNames {
+		if idMatches(want, id) || FoldName(want) == FoldName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// regionNames is what the catalog's RegionIds stand for. skyscanner only
+// gives regions for the US and Canada
+var regionNames = map[string]string{
+	"AL": "Alabama", "AK": "Alaska", "AZ": "Arizona", "AR": "Arkansas", "CA": "California",
+	"CO": "Colorado", "CT": "Connecticut", "DE": "Delaware", "DC": "District of Columbia", "FL": "Florida",
+	"GA": "Georgia", "HI": "Hawaii", "ID": "Idaho", "IL": "Illinois", "IN": "Indiana",
+	"IA": "Iowa", "KS": "Kansas", "KY": "Kentucky", "LA": "Louisiana", "ME": "Maine",
+	"MD": "Maryland", "MA": "Massachusetts", "MI": "Michigan", "MN": "Minnesota", "MS": "Mississippi",
+	"MO": "Missouri", "MT": "Montana", "NE": "Nebraska", "NV": "Nevada", "NH": "New Hampshire",
+	"NJ": "New Jersey", "NM": "New Mexico", "NY": "New York", "NC": "North Carolina", "ND": "North Dakota",
+	"OH": "Ohio", "OK": "Oklahoma", "OR": "Oregon", "PA": "Pennsylvania", "RI": "Rhode Island",
+	"SC": "South Carolina", "SD": "South Dakota", "TN": "Tennessee", "TX": "Texas", "UT": "Utah",
+	"VT": "Vermont", "VA": "Virginia", "WA": "Washington", "WV": "West Virginia", "WI": "Wisconsin",
+	"WY": "Wyoming", "PR": "Puerto Rico", "VI": "U.S. Virgin Islands", "GU": "Guam",
+	"AB": "Alberta", "BC": "British Columbia", "MB": "Manitoba", "NB": "New Brunswick",
+	"NL": "Newfoundland and Labrador", "NS": "Nova Scotia", "NT": "Northwest Territories", "NU": "Nunavut",
+	"ON": "Ontario", "PE": "Prince Edward Island", "QC": "Quebec", "SK": "Saskatchewan", "YT": "Yukon",
+}
+
+func cityMatches(want string, l Location) bool {
+	return idMatches(want, l.CityID)
+}
+
+func placeMatches(want string, l Location) bool {
+	return idMatches(want, l.PlaceID)
+}
+
+// ParseFilterExpr compiles a small filter language into a predicate:
+//
+//	country in [MX, US] and region != "Alaska"
+//	not (city == LASA or name ~ "vegas")
+//
+// fields are country, region, city, place and name. operators are ==, !=,
+// in, not in, and ~ (name contains). values are bare words or quoted
+// strings, matched the same loose way as the include/exclude lists
+func ParseFilterExpr(expr string) (func(Location) bool, error) {
+	pred, _, err := parseFilterExpr(expr)
+	return pred, err
+}
+
+// parseFilterExpr is ParseFilterExpr, with the parser handed back for the
+// regions it saw so Select can check them against the catalog
+func parseFilterExpr(expr string) (func(Location) bool, *filterParser, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.done() {
+		return nil, nil, fmt.Errorf("unexpected %q at end of filter", p.peek().text)
+	}
+
+	return pred, p, nil
+}
+
+type filterTokenKind int
+
+const (
+	tokWord filterTokenKind = iota
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{tokLParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{tokRParen, ")"})
+			i++
+		case r == '[':
+			tokens = append(tokens, filterToken{tokLBracket, "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, filterToken{tokRBracket, "]"})
+			i++
+		case r == ',':
+			tokens = append(tokens, filterToken{tokComma, ","})
+			i++
+		case r == '~':
+			tokens = append(tokens, filterToken{tokOp, "~"})
+			i++
+		case r == '=' || r == '!':
+			if i+1 >= len(rs) || rs[i+1] != '=' {
+				return nil, fmt.Errorf("bad operator at %d in filter, want == or !=", i)
+			}
+			tokens = append(tokens, filterToken{tokOp, string(rs[i : i+2])})
+			i += 2
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			tokens = append(tokens, filterToken{tokString, string(rs[i+1 : j])})
+			i = j + 1
+		default:
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '-' || rs[j] == '_') {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q in filter", r)
+			}
+			tokens = append(tokens, filterToken{tokWord, string(rs[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	// regions is every value compared against region
+	regions []string
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.done() {
+		return filterToken{kind: -1, text: "end of filter"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keywords are case insensitive
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (func(Location) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(loc Location) bool { return l(loc) || right(loc) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (func(Location) bool, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(loc Location) bool { return l(loc) && right(loc) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (func(Location) bool, error) {
+	if p.keyword("not") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(loc Location) bool { return !inner(loc) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (func(Location) bool, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) in filter, got %q", t.text)
+		}
+		return inner, nil
+	}
+
+	field := p.next()
+	if field.kind != tokWord {
+		return nil, fmt.Errorf("expected a field name in filter, got %q", field.text)
+	}
+	match, err := filterField(field.text)
+	if err != nil {
+		return nil, err
+	}
+
+	isRegion := strings.EqualFold(field.text, "region")
+	switch {
+	case p.keyword("in"):
+		return p.parseList(match, false, isRegion)
+	case p.keyword("not"):
+		if !p.keyword("in") {
+			return nil, fmt.Errorf("expected in after not, got %q", p.peek().text)
+		}
+		return p.parseList(match, true, isRegion)
+	}
+
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, fmt.Errorf("expected an operator after %s, got %q", field.text, op.text)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if isRegion {
+		p.regions = append(p.regions, value)
+	}
+
+	switch op.text {
+	case "==":
+		return func(loc Location) bool { return match(value, loc) }, nil
+	case "!=":
+		return func(loc Location) bool { return !match(value, loc) }, nil
+	default: // ~
+		if !strings.EqualFold(field.text, "name") {
+			return nil, fmt.Errorf("~ only works on name")
+		}
+		want := FoldName(value)
+		return func(loc Location) bool { return strings.Contains(FoldName(loc.PlaceName), want) }, nil
+	}
+}
+
+func (p *filterParser) parseList(match func(string, Location) bool, negate, isRegion bool) (func(Location) bool, error) {
+	if t := p.next(); t.kind != tokLBracket {
+		return nil, fmt.Errorf("expected [ in filter, got %q", t.text)
+	}
+
+	values := []string{}
+	for p.peek().kind != tokRBracket {
+		if len(values) > 0 {
+			if t := p.next(); t.kind != tokComma {
+				return nil, fmt.Errorf("expected , or ] in filter, got %q", t.text)
+			}
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	p.next()
+	if isRegion {
+		p.regions = append(p.regions, values...)
+	}
+
+	return func(loc Location) bool {
+		return matchAny(values, loc, match) != negate
+	}, nil
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	t := p.next()
+	if t.kind != tokWord && t.kind != tokString {
+		return "", fmt.Errorf("expected a value in filter, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+func filterField(name string) (func(string, Location) bool, error) {
+	switch strings.ToLower(name) {
+	case "country":
+		return countryMatches, nil
+	case "region":
+		return regionMatches, nil
+	case "city":
+		return cityMatches, nil
+	case "place":
+		return placeMatches, nil
+	case "name":
+		return func(want string, l Location) bool { return FoldName(want) == FoldName(l.PlaceName) }, nil
+	}
+	return nil, fmt.Errorf("unknown filter field %q", name)
+}
@@ -0,0 +1,63 @@
+package util
+
+import "testing"
+
+func TestSelectRegionsAndDeny(t *testing.T) {
+	catalog := NewCatalog([]Location{
+		{PlaceID: "DEN-sky", PlaceName: "Denver International", CountryID: "US-sky", RegionID: "CO", CityID: "DENA-sky"},
+		{PlaceID: "ANC-sky", PlaceName: "Anchorage", CountryID: "US-sky", RegionID: "AK", CityID: "ANCA-sky"},
+		{PlaceID: "JFK-sky", PlaceName: "New York John F. Kennedy", CountryID: "US-sky", RegionID: "NY", CityID: "NYCA-sky"},
+		{PlaceID: "EWR-sky", PlaceName: "New York Newark", CountryID: "US-sky", RegionID: "NJ", CityID: "NYCA-sky"},
+		{PlaceID: "MSY-sky", PlaceName: "New Orleans", CountryID: "US-sky", RegionID: "LA", CityID: "MSYA-sky"},
+	})
+
+	tests := []struct {
+		name   string
+		filter DestinationFilter
+		want   []string
+	}{
+		{"region by name", DestinationFilter{Where: `region != "Alaska"`}, []string{"DEN-sky", "JFK-sky", "EWR-sky", "MSY-sky"}},
+		{"region by id", DestinationFilter{Regions: []string{"co"}}, []string{"DEN-sky"}},
+		{"region list by name", DestinationFilter{Where: `region in [Colorado, "new york"]`}, []string{"DEN-sky", "JFK-sky"}},
+		{"exclude region by name", DestinationFilter{ExcludeRegions: []string{"new jersey"}}, []string{"DEN-sky", "ANC-sky", "JFK-sky", "MSY-sky"}},
+		{"deny isn't a substring", DestinationFilter{Deny: []string{"new"}}, []string{"DEN-sky", "ANC-sky", "JFK-sky", "EWR-sky", "MSY-sky"}},
+		{"deny by whole name", DestinationFilter{Deny: []string{"new york newark"}}, []string{"DEN-sky", "ANC-sky", "JFK-sky", "MSY-sky"}},
+		{"deny by place id", DestinationFilter{Deny: []string{"MSY"}}, []string{"DEN-sky", "ANC-sky", "JFK-sky", "EWR-sky"}},
+		{"deny by city id", DestinationFilter{Deny: []string{"NYCA-sky"}}, []string{"DEN-sky", "ANC-sky", "MSY-sky"}},
+	}
+	for _, tt := range tests {
+		got, err := tt.filter.Select(catalog)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		ids := []string{}
+		for _, l := range got {
+			ids = append(ids, l.PlaceID)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSelectUnknownRegion(t *testing.T) {
+	catalog := NewCatalog([]Location{{PlaceID: "DEN-sky", PlaceName: "Denver International", RegionID: "CO"}})
+	for _, f := range []DestinationFilter{
+		{Regions: []string{"Alaksa"}},
+		{ExcludeRegions: []string{"Alaksa"}},
+		{Where: `region != "Alaksa"`},
+		{Where: `country == US and region not in [CO, Alaksa]`},
+	} {
+		if _, err := f.Select(catalog); err == nil {
+			t.Errorf("%+v: want an error for the misspelled region", f)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const fixtureSessionKey = "fixture-session"
+
+// NewFixtureSkyScanner is a SkyScanner that never touches the network. Every
+// request is answered out of saved responses in dir, so the whole flow can be
+// run (and tested) offline:
+//
+//	view.html                               results page for CreateView
+//	fps3.json                               private search for InitSessionCommercial
+//	poll-<src>-<dst>.json, poll.json        rapidapi PollSession, most specific wins
+//	poll-<src>-multi.json, poll-multi.json  same for PollSessionMulti
+//	autosuggest-<query>.json                rapidapi GetLocation, query lowercased with _ for spaces
+//	browse-<src>.json                       rapidapi BrowseQuotes from src
+//
+// jsonLocation may be empty for an empty catalog. InitSession always succeeds
+// with a fixed session key. A missing fixture is a 404, which the client sees
+// the same as the real API having nothing
+func NewFixtureSkyScanner(jsonLocation, dir string) (SkyScanner, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("err opening fixture dir: %s", err.Error())
+	}
+
+	// fixtures don't check the key, there just has to be one
+	return newSkyScanner(jsonLocation, Config{APIKey: "fixture"}, &http.Client{
+		Transport: &fixtureTransport{dir: dir},
+	})
+}
+
+type fixtureTransport struct {
+	dir string
+}
+
+func (f *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/transport/flights/"):
+		return f.serve(req, "view.html")
+
+	case strings.Contains(path, "/conductor/v1/fps3/search"):
+		return f.serve(req, "fps3.json")
+
+	case path == "/apiservices/pricing/v1.0":
+		res := fixtureResponse(req, http.StatusCreated, nil)
+		res.Header.Set("location", fmt.Sprintf("https://%s/apiservices/pricing/uk2/v1.0/%s", rapidAPIHost, fixtureSessionKey))
+		return res, nil
+
+	case strings.HasPrefix(path, "/apiservices/pricing/uk2/v1.0/"):
+		q := req.URL.Query()
+		if strings.Contains(q.Get("destinationAirports"), ";") {
+			return f.serve(req,
+				fmt.Sprintf("poll-%s-multi.json", q.Get("originAirports")),
+				"poll-multi.json",
+			)
+		}
+		return f.serve(req,
+			fmt.Sprintf("poll-%s-%s.json", q.Get("originAirports"), q.Get("destinationAirports")),
+			"poll.json",
+		)
+
+	case strings.HasPrefix(path, "/apiservices/browsequotes/"):
+		// /apiservices/browsequotes/v1.0/US/USD/en-US/<src>/<dst>/<out>/<in>
+		parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+		if len(parts) < 7 {
+			return fixtureResponse(req, http.StatusNotFound, nil), nil
+		}
+		return f.serve(req, fmt.Sprintf("browse-%s.json", parts[6]))
+
+	case strings.HasPrefix(path, "/apiservices/autosuggest/"):
+		query := strings.ToLower(strings.TrimSpace(req.URL.Query().Get("query")))
+		return f.serve(req, fmt.Sprintf("autosuggest-%s.json", strings.Replace(query, " ", "_", -1)))
+	}
+
+	return fixtureResponse(req, http.StatusNotFound, nil), nil
+}
+
+// serve the first of names that exists
+func (f *fixtureTransport) serve(req *http.Request, names ...string) (*http.Response, error) {
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(f.dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return fixtureResponse(req, http.StatusOK, b), nil
+	}
+	return fixtureResponse(req, http.StatusNotFound, nil), nil
+}
+
+func fixtureResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
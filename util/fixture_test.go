@@ -0,0 +1,59 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixtureMissingPollIsNoItineraries(t *testing.T) {
+	dir := t.TempDir()
+	// only CUN has a saved poll, HAV's is a 404
+	poll, err := ioutil.ReadFile(filepath.Join("testdata", "poll.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "poll-DEN-sky-CUN-sky.json"), poll, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// and an empty one is no better
+	if err := ioutil.WriteFile(filepath.Join(dir, "poll-DEN-sky-PUJ-sky.json"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ss, err := NewFixtureSkyScanner("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	places := []Location{
+		{PlaceID: "CUN-sky", PlaceName: "Cancun"},
+		{PlaceID: "HAV-sky", PlaceName: "Havana"},
+		{PlaceID: "PUJ-sky", PlaceName: "Punta Cana"},
+	}
+	spec := &SearchSpec{
+		Travelers:    map[string]*Traveler{"alice": NewTraveler("alice", "DEN-sky")},
+		OutboundDate: "2020-01-01",
+		InboundDate:  "2020-01-05",
+		Destinations: places,
+	}
+	failures := map[string]FailureReason{}
+	e := testEngine(ss)
+	e.Subscriber = SubscriberFunc(func(ev Event) {
+		if ev.Type == EventLegFailed {
+			failures[ev.Destination] = ev.Failure.Reason
+		}
+	})
+	result, err := e.Run(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CheapestKey != "CUN-sky" {
+		t.Errorf("cheapest is %q, want CUN-sky priced from its fixture", result.CheapestKey)
+	}
+	for _, id := range []string{"HAV-sky", "PUJ-sky"} {
+		if got := failures[id]; got != FailNoItineraries {
+			t.Errorf("%s failed with %q, want %q", id, got, FailNoItineraries)
+		}
+	}
+}
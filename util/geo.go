@@ -0,0 +1,273 @@
+package util
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const earthRadiusMiles = 3958.8
+
+// GeoRecord is one airport out of an offline dataset
+type GeoRecord struct {
+	IATA        string
+	Name        string
+	Latitude    float64
+	Longitude   float64
+	ElevationFt int
+	TimeZone    string
+}
+
+// column names we understand, first match wins. covers the OurAirports
+// airports.csv export plus the usual spellings from other dumps that carry an
+// IANA zone (OurAirports itself doesn't)
+var geoColumns = map[string][]string{
+	"iata":      {"iata_code", "iata"},
+	"name":      {"name"},
+	"lat":       {"latitude_deg", "latitude", "lat"},
+	"lon":       {"longitude_deg", "longitude", "lon", "lng"},
+	"elevation": {"elevation_ft", "elevation"},
+	"tz":        {"tz_database_time_zone", "time_zone", "timezone", "tz"},
+}
+
+// ReadGeoCSV reads an airports csv with a header row into records keyed by
+// IATA code. Rows without an IATA code or coordinates are skipped
+func ReadGeoCSV(r io.Reader) (map[string]GeoRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("err reading geo csv header: %s", err.Error())
+	}
+
+	cols := map[string]int{}
+	for key, names := range geoColumns {
+		cols[key] = -1
+		for _, name := range names {
+			if i := indexOf(header, name); i >= 0 {
+				cols[key] = i
+				break
+			}
+		}
+	}
+	if cols["iata"] < 0 || cols["lat"] < 0 || cols["lon"] < 0 {
+		return nil, fmt.Errorf("geo csv needs iata, latitude and longitude columns, got %v", header)
+	}
+
+	get := func(row []string, key string) string {
+		i := cols[key]
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	records := map[string]GeoRecord{}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("err reading geo csv: %s", err.Error())
+		}
+
+		iata := strings.ToUpper(get(row, "iata"))
+		if len(iata) != 3 {
+			continue
+		}
+
+		lat, latErr := strconv.ParseFloat(get(row, "lat"), 64)
+		lon, lonErr := strconv.ParseFloat(get(row, "lon"), 64)
+		if latErr != nil || lonErr != nil {
+			continue
+		}
+
+		elevation, _ := strconv.ParseFloat(get(row, "elevation"), 64)
+		records[iata] = GeoRecord{
+			IATA:        iata,
+			Name:        get(row, "name"),
+			Latitude:    lat,
+			Longitude:   lon,
+			ElevationFt: int(elevation),
+			TimeZone:    get(row, "tz"),
+		}
+	}
+
+	return records, nil
+}
+
+// LoadGeoCSV is ReadGeoCSV for a file on disk
+func LoadGeoCSV(path string) (map[string]GeoRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadGeoCSV(f)
+}
+
+// MergeGeo returns a new catalog with coordinates, elevation and time zone
+// filled in from records, along with how many places it touched. source names
+// the dataset for provenance. Airports match on their IATA code ("DEN-sky" ->
+// DEN). City level places ("DENA-sky") don't have a code of their own so they
+// get the middle of their airports. Values already in the catalog are only
+// overwritten when the dataset has one
+func (c *Catalog) MergeGeo(records map[string]GeoRecord, source string) (*Catalog, int) {
+	places := c.List()
+	merged := 0
+
+	for i, l := range places {
+		rec, ok := records[webPlaceCode(l.PlaceID)]
+		if !ok {
+			continue
+		}
+		places[i].Latitude = rec.Latitude
+		places[i].Longitude = rec.Longitude
+		if rec.ElevationFt != 0 {
+			places[i].ElevationFt = rec.ElevationFt
+		}
+		if rec.TimeZone != "" {
+			places[i].TimeZone = rec.TimeZone
+		}
+		places[i].Provenance = withGeoSource(l.Provenance, source)
+		merged++
+	}
+
+	// second pass for cities, now that their airports have coords
+	byCity := map[string][]Location{}
+	for _, l := range places {
+		if l.CityID != "" && l.CityID != l.PlaceID && l.HasCoords() {
+			byCity[l.CityID] = append(byCity[l.CityID], l)
+		}
+	}
+	for i, l := range places {
+		airports := byCity[l.PlaceID]
+		if l.HasCoords() || len(airports) == 0 {
+			continue
+		}
+		lat, lon := Centroid(airports)
+		places[i].Latitude, places[i].Longitude = roundCoord(lat), roundCoord(lon)
+		places[i].TimeZone = airports[0].TimeZone
+		places[i].Provenance = withGeoSource(l.Provenance, source+" (city airports)")
+		merged++
+	}
+
+	res := c.withPlaces(places)
+	res.Meta.BuiltAt = time.Now().UTC()
+	return res, merged
+}
+
+// provenance is shared between catalogs, so copy before touching it
+func withGeoSource(p *Provenance, source string) *Provenance {
+	res := &Provenance{Source: "unknown"}
+	if p != nil {
+		*res = *p
+	}
+	res.GeoSource = source
+	return res
+}
+
+// GreatCircleMiles is the haversine distance between two places. Both need
+// coords
+func GreatCircleMiles(a, b Location) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Centroid is the geographic middle of a set of places, averaged on the sphere
+// so a group spread across the antimeridian doesn't end up in Africa. Places
+// without coords are ignored
+func Centroid(places []Location) (lat, lon float64) {
+	var x, y, z float64
+	n := 0
+	for _, l := range places {
+		if !l.HasCoords() {
+			continue
+		}
+		la, lo := radians(l.Latitude), radians(l.Longitude)
+		x += math.Cos(la) * math.Cos(lo)
+		y += math.Cos(la) * math.Sin(lo)
+		z += math.Sin(la)
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+
+	x, y, z = x/float64(n), y/float64(n), z/float64(n)
+	return degrees(math.Atan2(z, math.Sqrt(x*x+y*y))), degrees(math.Atan2(y, x))
+}
+
+// WithinMiles keeps the places no further than miles from lat/lon. Places
+// without coords can't be measured and are dropped
+func WithinMiles(places []Location, lat, lon, miles float64) []Location {
+	center := Location{Latitude: lat, Longitude: lon}
+	res := []Location{}
+	for _, l := range places {
+		if l.HasCoords() && GreatCircleMiles(center, l) <= miles {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// LocalTime puts t on the wall clock at l. Falls back to UTC when we don't
+// know the zone
+func LocalTime(t time.Time, l Location) time.Time {
+	if l.TimeZone == "" {
+		return t.UTC()
+	}
+	loc, err := time.LoadLocation(l.TimeZone)
+	if err != nil {
+		return t.UTC()
+	}
+	return t.In(loc)
+}
+
+// ParseLocalTime reads a "2020-01-19T10:10:00" style time as wall clock time
+// at l, which is how flight times come back
+func ParseLocalTime(value string, l Location) (time.Time, error) {
+	loc := time.UTC
+	if l.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(l.TimeZone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad time zone %q for %s: %s", l.TimeZone, l.PlaceID, err.Error())
+		}
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05", value, loc)
+}
+
+// six places is ~10cm, anything past that is float noise
+func roundCoord(d float64) float64 {
+	return math.Round(d*1e6) / 1e6
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+func indexOf(values []string, want string) int {
+	for i, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), want) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,249 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TripCalendar writes traveler's side of trip as an iCalendar file: their
+// flights there and back, and the trip's dates as an all day event. The trip
+// event has the same UID in everybody's file, so it only shows up once in a
+// calendar the group shares. catalog is where the airports' time zones come
+// from; a flight where either end has none is left in floating time, see
+// FloatingAirports
+func TripCalendar(w io.Writer, trip *Trip, traveler string, catalog *Catalog) error {
+	start, err := time.Parse("2006-01-02", trip.Dates.Outbound)
+	if err != nil {
+		return fmt.Errorf("bad outbound date %q: %s", trip.Dates.Outbound, err.Error())
+	}
+	end, err := time.Parse("2006-01-02", trip.Dates.Inbound)
+	if err != nil {
+		return fmt.Errorf("bad inbound date %q: %s", trip.Dates.Inbound, err.Error())
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	c := &icsWriter{w: w}
+	c.line("BEGIN:VCALENDAR")
+	c.line("VERSION:2.0")
+	c.line("PRODID:-//flight-finder//trip export//EN")
+	c.line("CALSCALE:GREGORIAN")
+	c.line("METHOD:PUBLISH")
+
+	// the whole trip, everybody's fare in the description
+	tripID := icsID(trip.Destination.PlaceID, trip.Dates.Outbound, trip.Dates.Inbound)
+	c.line("BEGIN:VEVENT")
+	c.line("UID:trip-" + tripID + "@flight-finder")
+	c.line("DTSTAMP:" + stamp)
+	c.line("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+	// all day DTEND is the day after the last one
+	c.line("DTEND;VALUE=DATE:" + end.AddDate(0, 0, 1).Format("20060102"))
+	c.text("SUMMARY", "Trip to "+trip.Destination.PlaceName)
+	c.text("LOCATION", placeLabel(trip.Destination))
+	c.text("DESCRIPTION", tripDescription(trip))
+	c.line("TRANSP:TRANSPARENT")
+	c.line("END:VEVENT")
+
+	fare, ok := trip.Legs[traveler]
+	if ok {
+		for _, way := range []struct {
+			name   string
+			flight *Flight
+		}{{"outbound", fare.Outbound}, {"inbound", fare.Inbound}} {
+			if way.flight == nil {
+				continue
+			}
+			if err := c.flight(way.flight, fare, catalog, stamp, icsID(traveler, way.name, tripID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.line("END:VCALENDAR")
+	return c.err
+}
+
+func (c *icsWriter) flight(f *Flight, fare Fare, catalog *Catalog, stamp, uid string) error {
+	from, to := airport(catalog, f.From), airport(catalog, f.To)
+	// one end in UTC and the other floating would make the flight the wrong
+	// length for anyone not in the floating end's zone, so both float
+	floating := from.TimeZone == "" || to.TimeZone == ""
+	departs, err := icsTime(f.Departure, from, floating)
+	if err != nil {
+		return err
+	}
+	arrives, err := icsTime(f.Arrival, to, floating)
+	if err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("%s to %s", airportCode(f.From), airportCode(f.To))
+	if len(f.Numbers) > 0 {
+		summary = strings.Join(f.Numbers, ", ") + " " + summary
+	} else if f.Carrier != "" {
+		summary = f.Carrier + " " + summary
+	}
+
+	desc := []string{}
+	if f.Carrier != "" {
+		desc = append(desc, strings.TrimSpace(f.Carrier+" "+strings.Join(f.Numbers, ", ")))
+	}
+	desc = append(desc,
+		fmt.Sprintf("Departs %s %s", placeLabel(from), localLabel(f.Departure, from)),
+		fmt.Sprintf("Arrives %s %s", placeLabel(to), localLabel(f.Arrival, to)),
+		fmt.Sprintf("$%.2f there and back", fare.Price),
+	)
+	if fare.Link != "" {
+		desc = append(desc, "Book: "+fare.Link)
+	}
+
+	c.line("BEGIN:VEVENT")
+	c.line("UID:" + uid + "@flight-finder")
+	c.line("DTSTAMP:" + stamp)
+	c.line("DTSTART:" + departs)
+	c.line("DTEND:" + arrives)
+	c.text("SUMMARY", summary)
+	c.text("LOCATION", placeLabel(from))
+	c.text("DESCRIPTION", strings.Join(desc, "\n"))
+	if fare.Link != "" {
+		// a URI, not text, so no escaping
+		c.line("URL:" + fare.Link)
+	}
+	c.line("END:VEVENT")
+	return nil
+}
+
+// airport is the catalog's entry for placeID, or just the id when it's not
+// in there
+func airport(catalog *Catalog, placeID string) Location {
+	if catalog != nil {
+		if l, ok := catalog.Place(placeID); ok {
+			return l
+		}
+	}
+	return Location{PlaceID: placeID, PlaceName: airportCode(placeID)}
+}
+
+// airportCode is DEN out of DEN-sky
+func airportCode(placeID string) string {
+	return strings.TrimSuffix(placeID, "-sky")
+}
+
+func placeLabel(l Location) string {
+	if l.CountryName != "" && l.CountryName != l.PlaceName {
+		return l.PlaceName + ", " + l.CountryName
+	}
+	return l.PlaceName
+}
+
+// icsTime is a flight's wall clock time at l as UTC, or floating, the same
+// wall clock wherever you are
+func icsTime(value string, l Location, floating bool) (string, error) {
+	t, err := ParseLocalTime(value, l)
+	if err != nil {
+		return "", fmt.Errorf("bad flight time %q at %s: %s", value, l.PlaceID, err.Error())
+	}
+	if floating {
+		return t.Format("20060102T150405"), nil
+	}
+	return t.UTC().Format("20060102T150405Z"), nil
+}
+
+// FloatingAirports is the airports on traveler's flights that catalog has no
+// time zone for, sorted. Any at all and TripCalendar puts those flights in
+// floating time
+func FloatingAirports(trip *Trip, traveler string, catalog *Catalog) []string {
+	fare, ok := trip.Legs[traveler]
+	if !ok {
+		return nil
+	}
+	seen := map[string]bool{}
+	res := []string{}
+	for _, f := range []*Flight{fare.Outbound, fare.Inbound} {
+		if f == nil {
+			continue
+		}
+		for _, id := range []string{f.From, f.To} {
+			if seen[id] || airport(catalog, id).TimeZone != "" {
+				continue
+			}
+			seen[id] = true
+			res = append(res, airportCode(id))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func localLabel(value string, l Location) string {
+	t, err := ParseLocalTime(value, l)
+	if err != nil {
+		return value
+	}
+	if l.TimeZone == "" {
+		return t.Format("Mon Jan 2 15:04") + " local time"
+	}
+	return t.Format("Mon Jan 2 15:04 MST")
+}
+
+func tripDescription(trip *Trip) string {
+	lines := []string{fmt.Sprintf("%s to %s, $%.2f for the group", trip.Dates.Outbound, trip.Dates.Inbound, trip.Total())}
+	for _, name := range trip.Travelers() {
+		f := trip.Legs[name]
+		if f.Local {
+			lines = append(lines, fmt.Sprintf("%s: already there, $%.2f on the ground", name, f.Price))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: $%.2f %s to %s", name, f.Price, airportCode(f.From), airportCode(f.To)))
+	}
+	for _, name := range trip.Missing {
+		lines = append(lines, name+": no fare yet")
+	}
+	return strings.Join(lines, "\n")
+}
+
+var icsIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// icsID joins parts into something safe to put in a UID
+func icsID(parts ...string) string {
+	for i, p := range parts {
+		parts[i] = strings.Trim(icsIDUnsafe.ReplaceAllString(strings.ToLower(p), "-"), "-")
+	}
+	return strings.Join(parts, "-")
+}
+
+// icsWriter writes content lines, folded at 75 octets the way RFC 5545 wants,
+// and keeps the first error
+type icsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (c *icsWriter) line(s string) {
+	if c.err != nil {
+		return
+	}
+	b := &strings.Builder{}
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > 75 {
+			// the space that starts the next line counts toward it
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	b.WriteString("\r\n")
+	_, c.err = io.WriteString(c.w, b.String())
+}
+
+// text is a property with a TEXT value, escaped
+func (c *icsWriter) text(name, value string) {
+	value = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+	c.line(name + ":" + value)
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTripCalendarTimeZones(t *testing.T) {
+	den := Location{PlaceID: "DEN-sky", PlaceName: "Denver", TimeZone: "America/Denver"}
+	jfk := Location{PlaceID: "JFK-sky", PlaceName: "New York", TimeZone: "America/New_York"}
+	cun := Location{PlaceID: "CUN-sky", PlaceName: "Cancun"}
+
+	cases := []struct {
+		name     string
+		to       Location
+		start    string
+		end      string
+		floating []string
+	}{
+		// 08:00 MST and 13:30 EST
+		{"both zones known", jfk, "DTSTART:20200101T150000Z", "DTEND:20200101T183000Z", []string{}},
+		// one end floating would make it the wrong length, so both are
+		{"one zone missing", cun, "DTSTART:20200101T080000\r\n", "DTEND:20200101T133000\r\n", []string{"CUN"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			trip := NewTrip(c.to, TripDates{Outbound: "2020-01-01", Inbound: "2020-01-05"})
+			trip.AddFare("alice", Fare{
+				Price:    300,
+				From:     "DEN-sky",
+				To:       c.to.PlaceID,
+				Outbound: &Flight{From: "DEN-sky", To: c.to.PlaceID, Departure: "2020-01-01T08:00:00", Arrival: "2020-01-01T13:30:00"},
+			})
+			catalog := NewCatalog([]Location{den, c.to})
+
+			b := &bytes.Buffer{}
+			if err := TripCalendar(b, trip, "alice", catalog); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{c.start, c.end} {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("calendar is missing %q:\n%s", want, b.String())
+				}
+			}
+			if got := FloatingAirports(trip, "alice", catalog); strings.Join(got, ",") != strings.Join(c.floating, ",") {
+				t.Errorf("floating airports %v, want %v", got, c.floating)
+			}
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStatus is where a search job is at
+type JobStatus string
+
+const (
+	JobQueued   JobStatus = "queued"
+	JobRunning  JobStatus = "running"
+	JobDone     JobStatus = "done"
+	JobFailed   JobStatus = "failed"
+	JobCanceled JobStatus = "canceled"
+)
+
+// SearchRequest is a search as it comes in over the api. It's the same knobs
+// as the search command
+type SearchRequest struct {
+	Travelers  []*Traveler       `json:"travelers"`
+	Outbound   string            `json:"outbound"`
+	Inbound    string            `json:"inbound"`
+	Filter     DestinationFilter `json:"filter"`
+	Anywhere   bool              `json:"anywhere"`
+	Top        int               `json:"top"`
+	BatchBy    string            `json:"batch_by"`
+	BatchSize  int               `json:"batch_size"`
+	Budget     int               `json:"budget"`
+	Slack      float64           `json:"slack"`
+	GroundCost float64           `json:"ground_cost"`
+}
+
+var isoDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// Spec checks the request over and turns it into a SearchSpec, picking the
+// destinations out of catalog
+func (r *SearchRequest) Spec(catalog *Catalog) (*SearchSpec, error) {
+	if len(r.Travelers) == 0 {
+		return nil, fmt.Errorf("need at least one traveler")
+	}
+	travelers := map[string]*Traveler{}
+	for _, t := range r.Travelers {
+		if t == nil || t.Name == "" || t.LocationCode == "" {
+			return nil, fmt.Errorf("every traveler needs a name and a location_code")
+		}
+		if _, ok := travelers[t.Name]; ok {
+			return nil, fmt.Errorf("traveler %q is in there twice", t.Name)
+		}
+		travelers[t.Name] = NewTraveler(t.Name, t.LocationCode)
+	}
+	if !isoDate.MatchString(r.Outbound) || !isoDate.MatchString(r.Inbound) {
+		return nil, fmt.Errorf("outbound and inbound need to be dates like 2020-01-01")
+	}
+	if r.BatchBy != "" && r.BatchBy != "city" && r.BatchBy != "country" {
+		return nil, fmt.Errorf("batch_by must be city or country, not %q", r.BatchBy)
+	}
+
+	destinations, err := r.Filter.Select(catalog)
+	if err != nil {
+		return nil, err
+	}
+	// anywhere with no filter means anywhere, not just what's in the catalog
+	if r.Anywhere && !r.Filter.hasIncludes() && r.Filter.Where == "" {
+		destinations = nil
+	}
+	if !r.Anywhere && len(destinations) == 0 {
+		return nil, fmt.Errorf("filter doesn't match any destinations")
+	}
+
+	return &SearchSpec{
+		Travelers:    travelers,
+		OutboundDate: r.Outbound,
+		InboundDate:  r.Inbound,
+		Destinations: destinations,
+		Anywhere:     r.Anywhere,
+		TopK:         r.Top,
+		BatchBy:      r.BatchBy,
+		BatchSize:    r.BatchSize,
+		Budget:       r.Budget,
+		PlanSlack:    r.Slack,
+		GroundCost:   r.GroundCost,
+	}, nil
+}
+
+// Job is one search running in the background
+type Job struct {
+	ID         string
+	Request    *SearchRequest
+	Status     JobStatus
+	Error      string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	spec   *SearchSpec
+	result *SearchResult
+	cancel context.CancelFunc
+	// watchers get the job's events until it finishes, then get closed
+	watchers map[chan Event]bool
+	// ballots is everybody's vote on the shortlist, by traveler
+	ballots map[string]Ballot
+}
+
+// JobView is what the api shows of a job
+type JobView struct {
+	ID         string     `json:"id"`
+	Status     JobStatus  `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Progress   struct {
+		Planned  int `json:"planned"`
+		Searched int `json:"searched"`
+		Calls    int `json:"calls"`
+	} `json:"progress"`
+	Cheapest *JobCheapest `json:"cheapest,omitempty"`
+}
+
+// JobCheapest is the best trip a job has found so far
+type JobCheapest struct {
+	Destination string  `json:"destination"`
+	PlaceName   string  `json:"place_name"`
+	Total       float64 `json:"total"`
+}
+
+func (j *Job) view() JobView {
+	v := JobView{
+		ID:        j.ID,
+		Status:    j.Status,
+		Error:     j.Error,
+		CreatedAt: j.CreatedAt,
+	}
+	if !j.StartedAt.IsZero() {
+		started := j.StartedAt
+		v.StartedAt = &started
+	}
+	if !j.FinishedAt.IsZero() {
+		finished := j.FinishedAt
+		v.FinishedAt = &finished
+	}
+	if r := j.result; r != nil {
+		v.Progress.Planned = r.Planned
+		v.Progress.Searched = r.Searched
+		v.Progress.Calls = r.Calls
+		if trip, ok := r.Trips[r.CheapestKey]; ok {
+			v.Cheapest = &JobCheapest{
+				Destination: r.CheapestKey,
+				PlaceName:   trip.Destination.PlaceName,
+				Total:       r.Cheapest,
+			}
+		}
+	}
+	return v
+}
+
+// JobManager runs search jobs side by side. They each get their own engine
+// but share the provider and, more to the point, the rate limiter
+type JobManager struct {
+	ss      SkyScanner
+	limiter *RateLimiter
+
+	// Configure, if set, gets each job's engine before it starts
+	Configure func(*Engine)
+	// Store, if set, keeps every job's run, under the job's id
+	Store *Store
+	// Notifier, if set, gets each job's best NotifyTop trips when it's over
+	Notifier  Notifier
+	NotifyTop int
+	// Costs, if set, go on every job's trips, out of the CostTable file
+	Costs     []CostComponent
+	CostTable string
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	wg   sync.WaitGroup
+}
+
+func NewJobManager(ss SkyScanner, limiter *RateLimiter) *JobManager {
+	if limiter == nil {
+		limiter = NewRateLimiter(DefaultRequestsPerMinute)
+	}
+	return &JobManager{
+		ss:      ss,
+		limiter: limiter,
+		jobs:    map[string]*Job{},
+	}
+}
+
+// Catalog is the provider's catalog, what searches pick destinations from
+func (m *JobManager) Catalog() *Catalog {
+	return m.ss.Catalog()
+}
+
+// Start checks req and kicks it off in the background
+func (m *JobManager) Start(req *SearchRequest) (JobView, error) {
+	spec, err := req.Spec(m.ss.Catalog())
+	if err != nil {
+		return JobView{}, err
+	}
+	spec.Costs, spec.CostTable = m.Costs, m.CostTable
+	id, err := newUUID()
+	if err != nil {
+		return JobView{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        id,
+		Request:   req,
+		Status:    JobQueued,
+		CreatedAt: time.Now().UTC(),
+		spec:      spec,
+		cancel:    cancel,
+	}
+
+	m.mu.Lock()
+	m.jobs[id] = job
+	view := job.view()
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.run(ctx, job)
+
+	return view, nil
+}
+
+func (m *JobManager) run(ctx context.Context, job *Job) {
+	defer m.wg.Done()
+	defer job.cancel()
+
+	m.mu.Lock()
+	job.Status = JobRunning
+	job.StartedAt = time.Now().UTC()
+	m.mu.Unlock()
+
+	engine := NewEngine(m.ss, m.limiter)
+	if m.Configure != nil {
+		m.Configure(engine)
+	}
+	engine.Checkpoint = func(r *SearchResult) {
+		m.mu.Lock()
+		job.result = snapshotResult(r)
+		m.mu.Unlock()
+	}
+	watchers := SubscriberFunc(func(ev Event) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for ch := range job.watchers {
+			// a watcher that can't keep up misses events rather than
+			// holding the search up
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	})
+	engine.Subscriber = watchers
+
+	var rec *RunRecorder
+	if m.Store != nil {
+		var err error
+		if rec, err = m.Store.Begin(job.ID, "serve", job.spec); err != nil {
+			// the search is still worth running without the history
+			fmt.Println(err.Error())
+		} else {
+			checkpoint := engine.Checkpoint
+			engine.Checkpoint = func(r *SearchResult) {
+				checkpoint(r)
+				rec.Checkpoint(r)
+			}
+			engine.Subscriber = Subscribers{watchers, rec}
+		}
+	}
+
+	result, err := engine.Run(ctx, job.spec)
+	if rec != nil {
+		if err := rec.Finish(ctx, result, err); err != nil {
+			fmt.Println("err saving run:", err.Error())
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.result = snapshotResult(result)
+	job.FinishedAt = time.Now().UTC()
+	job.Status = FinishedStatus(ctx, err)
+	if job.Status == JobFailed {
+		job.Error = err.Error()
+	}
+	if m.Notifier != nil && result != nil {
+		n := NewResultsNotification(NewReport(job.spec.Travelers, result.Trips, job.spec.OutboundDate, job.spec.InboundDate), job.ID, job.Status, m.NotifyTop)
+		// the job's own ctx is done by now one way or another, and this
+		// shouldn't hold the lock while it's out on the network
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := m.Notifier.Notify(ctx, n); err != nil {
+				fmt.Println("err sending results:", err.Error())
+			}
+		}()
+	}
+	for ch := range job.watchers {
+		close(ch)
+	}
+	job.watchers = nil
+}
+
+// the engine keeps going with its result after a checkpoint, so jobs hold a
+// copy. trips aren't touched again once they're in there
+func snapshotResult(r *SearchResult) *SearchResult {
+	if r == nil {
+		return nil
+	}
+	res := *r
+	res.Trips = make(map[string]*Trip, len(r.Trips))
+	for k, v := range r.Trips {
+		res.Trips[k] = v
+	}
+	res.Pruned = append([]string{}, r.Pruned...)
+	res.Skipped = append([]string{}, r.Skipped...)
+	return &res
+}
+
+// Get is one job
+func (m *JobManager) Get(id string) (JobView, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return JobView{}, false
+	}
+	return job.view(), true
+}
+
+// List is every job, newest first
+func (m *JobManager) List() []JobView {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	views := make([]JobView, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		views = append(views, job.view())
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].CreatedAt.After(views[j].CreatedAt)
+	})
+	return views
+}
+
+// Watch gets the job's events as they happen, along with where it's at now.
+// The channel is closed when the job finishes, straight away if it already
+// has, or on stop
+func (m *JobManager) Watch(id string) (view JobView, events <-chan Event, stop func(), ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return JobView{}, nil, nil, false
+	}
+	ch := make(chan Event, 64)
+	if !job.FinishedAt.IsZero() {
+		close(ch)
+		return job.view(), ch, func() {}, true
+	}
+	if job.watchers == nil {
+		job.watchers = map[chan Event]bool{}
+	}
+	job.watchers[ch] = true
+	stop = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if job.watchers[ch] {
+			delete(job.watchers, ch)
+			close(ch)
+		}
+	}
+	return job.view(), ch, stop, true
+}
+
+// Cancel stops a job. It's false if there's no such job
+func (m *JobManager) Cancel(id string) bool {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if ok {
+		job.cancel()
+	}
+	return ok
+}
+
+// Report is the job's trips so far, ranked
+func (m *JobManager) Report(id string) (*Report, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	trips := map[string]*Trip{}
+	if job.result != nil {
+		trips = job.result.Trips
+	}
+	return NewReport(job.spec.Travelers, trips, job.spec.OutboundDate, job.spec.InboundDate), true
+}
+
+// Trip is one of the job's trips
+func (m *JobManager) Trip(id, placeID string) (*Trip, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.result == nil {
+		return nil, false
+	}
+	trip, ok := job.result.Trips[placeID]
+	return trip, ok
+}
+
+// Shortlist is the job's best n complete trips so far, what gets voted on
+func (m *JobManager) Shortlist(id string, n int) ([]*Trip, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return job.shortlist(n), true
+}
+
+func (j *Job) shortlist(n int) []*Trip {
+	if j.result == nil {
+		return []*Trip{}
+	}
+	return Shortlist(RankTrips(j.result.Trips), n)
+}
+
+// CastBallot records a traveler's vote on the job, replacing any they cast
+// before. It's false if there's no such job
+func (m *JobManager) CastBallot(id string, b Ballot) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return false, nil
+	}
+	if _, ok := job.spec.Travelers[b.Traveler]; !ok {
+		return true, fmt.Errorf("%q isn't on this trip", b.Traveler)
+	}
+	if job.ballots == nil {
+		job.ballots = map[string]Ballot{}
+	}
+	job.ballots[b.Traveler] = b
+	return true, nil
+}
+
+// Ballots is every vote cast on the job, by traveler name
+func (m *JobManager) Ballots(id string) ([]Ballot, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return job.sortedBallots(), true
+}
+
+func (j *Job) sortedBallots() []Ballot {
+	ballots := []Ballot{}
+	for _, b := range j.ballots {
+		ballots = append(ballots, b)
+	}
+	sort.Slice(ballots, func(i, k int) bool { return ballots[i].Traveler < ballots[k].Traveler })
+	return ballots
+}
+
+// Vote counts the ballots cast so far over the job's shortlist of n
+func (m *JobManager) Vote(id string, n int, method VoteMethod) (*VoteResult, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false, nil
+	}
+	travelers := []string{}
+	for name := range job.spec.Travelers {
+		travelers = append(travelers, name)
+	}
+	res, err := Vote(job.shortlist(n), travelers, job.sortedBallots(), method)
+	return res, true, err
+}
+
+// Shutdown cancels every job and waits for them to wind down, or for ctx
+func (m *JobManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	for _, job := range m.jobs {
+		job.cancel()
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
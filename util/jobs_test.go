@@ -0,0 +1,135 @@
+package util
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+var jobPlaces = []Location{
+	{PlaceID: "CUN-sky", PlaceName: "Cancun", CountryID: "MX-sky", CityID: "CUNA-sky"},
+	{PlaceID: "HAV-sky", PlaceName: "Havana", CountryID: "CU-sky", CityID: "HAVA-sky"},
+}
+
+// Cancun is 300 all in and Havana 270
+var jobFares = map[string]map[string]float64{
+	"DEN-sky": {"CUN-sky": 100, "HAV-sky": 150},
+	"JFK-sky": {"CUN-sky": 200, "HAV-sky": 120},
+}
+
+// gatedProvider holds every poll until gate is closed, so a test can get in
+// before the search is over
+type gatedProvider struct {
+	*fareProvider
+	gate chan struct{}
+}
+
+func (p *gatedProvider) PollSession(sessionKey, departureAirport, destinationAirport, placeName string) (*PricingOption, error) {
+	<-p.gate
+	return p.fareProvider.PollSession(sessionKey, departureAirport, destinationAirport, placeName)
+}
+
+func testJobs(ss SkyScanner) *JobManager {
+	m := NewJobManager(ss, NewRateLimiter(0))
+	m.Configure = func(e *Engine) {
+		e.RetryDelay = 0
+		e.MaxNoLegs = 0
+		e.Log = ioutil.Discard
+	}
+	return m
+}
+
+func testRequest() *SearchRequest {
+	return &SearchRequest{
+		Travelers: []*Traveler{NewTraveler("alice", "DEN-sky"), NewTraveler("bob", "JFK-sky")},
+		Outbound:  "2020-01-01",
+		Inbound:   "2020-01-05",
+	}
+}
+
+// waitJob drains the job's events until it's over and returns how it ended
+func waitJob(t *testing.T, m *JobManager, id string) JobView {
+	t.Helper()
+	_, events, stop, ok := m.Watch(id)
+	if !ok {
+		t.Fatalf("no job %s", id)
+	}
+	defer stop()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case _, open := <-events:
+			if !open {
+				view, _ := m.Get(id)
+				return view
+			}
+		case <-timeout:
+			t.Fatalf("job %s never finished", id)
+		}
+	}
+}
+
+func TestJobManagerRunsASearch(t *testing.T) {
+	m := testJobs(newFareProvider(jobPlaces, jobFares))
+	started, err := m.Start(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started.Status != JobQueued {
+		t.Errorf("new job is %s, want queued", started.Status)
+	}
+
+	view := waitJob(t, m, started.ID)
+	if view.Status != JobDone || view.FinishedAt == nil {
+		t.Fatalf("job ended %+v, want done", view)
+	}
+	if view.Cheapest == nil || view.Cheapest.Destination != "HAV-sky" || view.Cheapest.Total != 270 {
+		t.Errorf("cheapest %+v, want Havana at 270", view.Cheapest)
+	}
+	if view.Progress.Planned != 2 || view.Progress.Searched != 2 {
+		t.Errorf("progress %+v, want 2 of 2", view.Progress)
+	}
+
+	report, ok := m.Report(started.ID)
+	if !ok || len(report.Trips) != 2 || report.Trips[0].Destination != "HAV-sky" {
+		t.Errorf("report %+v, want Havana then Cancun", report)
+	}
+	if list := m.List(); len(list) != 1 || list[0].ID != started.ID {
+		t.Errorf("list %+v, want just the one job", list)
+	}
+	if _, ok := m.Get("nope"); ok {
+		t.Errorf("found a job that isn't there")
+	}
+}
+
+func TestJobManagerTurnsAwayBadRequests(t *testing.T) {
+	m := testJobs(newFareProvider(jobPlaces, jobFares))
+	req := testRequest()
+	req.Outbound = "next week"
+	if _, err := m.Start(req); err == nil {
+		t.Errorf("started a search with no real dates")
+	}
+	if len(m.List()) != 0 {
+		t.Errorf("a bad request left a job behind")
+	}
+}
+
+func TestJobManagerCancel(t *testing.T) {
+	ss := &gatedProvider{newFareProvider(jobPlaces, jobFares), make(chan struct{})}
+	m := testJobs(ss)
+	started, err := m.Start(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Cancel(started.ID) {
+		t.Fatal("couldn't cancel the job")
+	}
+	close(ss.gate)
+
+	if view := waitJob(t, m, started.ID); view.Status != JobCanceled {
+		t.Errorf("job ended %s, want canceled", view.Status)
+	}
+	if m.Cancel("nope") {
+		t.Errorf("canceled a job that isn't there")
+	}
+}
@@ -0,0 +1,29 @@
+package util
+
+// LocalFare is how a traveler gets to dst without flying, if they can: it's
+// their home airport or the city it's in, which is free, or another airport
+// in the same city, which costs groundCost. The catalog is needed to know
+// what city home is in
+func LocalFare(catalog *Catalog, traveler *Traveler, dst Location, groundCost float64) (Fare, bool) {
+	fare := Fare{
+		From:  traveler.LocationCode,
+		To:    dst.PlaceID,
+		Local: true,
+	}
+	if traveler.LocationCode == dst.PlaceID {
+		return fare, true
+	}
+
+	home, ok := catalog.Place(traveler.LocationCode)
+	if !ok || home.CityID == "" {
+		return Fare{}, false
+	}
+	if home.CityID == dst.PlaceID {
+		return fare, true
+	}
+	if home.CityID == dst.CityID {
+		fare.Price = groundCost
+		return fare, true
+	}
+	return Fare{}, false
+}
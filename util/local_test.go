@@ -0,0 +1,129 @@
+package util
+
+import (
+	"context"
+	"testing"
+)
+
+// nyc lives by JFK, den by DEN. EWR is the other New York airport and PHL is
+// somewhere both have to fly to, cheap enough to never be pruned
+var localPlaces = []Location{
+	{PlaceID: "NYCA-sky", PlaceName: "New York", CountryID: "US-sky", CityID: "NYCA-sky"},
+	{PlaceID: "JFK-sky", PlaceName: "New York John F. Kennedy", CountryID: "US-sky", CityID: "NYCA-sky"},
+	{PlaceID: "EWR-sky", PlaceName: "New York Newark", CountryID: "US-sky", CityID: "NYCA-sky"},
+	{PlaceID: "DEN-sky", PlaceName: "Denver International", CountryID: "US-sky", CityID: "DENA-sky"},
+	{PlaceID: "PHL-sky", PlaceName: "Philadelphia International", CountryID: "US-sky", CityID: "PHLA-sky"},
+}
+
+const localGroundCost = 30
+
+// the api will quote nyc a flight to the other airport in town, none of these
+// should ever be what nyc pays
+var localFares = map[string]map[string]float64{
+	"JFK-sky": {"NYCA-sky": 400, "EWR-sky": 500, "PHL-sky": 90},
+	"DEN-sky": {"NYCA-sky": 200, "JFK-sky": 210, "EWR-sky": 220, "PHL-sky": 50},
+}
+
+var localCases = []struct {
+	name string
+	dst  string
+	// want is what nyc pays, and den's fare to dst
+	want, den float64
+}{
+	{"home airport", "JFK-sky", 0, 210},
+	{"home city", "NYCA-sky", 0, 200},
+	{"same city airport", "EWR-sky", localGroundCost, 220},
+}
+
+func localTravelers() map[string]*Traveler {
+	return map[string]*Traveler{
+		"nyc": NewTraveler("nyc", "JFK-sky"),
+		"den": NewTraveler("den", "DEN-sky"),
+	}
+}
+
+func TestLocalFare(t *testing.T) {
+	catalog := NewCatalog(localPlaces)
+	nyc := NewTraveler("nyc", "JFK-sky")
+	for _, c := range localCases {
+		t.Run(c.name, func(t *testing.T) {
+			dst, _ := catalog.Place(c.dst)
+			fare, ok := LocalFare(catalog, nyc, dst, localGroundCost)
+			if !ok || !fare.Local || fare.Price != c.want {
+				t.Errorf("got %+v, %v, want a local fare of %v", fare, ok, c.want)
+			}
+		})
+	}
+
+	phl, _ := catalog.Place("PHL-sky")
+	if fare, ok := LocalFare(catalog, nyc, phl, localGroundCost); ok {
+		t.Errorf("PHL isn't local to JFK, got %+v", fare)
+	}
+}
+
+func TestEstimateDestinationsLocals(t *testing.T) {
+	catalog := NewCatalog(localPlaces)
+	quotes := map[string][]Quote{}
+	for origin, fares := range localFares {
+		for dst, price := range fares {
+			quotes[origin] = append(quotes[origin], Quote{Origin: origin, Destination: dst, MinPrice: price})
+		}
+	}
+
+	for _, c := range localCases {
+		t.Run(c.name, func(t *testing.T) {
+			dst, _ := catalog.Place(c.dst)
+			estimates := EstimateDestinations(localTravelers(), quotes, catalog, []Location{dst}, localGroundCost)
+			if len(estimates) != 1 {
+				t.Fatalf("got %d estimates, want 1", len(estimates))
+			}
+			e := estimates[0]
+			if len(e.Missing) != 0 {
+				t.Errorf("missing %v", e.Missing)
+			}
+			if e.Fares["nyc"] != c.want || e.Total != c.want+c.den {
+				t.Errorf("nyc %v total %v, want nyc %v total %v", e.Fares["nyc"], e.Total, c.want, c.want+c.den)
+			}
+		})
+	}
+}
+
+func TestRunLocals(t *testing.T) {
+	// one destination a session, then everything in one batched session
+	for path, batchBy := range map[string]string{"single": "", "batched": "country"} {
+		for _, c := range localCases {
+			t.Run(path+" "+c.name, func(t *testing.T) {
+				ss := newFareProvider(localPlaces, localFares)
+				dst, _ := ss.Catalog().Place(c.dst)
+				phl, _ := ss.Catalog().Place("PHL-sky")
+				spec := &SearchSpec{
+					Travelers:    localTravelers(),
+					OutboundDate: "2020-01-01",
+					InboundDate:  "2020-01-05",
+					Destinations: []Location{dst, phl},
+					BatchBy:      batchBy,
+					GroundCost:   localGroundCost,
+				}
+
+				result, err := testEngine(ss).Run(context.Background(), spec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				trip := result.Trips[c.dst]
+				if trip == nil || !trip.Complete() {
+					t.Fatalf("%s should be complete, got %+v", c.dst, trip)
+				}
+				if leg := trip.Legs["nyc"]; !leg.Local || leg.Price != c.want {
+					t.Errorf("nyc got %+v, want a local fare of %v", leg, c.want)
+				}
+				if trip.Total() != c.want+c.den {
+					t.Errorf("total %v, want %v", trip.Total(), c.want+c.den)
+				}
+				// nyc only ever needed the api for PHL
+				if ss.polls["JFK-sky"] != 1 {
+					t.Errorf("nyc was polled %d times, want 1", ss.polls["JFK-sky"])
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package util
+
+import "fmt"
+
+// Objective is one thing a trip can be good at, lower is better. Value is
+// false when the trip doesn't have what it'd take to say, ie flight times
+type Objective struct {
+	Name string
+	// Label is how it reads when a trip wins it
+	Label string
+	Value func(t ReportTrip) (float64, bool)
+}
+
+// ParetoObjectives is what trips get weighed on for the front: what the
+// group pays all in, what the unluckiest one pays for their fare, and time in
+// the air for everybody and for whoever has the longest of it
+var ParetoObjectives = []Objective{
+	{
+		Name:  "total",
+		Label: "cheapest for the group",
+		Value: func(t ReportTrip) (float64, bool) { return t.Cost, true },
+	},
+	{
+		Name:  "worst_fare",
+		Label: "lowest top fare",
+		Value: func(t ReportTrip) (float64, bool) { return t.WorstFare, true },
+	},
+	{
+		Name:  "travel_time",
+		Label: "least flying all told",
+		Value: func(t ReportTrip) (float64, bool) { return minutesValue(t.TravelMinutes) },
+	},
+	{
+		Name:  "max_travel_time",
+		Label: "shortest longest journey",
+		Value: func(t ReportTrip) (float64, bool) { return minutesValue(t.MaxTravelMinutes) },
+	},
+}
+
+func minutesValue(m *int) (float64, bool) {
+	if m == nil {
+		return 0, false
+	}
+	return float64(*m), true
+}
+
+// markPareto works out which complete trips no other complete trip beats on
+// every objective at once, and what each is the best at. Partial trips are
+// left out since their costs don't cover everybody. An objective only counts
+// when every complete trip has a value for it, so a few fares without flight
+// times drop time for the lot rather than skewing it
+func (r *Report) markPareto() {
+	r.Objectives = []string{}
+	complete := []int{}
+	for i := range r.Trips {
+		if r.Trips[i].Complete {
+			complete = append(complete, i)
+		}
+	}
+	if len(complete) == 0 {
+		return
+	}
+
+	objectives := []Objective{}
+	for _, o := range ParetoObjectives {
+		ok := true
+		for _, i := range complete {
+			if _, has := o.Value(r.Trips[i]); !has {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			objectives = append(objectives, o)
+			r.Objectives = append(r.Objectives, o.Name)
+		}
+	}
+
+	values := make([][]float64, len(r.Trips))
+	for _, i := range complete {
+		values[i] = make([]float64, len(objectives))
+		for k, o := range objectives {
+			values[i][k], _ = o.Value(r.Trips[i])
+		}
+	}
+	best := make([]float64, len(objectives))
+	for k := range objectives {
+		for n, i := range complete {
+			if n == 0 || values[i][k] < best[k] {
+				best[k] = values[i][k]
+			}
+		}
+	}
+
+	for _, i := range complete {
+		r.Trips[i].Pareto = true
+		for _, j := range complete {
+			if i != j && dominates(values[j], values[i]) {
+				r.Trips[i].Pareto = false
+				break
+			}
+		}
+		for k, o := range objectives {
+			if values[i][k] == best[k] {
+				r.Trips[i].Wins = append(r.Trips[i].Wins, o.Name)
+			}
+		}
+	}
+}
+
+// dominates is a at least as good as b on everything and better on something
+func dominates(a, b []float64) bool {
+	better := false
+	for k := range a {
+		if a[k] > b[k] {
+			return false
+		}
+		if a[k] < b[k] {
+			better = true
+		}
+	}
+	return better
+}
+
+// Front is the trips on the Pareto front, in report order
+func (r *Report) Front() []ReportTrip {
+	front := []ReportTrip{}
+	for _, t := range r.Trips {
+		if t.Pareto {
+			front = append(front, t)
+		}
+	}
+	return front
+}
+
+// WinLabels is Wins the way people say it
+func (t ReportTrip) WinLabels() []string {
+	labels := []string{}
+	for _, name := range t.Wins {
+		for _, o := range ParetoObjectives {
+			if o.Name == name {
+				labels = append(labels, o.Label)
+			}
+		}
+	}
+	return labels
+}
+
+// Tradeoffs is the trip's numbers for each objective in a line, "top fare
+// $412.00, 31h20m flying, longest 7h05m"
+func (t ReportTrip) Tradeoffs() string {
+	s := fmt.Sprintf("top fare $%.2f", t.WorstFare)
+	if t.TravelMinutes != nil {
+		s += ", " + durationLabel(*t.TravelMinutes) + " flying"
+	}
+	if t.MaxTravelMinutes != nil {
+		s += ", longest " + durationLabel(*t.MaxTravelMinutes)
+	}
+	return s
+}
+
+func durationLabel(minutes int) string {
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
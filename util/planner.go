@@ -0,0 +1,129 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrBudgetSpent is what a search hits when it has made every api call its
+// budget allows
+var ErrBudgetSpent = errors.New("api call budget spent")
+
+// rough fare for a flight when there's no quote to go on, from distance alone.
+// only used to decide search order, never reported as a price
+const (
+	guessBaseFare    = 50.0
+	guessFarePerMile = 0.1
+)
+
+// callBudget counts the api calls one search makes. limit 0 is no limit
+type callBudget struct {
+	limit int
+	used  int
+	// pause, if set, hears about it before the rate limiter holds a call up
+	pause func(time.Duration)
+}
+
+func (b *callBudget) take() bool {
+	if b.limit > 0 && b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// left is how many calls are left, or -1 for no limit
+func (b *callBudget) left() int {
+	if b.limit <= 0 {
+		return -1
+	}
+	return b.limit - b.used
+}
+
+// wait is the rate limiter plus the budget: every api call goes through here
+func (e *Engine) wait(ctx context.Context, b *callBudget) error {
+	if !b.take() {
+		return ErrBudgetSpent
+	}
+	if wait := e.limiter.Delay(); wait > 0 && b.pause != nil {
+		b.pause(wait)
+	}
+	_, err := e.limiter.Wait(ctx)
+	return err
+}
+
+// planDestinations is the cheap first pass of a budgeted search. Every
+// destination gets an estimated fare per traveler: the cached quote when
+// there is one, otherwise a guess from how far apart the airports are. Those
+// with neither are left missing. The result is in the order worth spending
+// live calls on, best first
+func planDestinations(travelers map[string]*Traveler, destinations []Location, estimates []*Estimate, catalog *Catalog, groundCost float64) []*Estimate {
+	quoted := map[string]*Estimate{}
+	for _, est := range estimates {
+		quoted[est.Destination.PlaceID] = est
+	}
+
+	plan := []*Estimate{}
+	for _, dst := range destinations {
+		est := &Estimate{
+			Destination: dst,
+			Fares:       map[string]float64{},
+			Missing:     []string{},
+		}
+		q := quoted[dst.PlaceID]
+
+		for _, traveler := range travelers {
+			if q != nil {
+				if fare, ok := q.Fares[traveler.Name]; ok {
+					est.Fares[traveler.Name] = fare
+					est.Total += fare
+					continue
+				}
+			}
+			if fare, ok := LocalFare(catalog, traveler, dst, groundCost); ok {
+				est.Fares[traveler.Name] = fare.Price
+				est.Total += fare.Price
+				continue
+			}
+			home, ok := catalog.Place(traveler.LocationCode)
+			if !ok || !home.HasCoords() || !dst.HasCoords() {
+				est.Missing = append(est.Missing, traveler.Name)
+				continue
+			}
+			fare := guessBaseFare + guessFarePerMile*GreatCircleMiles(home, dst)
+			est.Fares[traveler.Name] = fare
+			est.Total += fare
+		}
+		sort.Strings(est.Missing)
+		plan = append(plan, est)
+	}
+
+	sortEstimates(plan)
+	return plan
+}
+
+// travelerOrder puts the most expensive estimated legs first so a destination
+// that isn't going to win gets found out, and pruned, in as few calls as
+// possible. Travelers with no estimate go last
+func travelerOrder(travelers map[string]*Traveler, est *Estimate) []*Traveler {
+	res := make([]*Traveler, 0, len(travelers))
+	for _, traveler := range travelers {
+		res = append(res, traveler)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if est != nil {
+			fi, iok := est.Fares[res[i].Name]
+			fj, jok := est.Fares[res[j].Name]
+			if iok != jok {
+				return iok
+			}
+			if fi != fj {
+				return fi > fj
+			}
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
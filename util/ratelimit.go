@@ -0,0 +1,79 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultRequestsPerMinute is our best guess. skyscanner won't say what the
+// limit is and doesn't tell you when you hit it
+const DefaultRequestsPerMinute = 60
+
+// RateLimiter lets at most limit requests through per window. It's safe to
+// share, and should be: every search hitting the api has to go through the
+// same one or they'll trip the limit for each other
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	start  time.Time
+	count  int
+}
+
+// NewRateLimiter allows perMinute requests a minute. 0 means no limit
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{
+		limit:  perMinute,
+		window: time.Minute,
+	}
+}
+
+// Wait blocks until a request is allowed and counts it. It returns how long it
+// had to wait, or ctx's error if it gave up first
+func (r *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	began := time.Now()
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		if now.Sub(r.start) >= r.window {
+			r.start = now
+			r.count = 0
+		}
+		if r.limit <= 0 || r.count < r.limit {
+			r.count++
+			r.mu.Unlock()
+			return time.Since(began), nil
+		}
+		wait := r.window - now.Sub(r.start)
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return time.Since(began), ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Delay is how long a Wait made now would block, near enough. Other callers
+// can get in first so it's a guess, good for telling people, not for timing
+func (r *RateLimiter) Delay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elapsed := time.Since(r.start)
+	if r.limit <= 0 || elapsed >= r.window || r.count < r.limit {
+		return 0
+	}
+	return r.window - elapsed
+}
+
+// Remaining is how many requests are left in the current window
+func (r *RateLimiter) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.start) >= r.window {
+		return r.limit
+	}
+	return r.limit - r.count
+}
@@ -0,0 +1,403 @@
+package util
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReportSchemaVersion is bumped whenever the json report changes shape. The
+// schema itself is in report.schema.json next to this file
+const ReportSchemaVersion = 5
+
+// Report is a finished search laid out for people: every trip, who flies
+// from where for how much, and the link to book it
+type Report struct {
+	SchemaVersion int          `json:"schema_version"`
+	GeneratedAt   time.Time    `json:"generated_at"`
+	OutboundDate  string       `json:"outbound_date"`
+	InboundDate   string       `json:"inbound_date"`
+	Travelers     []string     `json:"travelers"`
+	Trips         []ReportTrip `json:"trips"`
+	// Objectives is what the Pareto front was worked out over, see
+	// ParetoObjectives
+	Objectives []string `json:"objectives"`
+}
+
+// ReportTrip is one destination. It's Complete when everybody has a fare,
+// otherwise Total only covers the ones that do and Failures says why the
+// rest don't. Cost is Total and Ground, what trips are ranked by
+type ReportTrip struct {
+	Destination string          `json:"destination"`
+	PlaceName   string          `json:"place_name"`
+	Total       float64         `json:"total"`
+	Ground      []CostItem      `json:"ground"`
+	Cost        float64         `json:"cost"`
+	Complete    bool            `json:"complete"`
+	Legs        []ReportLeg     `json:"legs"`
+	Missing     []string        `json:"missing"`
+	Failures    []ReportFailure `json:"failures"`
+	// WorstFare is the most any one traveler pays. TravelMinutes is
+	// everybody's flights added up and MaxTravelMinutes the longest anyone's,
+	// both nil when someone's flight times aren't known
+	WorstFare        float64 `json:"worst_fare"`
+	TravelMinutes    *int    `json:"travel_minutes,omitempty"`
+	MaxTravelMinutes *int    `json:"max_travel_minutes,omitempty"`
+	// Pareto is set on complete trips that no other complete trip beats on
+	// every objective at once, and Wins is the objectives it's the best at
+	Pareto bool     `json:"pareto"`
+	Wins   []string `json:"wins"`
+}
+
+// ReportLeg is one traveler's flight, or for a Local traveler their ground
+// transport
+type ReportLeg struct {
+	Traveler string  `json:"traveler"`
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Fare     float64 `json:"fare"`
+	Link     string  `json:"link"`
+	Local    bool    `json:"local"`
+	// TravelMinutes is the flights there and back, nil when they're not known
+	TravelMinutes *int `json:"travel_minutes,omitempty"`
+}
+
+// ReportFailure is a traveler with no fare and why
+type ReportFailure struct {
+	Traveler string        `json:"traveler"`
+	Reason   FailureReason `json:"reason"`
+	Attempts int           `json:"attempts"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Describe is the failure in a few words, "rate_limited after 10 attempts"
+func (f ReportFailure) Describe() string {
+	if f.Attempts == 0 {
+		return string(f.Reason)
+	}
+	return fmt.Sprintf("%s after %d attempts", f.Reason, f.Attempts)
+}
+
+// Route is the leg as "DEN-sky -> CUN-sky"
+func (l ReportLeg) Route() string {
+	if l.Local && l.Fare == 0 {
+		return "already there"
+	}
+	if l.Local {
+		return l.From + " -> " + l.To + " by ground"
+	}
+	return l.From + " -> " + l.To
+}
+
+// NewReport lays out trips (keyed by destination, as the engine makes them)
+// for people. Complete trips come first, cheapest all in first
+func NewReport(travelers map[string]*Traveler, trips map[string]*Trip, outboundDate, inboundDate string) *Report {
+	r := &Report{
+		SchemaVersion: ReportSchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		OutboundDate:  outboundDate,
+		InboundDate:   inboundDate,
+		Travelers:     []string{},
+		Trips:         []ReportTrip{},
+		Objectives:    []string{},
+	}
+	for name := range travelers {
+		r.Travelers = append(r.Travelers, name)
+	}
+	sort.Strings(r.Travelers)
+
+	for _, t := range trips {
+		trip := ReportTrip{
+			Destination: t.Destination.PlaceID,
+			PlaceName:   t.Destination.PlaceName,
+			Total:       t.Total(),
+			Ground:      append([]CostItem{}, t.Costs...),
+			Cost:        t.Cost(),
+			Complete:    t.Complete(),
+			Legs:        []ReportLeg{},
+			Missing:     append([]string{}, t.Missing...),
+			Failures:    []ReportFailure{},
+			Wins:        []string{},
+		}
+		for _, name := range t.Missing {
+			f := t.Failures[name]
+			trip.Failures = append(trip.Failures, ReportFailure{
+				Traveler: name,
+				Reason:   f.Reason,
+				Attempts: f.Attempts,
+				Error:    f.Error,
+			})
+		}
+		for _, name := range t.Travelers() {
+			leg := t.Legs[name]
+			trip.Legs = append(trip.Legs, ReportLeg{
+				Traveler: name,
+				From:     leg.From,
+				To:       leg.To,
+				Fare:     leg.Price,
+				Link:     leg.Link,
+				Local:    leg.Local,
+			})
+		}
+		trip.addTravelTimes(t)
+		r.Trips = append(r.Trips, trip)
+	}
+
+	sort.Slice(r.Trips, func(i, j int) bool {
+		if r.Trips[i].Complete != r.Trips[j].Complete {
+			return r.Trips[i].Complete
+		}
+		if r.Trips[i].Cost != r.Trips[j].Cost {
+			return r.Trips[i].Cost < r.Trips[j].Cost
+		}
+		return r.Trips[i].Destination < r.Trips[j].Destination
+	})
+	r.markPareto()
+
+	return r
+}
+
+// GroundLine is the trip's costs once there in a line, "$1200.00 in fares,
+// $640.00 on the ground: lodging $480.00 (4 nights x 2 people x $60.00)".
+// Empty when there aren't any
+func (t ReportTrip) GroundLine() string {
+	if len(t.Ground) == 0 {
+		return ""
+	}
+	items := []string{}
+	for _, c := range t.Ground {
+		items = append(items, fmt.Sprintf("%s $%.2f (%s)", c.Name, c.Amount, c.Detail))
+	}
+	return fmt.Sprintf("$%.2f in fares, $%.2f on the ground: %s", t.Total, cents(t.Cost-t.Total), strings.Join(items, ", "))
+}
+
+// addTravelTimes fills in the worst fare and the flying times from t's fares
+func (rt *ReportTrip) addTravelTimes(t *Trip) {
+	total, longest, known := 0, 0, len(rt.Legs) > 0
+	for i := range rt.Legs {
+		leg := &rt.Legs[i]
+		if leg.Fare > rt.WorstFare {
+			rt.WorstFare = leg.Fare
+		}
+		minutes, ok := t.Legs[leg.Traveler].TravelMinutes()
+		if !ok {
+			known = false
+			continue
+		}
+		leg.TravelMinutes = &minutes
+		total += minutes
+		if minutes > longest {
+			longest = minutes
+		}
+	}
+	if known {
+		rt.TravelMinutes, rt.MaxTravelMinutes = &total, &longest
+	}
+}
+
+// ReportWriter renders a report in one format
+type ReportWriter func(w io.Writer, r *Report) error
+
+var reportWriters = map[string]ReportWriter{
+	"markdown": WriteMarkdownReport,
+	"csv":      WriteCSVReport,
+	"html":     WriteHTMLReport,
+	"json":     WriteJSONReport,
+}
+
+// RegisterReportFormat adds a format, or replaces one, for WriteReport
+func RegisterReportFormat(name string, w ReportWriter) {
+	reportWriters[name] = w
+}
+
+// ReportFormats is every format WriteReport knows, sorted
+func ReportFormats() []string {
+	names := []string{}
+	for name := range reportWriters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteReport renders r to w in the named format
+func WriteReport(w io.Writer, format string, r *Report) error {
+	write, ok := reportWriters[format]
+	if !ok {
+		return fmt.Errorf("unknown report format %q, want one of %s", format, strings.Join(ReportFormats(), ", "))
+	}
+	return write(w, r)
+}
+
+// WriteMarkdownReport is a table per trip, small enough to paste in the
+// group chat
+func WriteMarkdownReport(w io.Writer, r *Report) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "## Trips %s to %s\n", r.OutboundDate, r.InboundDate)
+	if front := r.Front(); len(front) > 1 {
+		b.WriteString("\n### Tradeoffs\n\nNone of these beats another on everything:\n\n")
+		for _, trip := range front {
+			fmt.Fprintf(b, "- **%s** $%.2f, %s", markdownEscape(trip.PlaceName), trip.Cost, trip.Tradeoffs())
+			if labels := trip.WinLabels(); len(labels) > 0 {
+				fmt.Fprintf(b, ": %s", strings.Join(labels, ", "))
+			}
+			b.WriteString("\n")
+		}
+	}
+	for _, trip := range r.Trips {
+		fmt.Fprintf(b, "\n### %s (%s): $%.2f", markdownEscape(trip.PlaceName), trip.Destination, trip.Cost)
+		if !trip.Complete {
+			fmt.Fprintf(b, " without %s", strings.Join(trip.Missing, ", "))
+		}
+		b.WriteString("\n")
+		if line := trip.GroundLine(); line != "" {
+			fmt.Fprintf(b, "\n%s\n", line)
+		}
+		if trip.Complete {
+			fmt.Fprintf(b, "\n%s", trip.Tradeoffs())
+			if labels := trip.WinLabels(); len(labels) > 0 {
+				fmt.Fprintf(b, ". Wins: %s", strings.Join(labels, ", "))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("\n| Traveler | Fare | Route | Link |\n|---|---:|---|---|\n")
+		for _, leg := range trip.Legs {
+			link := ""
+			if leg.Link != "" {
+				link = fmt.Sprintf("[book](%s)", leg.Link)
+			}
+			fmt.Fprintf(b, "| %s | $%.2f | %s | %s |\n", markdownEscape(leg.Traveler), leg.Fare, leg.Route(), link)
+		}
+		for _, f := range trip.Failures {
+			fmt.Fprintf(b, "| %s | | no fare: %s | |\n", markdownEscape(f.Traveler), markdownEscape(f.Describe()))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_").Replace(s)
+}
+
+// WriteCSVReport is one row per leg, trip columns repeated, for spreadsheets.
+// Missing legs get a row too, with no fare and the failure filled in
+func WriteCSVReport(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"destination", "place_name", "trip_total", "complete", "traveler", "from", "to", "fare", "link", "failure", "attempts",
+		"worst_fare", "trip_travel_minutes", "max_travel_minutes", "pareto", "wins", "travel_minutes", "ground_total", "trip_cost"})
+	for _, trip := range r.Trips {
+		tripCols := []string{
+			fmt.Sprintf("%.2f", trip.WorstFare),
+			minutesCol(trip.TravelMinutes),
+			minutesCol(trip.MaxTravelMinutes),
+			fmt.Sprintf("%t", trip.Pareto),
+			strings.Join(trip.Wins, ";"),
+		}
+		costCols := []string{
+			fmt.Sprintf("%.2f", cents(trip.Cost-trip.Total)),
+			fmt.Sprintf("%.2f", trip.Cost),
+		}
+		for _, leg := range trip.Legs {
+			cw.Write(append([]string{
+				trip.Destination,
+				trip.PlaceName,
+				fmt.Sprintf("%.2f", trip.Total),
+				fmt.Sprintf("%t", trip.Complete),
+				leg.Traveler,
+				leg.From,
+				leg.To,
+				fmt.Sprintf("%.2f", leg.Fare),
+				leg.Link,
+				"",
+				"",
+			}, append(append(tripCols, minutesCol(leg.TravelMinutes)), costCols...)...))
+		}
+		for _, f := range trip.Failures {
+			cw.Write(append([]string{
+				trip.Destination,
+				trip.PlaceName,
+				fmt.Sprintf("%.2f", trip.Total),
+				fmt.Sprintf("%t", trip.Complete),
+				f.Traveler,
+				"",
+				"",
+				"",
+				"",
+				string(f.Reason),
+				fmt.Sprintf("%d", f.Attempts),
+			}, append(append(tripCols, ""), costCols...)...))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func minutesCol(m *int) string {
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", *m)
+}
+
+// WriteJSONReport is the report as is. See report.schema.json
+func WriteJSONReport(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	// links are full of &s, leave them readable
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+// WriteHTMLReport is a single page with no outside assets, so it can be
+// mailed around or opened straight off disk
+func WriteHTMLReport(w io.Writer, r *Report) error {
+	return htmlReport.Execute(w, r)
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"money": func(f float64) string { return fmt.Sprintf("$%.2f", f) },
+	"join":  strings.Join,
+	"gt":    func(a, b int) bool { return a > b },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Trips {{.OutboundDate}} to {{.InboundDate}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+td.fare { text-align: right; }
+.partial { color: #a60; }
+.wins { color: #264; font-size: 0.9em; }
+.ground { color: #555; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>Trips {{.OutboundDate}} to {{.InboundDate}}</h1>
+<p>{{len .Trips}} destinations for {{join .Travelers ", "}}. Generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}.</p>
+{{with .Front}}{{if gt (len .) 1}}<h2>Tradeoffs</h2>
+<p>None of these beats another on everything.</p>
+<table>
+<tr><th>Destination</th><th>Total</th><th>Numbers</th><th>Wins</th></tr>
+{{range .}}<tr><td>{{.PlaceName}}</td><td class="fare">{{money .Cost}}</td><td>{{.Tradeoffs}}</td><td>{{join .WinLabels ", "}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{range .Trips}}
+<h2>{{.PlaceName}} ({{.Destination}}): {{money .Cost}}{{if not .Complete}} <span class="partial">without {{join .Missing ", "}}</span>{{end}}</h2>
+{{with .GroundLine}}<p class="ground">{{.}}</p>
+{{end}}{{if .Complete}}<p class="wins">{{.Tradeoffs}}{{with .WinLabels}}. Wins: {{join . ", "}}{{end}}</p>
+{{end}}<table>
+<tr><th>Traveler</th><th>Fare</th><th>Route</th><th>Link</th></tr>
+{{range .Legs}}<tr><td>{{.Traveler}}</td><td class="fare">{{money .Fare}}</td><td>{{.Route}}</td><td>{{if .Link}}<a href="{{.Link}}">book</a>{{end}}</td></tr>
+{{end}}{{range .Failures}}<tr class="partial"><td>{{.Traveler}}</td><td></td><td>no fare: {{.Describe}}</td><td></td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
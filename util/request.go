@@ -0,0 +1,182 @@
+package util
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	rapidAPIHost = "skyscanner-skyscanner-flight-search-v1.p.rapidapi.com"
+
+	browserUserAgent = "Mozilla/5.0 (X11; U; Linux i686) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.94 Safari/537.36 OPR/46.0.2137.58"
+)
+
+// RequestMiddleware decorates an outgoing request before it's sent. Returning an
+// error aborts the build
+type RequestMiddleware func(req *http.Request) error
+
+// RequestBuilder is the one place requests get made, for both the RapidAPI and
+// the web flows. Each flow is just a different stack of middleware, so a new
+// header or auth scheme only has to be added once
+type RequestBuilder struct {
+	middleware []RequestMiddleware
+}
+
+func NewRequestBuilder(middleware ...RequestMiddleware) *RequestBuilder {
+	return &RequestBuilder{
+		middleware: middleware,
+	}
+}
+
+// With returns a copy of the builder with more middleware stacked on top; the
+// receiver is left alone so it can be shared
+func (b *RequestBuilder) With(middleware ...RequestMiddleware) *RequestBuilder {
+	stack := make([]RequestMiddleware, 0, len(b.middleware)+len(middleware))
+	stack = append(stack, b.middleware...)
+	stack = append(stack, middleware...)
+	return &RequestBuilder{
+		middleware: stack,
+	}
+}
+
+// New builds a request and runs it through the middleware in order
+func (b *RequestBuilder) New(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("err building %s %s: %s", method, url, err.Error())
+	}
+
+	for _, m := range b.middleware {
+		if err := m(req); err != nil {
+			return nil, fmt.Errorf("err building %s %s: %s", method, url, err.Error())
+		}
+	}
+
+	return req, nil
+}
+
+// RapidAPIAuth injects the rapidapi host/key pair
+func RapidAPIAuth(host, key string) RequestMiddleware {
+	return func(req *http.Request) error {
+		if key == "" {
+			return fmt.Errorf("no rapidapi key configured")
+		}
+		req.Header.Set("x-rapidapi-host", host)
+		req.Header.Set("x-rapidapi-key", key)
+		return nil
+	}
+}
+
+// FormEncoded marks POST bodies as url encoded forms, which is all the pricing
+// endpoint understands
+func FormEncoded() RequestMiddleware {
+	return func(req *http.Request) error {
+		if req.Method == http.MethodPost {
+			req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		}
+		return nil
+	}
+}
+
+// Headers sets a fixed set of headers. Use it for header "profiles"
+func Headers(headers map[string]string) RequestMiddleware {
+	return func(req *http.Request) error {
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return nil
+	}
+}
+
+func UserAgent(ua string) RequestMiddleware {
+	return func(req *http.Request) error {
+		req.Header.Set("user-agent", ua)
+		return nil
+	}
+}
+
+// RequestID tags every request with a fresh id so a failure in the logs can be
+// matched up with what was sent
+func RequestID() RequestMiddleware {
+	return func(req *http.Request) error {
+		id, err := newUUID()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("x-request-id", id)
+		return nil
+	}
+}
+
+// BrowserHeaders is the header profile the skyscanner website sends from a
+// desktop browser. Anything tied to a particular session (utid, viewid) is
+// layered on separately. accept-encoding is left to net/http, setting it by
+// hand turns off transparent gzip and we'd get compressed bytes back
+func BrowserHeaders(origin string) map[string]string {
+	return map[string]string{
+		"accept":                                "application/json",
+		"cache-control":                         "no-cache",
+		"content-type":                          "application/json",
+		"origin":                                origin,
+		"pragma":                                "no-cache",
+		"x-skyscanner-channelid":                "website",
+		"x-skyscanner-devicedetection-ismobile": "false",
+		"x-skyscanner-devicedetection-istablet": "false",
+	}
+}
+
+// WebSession adds the per-visitor ids scraped off the view page. The viewid is
+// optional, the utid is not
+func WebSession(utid, viewID string) RequestMiddleware {
+	return func(req *http.Request) error {
+		if utid == "" {
+			return fmt.Errorf("web session needs a utid")
+		}
+		req.Header.Set("x-skyscanner-traveller-context", utid)
+		req.Header.Set("x-skyscanner-utid", utid)
+		if viewID != "" {
+			req.Header.Set("x-skyscanner-viewid", viewID)
+		}
+		return nil
+	}
+}
+
+// Referer is set per request since the website always refers back to the
+// results page for the route being searched
+func Referer(referer string) RequestMiddleware {
+	return func(req *http.Request) error {
+		req.Header.Set("referer", referer)
+		return nil
+	}
+}
+
+// rapidAPIRequests is the stack for the public rapidapi endpoints
+func rapidAPIRequests(key string) *RequestBuilder {
+	return NewRequestBuilder(
+		RequestID(),
+		RapidAPIAuth(rapidAPIHost, key),
+		FormEncoded(),
+	)
+}
+
+// webRequests is the stack for the private skyscanner.com endpoints
+func webRequests(origin string) *RequestBuilder {
+	return NewRequestBuilder(
+		RequestID(),
+		Headers(BrowserHeaders(origin)),
+		UserAgent(browserUserAgent),
+	)
+}
+
+// random v4 uuid, same shape skyscanner uses for its own ids
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("err generating uuid: %s", err.Error())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,596 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// SearchSpec is everything a search needs to know about the trip
+type SearchSpec struct {
+	Travelers    map[string]*Traveler
+	OutboundDate string
+	InboundDate  string
+	Destinations []Location
+
+	// Anywhere does a coarse first pass with cached browse quotes, one call
+	// per home airport, and only live prices the TopK best estimates. With no
+	// Destinations anything quoted is fair game
+	Anywhere bool
+	TopK     int
+
+	// BatchBy groups destinations in the same "city" or "country" into one
+	// session per traveler, polled for all of them at once. BatchSize caps
+	// how many airports go in one poll
+	BatchBy   string
+	BatchSize int
+
+	// Budget caps the api calls the whole search can make, 0 for no cap. With
+	// a budget the destinations are estimated first and searched best first,
+	// and any whose estimate is more than PlanSlack (0.25 is 25%) over the
+	// cheapest trip found so far are skipped
+	Budget    int
+	PlanSlack float64
+
+	// GroundCost is what it costs a traveler to get to a destination in their
+	// own city that isn't their home airport. Their home airport is free
+	GroundCost float64
+
+	// Costs is what a trip costs on top of the fares once everybody's there,
+	// and goes into its Score. CostTable is the file they came out of, if
+	// any, so the run can be redone with them
+	Costs     []CostComponent
+	CostTable string
+}
+
+// SearchResult is where a search got to. It's handed to Checkpoint after
+// every destination as well as returned at the end
+type SearchResult struct {
+	// Trips is keyed by destination PlaceID
+	Trips map[string]*Trip
+	// CheapestKey and Cheapest are the best complete trip so far. It's the
+	// bound everything else gets pruned against, so a trip missing legs
+	// never gets to be it
+	CheapestKey string
+	Cheapest    float64
+	Estimates   []*Estimate
+
+	// Planned is how many destinations are going to be searched, once that's
+	// been worked out, and Searched how many have been so far
+	Planned  int
+	Searched int
+
+	// Calls is how many api calls the search made. Pruned destinations were
+	// dropped part way through once they cost more than the cheapest trip,
+	// Skipped ones were never searched because of their estimate
+	Calls       int
+	Pruned      []string
+	Skipped     []string
+	OutOfBudget bool
+}
+
+// Engine runs searches against a provider. The CLI and anything else that
+// wants to search go through here, sharing the one rate limiter
+type Engine struct {
+	ss      SkyScanner
+	limiter *RateLimiter
+
+	// RetryDelay is the pause between attempts on the same leg
+	RetryDelay time.Duration
+	// MaxAttempts per leg, and how many "no pricing option" answers we put up
+	// with before giving up on it
+	MaxAttempts int
+	MaxNoLegs   int
+
+	// Checkpoint, if set, gets the results so far after each destination. The
+	// search takes forever so it's worth saving as we go
+	Checkpoint func(*SearchResult)
+	// Subscriber, if set, hears about everything as it happens
+	Subscriber Subscriber
+	// Log is where the running commentary goes, stdout unless changed
+	Log io.Writer
+}
+
+func NewEngine(ss SkyScanner, limiter *RateLimiter) *Engine {
+	if limiter == nil {
+		limiter = NewRateLimiter(DefaultRequestsPerMinute)
+	}
+	return &Engine{
+		ss:          ss,
+		limiter:     limiter,
+		RetryDelay:  1 * time.Second,
+		MaxAttempts: 10,
+		MaxNoLegs:   5,
+		Log:         os.Stdout,
+	}
+}
+
+func (e *Engine) logf(format string, a ...interface{}) {
+	fmt.Fprintf(e.Log, format, a...)
+}
+
+func (e *Engine) logln(a ...interface{}) {
+	fmt.Fprintln(e.Log, a...)
+}
+
+// emit fills in the time and the progress counters and hands ev on
+func (e *Engine) emit(result *SearchResult, budget *callBudget, ev Event) {
+	if e.Subscriber == nil {
+		return
+	}
+	ev.Time = time.Now().UTC()
+	ev.Planned, ev.Searched, ev.Calls = result.Planned, result.Searched, budget.used
+	e.Subscriber.Event(ev)
+}
+
+// priced adds traveler's fare to trip and tells the subscriber
+func (e *Engine) priced(result *SearchResult, budget *callBudget, trip *Trip, traveler string, fare Fare) {
+	trip.AddFare(traveler, fare)
+	e.emit(result, budget, Event{
+		Type:        EventLegPriced,
+		Destination: trip.Destination.PlaceID,
+		PlaceName:   trip.Destination.PlaceName,
+		Traveler:    traveler,
+		Fare:        &fare,
+	})
+}
+
+// failed marks traveler's leg of trip failed and tells the subscriber
+func (e *Engine) failed(result *SearchResult, budget *callBudget, trip *Trip, traveler string, f LegFailure) {
+	trip.Fail(traveler, f)
+	e.emit(result, budget, Event{
+		Type:        EventLegFailed,
+		Destination: trip.Destination.PlaceID,
+		PlaceName:   trip.Destination.PlaceName,
+		Traveler:    traveler,
+		Failure:     &f,
+	})
+}
+
+// Run searches every destination in the spec for every traveler. A
+// destination stops getting searched as soon as what it costs so far is
+// already more than the cheapest one found, it can't win from there
+func (e *Engine) Run(ctx context.Context, spec *SearchSpec) (_ *SearchResult, err error) {
+	result := &SearchResult{
+		Trips:    map[string]*Trip{},
+		Cheapest: 99999999.00, // arbitrary big number
+		Pruned:   []string{},
+		Skipped:  []string{},
+	}
+	budget := &callBudget{limit: spec.Budget}
+	budget.pause = func(wait time.Duration) {
+		e.emit(result, budget, Event{Type: EventRateLimitPause, WaitMs: wait.Milliseconds()})
+	}
+	defer func() {
+		result.Calls = budget.used
+		done := Event{Type: EventSearchDone}
+		if err != nil {
+			done.Error = err.Error()
+		}
+		e.emit(result, budget, done)
+	}()
+
+	priced := func(trip *Trip, traveler string, fare Fare) {
+		e.priced(result, budget, trip, traveler, fare)
+	}
+	failed := func(trip *Trip, traveler string, f LegFailure) {
+		e.failed(result, budget, trip, traveler, f)
+	}
+
+	destinations := spec.Destinations
+	plan := map[string]*Estimate{}
+	if spec.Anywhere || spec.Budget > 0 {
+		estimates, err := e.estimate(ctx, spec, budget)
+		if err != nil {
+			return result, e.stopped(result, err)
+		}
+		result.Estimates = estimates
+
+		if spec.Anywhere {
+			destinations = []Location{}
+			for _, est := range estimates {
+				if spec.TopK > 0 && len(destinations) >= spec.TopK {
+					break
+				}
+				destinations = append(destinations, est.Destination)
+			}
+			e.logf("browse quotes priced %d destinations, live searching the best %d\n", len(estimates), len(destinations))
+		}
+
+		if spec.Budget > 0 {
+			planned := planDestinations(spec.Travelers, destinations, estimates, e.ss.Catalog(), spec.GroundCost)
+			destinations = []Location{}
+			for _, est := range planned {
+				plan[est.Destination.PlaceID] = est
+				destinations = append(destinations, est.Destination)
+			}
+		}
+	}
+
+	result.Planned = len(destinations)
+	for _, batch := range batchDestinations(destinations, spec.BatchBy, spec.BatchSize) {
+		// with a budget, don't spend calls on places the estimates say are
+		// well out of the running
+		if spec.Budget > 0 && result.CheapestKey != "" && e.outOfRunning(spec, result, batch, plan) {
+			for _, l := range batch.Destinations {
+				result.Skipped = append(result.Skipped, l.PlaceID)
+			}
+			result.Searched += len(batch.Destinations)
+			continue
+		}
+
+		e.logf("initiating session for %s (%d destinations)\n", batch.SessionPlace, len(batch.Destinations))
+		for _, l := range batch.Destinations {
+			e.emit(result, budget, Event{Type: EventDestinationStarted, Destination: l.PlaceID, PlaceName: l.PlaceName})
+		}
+
+		dates := TripDates{Outbound: spec.OutboundDate, Inbound: spec.InboundDate}
+		trips := map[string]*Trip{}
+		for _, l := range batch.Destinations {
+			trips[l.PlaceID] = NewTrip(l, dates)
+		}
+		travelers := travelerOrder(spec.Travelers, plan[batch.SessionPlace])
+		pruned := map[string]bool{}
+		for i, traveler := range travelers {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+
+			if len(batch.Destinations) > 1 {
+				// skip is everything that doesn't go in the poll: pruned
+				// already, or somewhere this traveler lives
+				skip := map[string]bool{}
+				for _, l := range batch.Destinations {
+					if pruned[l.PlaceID] {
+						skip[l.PlaceID] = true
+						continue
+					}
+					if trips[l.PlaceID].Total() >= result.Cheapest {
+						e.logf("%s is already over the cheapest trip, moving on\n", l.PlaceID)
+						result.Pruned = append(result.Pruned, l.PlaceID)
+						for _, rest := range travelers[i:] {
+							failed(trips[l.PlaceID], rest.Name, LegFailure{Reason: FailPruned})
+						}
+						pruned[l.PlaceID] = true
+						skip[l.PlaceID] = true
+						continue
+					}
+					if fare, ok := LocalFare(e.ss.Catalog(), traveler, l, spec.GroundCost); ok {
+						priced(trips[l.PlaceID], traveler.Name, fare)
+						skip[l.PlaceID] = true
+					}
+				}
+				if len(skip) == len(batch.Destinations) {
+					continue
+				}
+
+				e.logln("searching flights for", traveler.Name)
+				prices, attempts, err := e.priceBatch(ctx, spec, budget, traveler, batch, skip)
+				if err == ErrBudgetSpent {
+					return result, e.stopped(result, err)
+				}
+				if err != nil {
+					e.logln(err.Error())
+				}
+				for _, l := range batch.Destinations {
+					if skip[l.PlaceID] {
+						continue
+					}
+					bestPrice, ok := prices[l.PlaceID]
+					if !ok {
+						failure := LegFailure{Reason: FailNoItineraries, Attempts: attempts}
+						if err != nil {
+							failure = legFailure(err)
+						}
+						failed(trips[l.PlaceID], traveler.Name, failure)
+						continue
+					}
+					e.logf("best price: %f to %s\n", bestPrice.Price, bestPrice.Deeplink)
+					priced(trips[l.PlaceID], traveler.Name, fareFrom(bestPrice))
+				}
+				continue
+			}
+
+			location := batch.Destinations[0]
+			if trips[location.PlaceID].Total() >= result.Cheapest {
+				e.logf("%s is already over the cheapest trip, moving on\n", location.PlaceID)
+				result.Pruned = append(result.Pruned, location.PlaceID)
+				for _, rest := range travelers[i:] {
+					failed(trips[location.PlaceID], rest.Name, LegFailure{Reason: FailPruned})
+				}
+				break
+			}
+
+			// person already lives here
+			if fare, ok := LocalFare(e.ss.Catalog(), traveler, location, spec.GroundCost); ok {
+				e.logln(traveler.Name, "is already there")
+				priced(trips[location.PlaceID], traveler.Name, fare)
+				continue
+			}
+
+			e.logln("searching flights for", traveler.Name)
+			bestPrice, _, err := e.priceLeg(ctx, spec, budget, traveler, location)
+			if err == ErrBudgetSpent {
+				return result, e.stopped(result, err)
+			}
+			if err != nil {
+				e.logln(err.Error())
+				failed(trips[location.PlaceID], traveler.Name, legFailure(err))
+				continue
+			}
+
+			e.logf("best price: %f to %s\n", bestPrice.Price, bestPrice.Deeplink)
+			priced(trips[location.PlaceID], traveler.Name, fareFrom(bestPrice))
+		}
+
+		for _, location := range batch.Destinations {
+			trip := trips[location.PlaceID]
+			trip.finish(spec)
+			if len(trip.Legs) > 0 {
+				result.Trips[location.PlaceID] = trip
+			}
+			// the whole trip, so somewhere with cheap fares and dear hotels
+			// doesn't win. Pruning above goes on fares alone, which is only
+			// ever less
+			tripCostTotal := trip.Cost()
+
+			// determine if it was cheaper and save the key
+			e.logln("comparing:", tripCostTotal, result.Cheapest)
+			if trip.Complete() && tripCostTotal < result.Cheapest {
+				result.CheapestKey = location.PlaceID
+				result.Cheapest = tripCostTotal
+				e.emit(result, budget, Event{
+					Type:        EventNewBest,
+					Destination: location.PlaceID,
+					PlaceName:   location.PlaceName,
+					Total:       tripCostTotal,
+				})
+			}
+		}
+
+		result.Searched += len(batch.Destinations)
+		result.Calls = budget.used
+		if e.Checkpoint != nil {
+			e.Checkpoint(result)
+		}
+	}
+
+	return result, nil
+}
+
+// outOfRunning is true when every destination in the batch has a full
+// estimate and it's more than PlanSlack over the cheapest trip found so far.
+// estimates are rough, so anything close still gets a live search
+func (e *Engine) outOfRunning(spec *SearchSpec, result *SearchResult, batch searchBatch, plan map[string]*Estimate) bool {
+	for _, l := range batch.Destinations {
+		est, ok := plan[l.PlaceID]
+		if !ok || len(est.Missing) > 0 || est.Total <= result.Cheapest*(1+spec.PlanSlack) {
+			return false
+		}
+	}
+	return true
+}
+
+// stopped turns running out of budget into a normal finish: the result is
+// as good as the calls allowed
+func (e *Engine) stopped(result *SearchResult, err error) error {
+	if err == ErrBudgetSpent {
+		e.logln("out of api calls, stopping with what we have")
+		result.OutOfBudget = true
+		return nil
+	}
+	return err
+}
+
+// Estimate is the coarse pass: cached quotes from each home airport to
+// everywhere, summed per destination and ranked
+func (e *Engine) Estimate(ctx context.Context, spec *SearchSpec) ([]*Estimate, error) {
+	return e.estimate(ctx, spec, &callBudget{})
+}
+
+func (e *Engine) estimate(ctx context.Context, spec *SearchSpec, budget *callBudget) ([]*Estimate, error) {
+	quotes := map[string][]Quote{}
+	for _, traveler := range spec.Travelers {
+		origin := traveler.LocationCode
+		if _, ok := quotes[origin]; ok {
+			continue
+		}
+
+		for attempt := 1; ; attempt++ {
+			if err := e.wait(ctx, budget); err != nil {
+				return nil, err
+			}
+			q, err := e.ss.BrowseQuotes(spec.OutboundDate, spec.InboundDate, origin, AnywhereDestination)
+			if err == nil {
+				quotes[origin] = q
+				break
+			}
+			e.logf("error browsing quotes from %s: %s\n", origin, err.Error())
+			// only rate limiting is worth another go, anything else will just
+			// fail again and eat calls doing it
+			if attempt >= e.MaxAttempts || !strings.Contains(err.Error(), "Rate limit has been exceeded") {
+				// carry on without it, those travelers just show as missing
+				quotes[origin] = []Quote{}
+				break
+			}
+			if err := backOff(ctx, e.RetryDelay); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return EstimateDestinations(spec.Travelers, quotes, e.ss.Catalog(), spec.Destinations, spec.GroundCost), nil
+}
+
+// priceLeg keeps at one traveler's flight to one destination until the api
+// gives up a price or we give up on the api
+func (e *Engine) priceLeg(ctx context.Context, spec *SearchSpec, budget *callBudget, traveler *Traveler, location Location) (*PricingOption, int, error) {
+	var bestPrice *PricingOption
+	attempts, err := e.retry(ctx, spec, budget, traveler, location.PlaceID, func(sessionKey string) (bool, error) {
+		var err error
+		bestPrice, err = e.ss.PollSession(sessionKey, traveler.LocationCode, location.PlaceID, location.PlaceName)
+		return bestPrice != nil, err
+	})
+	return bestPrice, attempts, err
+}
+
+// priceBatch is priceLeg for a whole batch at once: one session against the
+// batch's city or country, one poll filtered to all of its airports but the
+// ones in skip
+func (e *Engine) priceBatch(ctx context.Context, spec *SearchSpec, budget *callBudget, traveler *Traveler, batch searchBatch, skip map[string]bool) (map[string]*PricingOption, int, error) {
+	airports := []string{}
+	names := map[string]string{}
+	for _, l := range batch.Destinations {
+		if skip[l.PlaceID] {
+			continue
+		}
+		airports = append(airports, l.PlaceID)
+		names[l.PlaceID] = l.PlaceName
+	}
+
+	var prices map[string]*PricingOption
+	attempts, err := e.retry(ctx, spec, budget, traveler, batch.SessionPlace, func(sessionKey string) (bool, error) {
+		var err error
+		prices, err = e.ss.PollSessionMulti(sessionKey, traveler.LocationCode, airports, names)
+		return len(prices) > 0, err
+	})
+	return prices, attempts, err
+}
+
+// retry makes a session from traveler's home to sessionPlace and polls it,
+// over and over, until poll says it got something or we run out of patience.
+// the api fails for no reason a lot so most errors just mean try again. It
+// returns how many attempts it took, and gives up with a *LegError saying why
+func (e *Engine) retry(ctx context.Context, spec *SearchSpec, budget *callBudget, traveler *Traveler, sessionPlace string, poll func(sessionKey string) (bool, error)) (int, error) {
+	attempts := 0
+	noLegsFound := 0
+	rateLimited := 0
+	sessionFailed := 0
+	for {
+		attempts++
+		if attempts > e.MaxAttempts {
+			reason := FailExceededAttempts
+			if rateLimited == e.MaxAttempts {
+				reason = FailRateLimited
+			} else if sessionFailed == e.MaxAttempts {
+				reason = FailSessionFailed
+			}
+			return e.MaxAttempts, &LegError{
+				Reason:   reason,
+				Attempts: e.MaxAttempts,
+				Err:      fmt.Errorf("exceeded %d attempts. Skipping this destination", e.MaxAttempts),
+			}
+		}
+		if err := e.wait(ctx, budget); err != nil {
+			return attempts, err
+		}
+
+		sessionKey, err := e.ss.InitSession(spec.OutboundDate, spec.InboundDate, traveler.LocationCode, sessionPlace)
+		if err != nil {
+			// try again. this shouldn't happen
+			e.logln("error initiating session:", err.Error())
+			sessionFailed++
+			continue
+		}
+
+		if err := e.wait(ctx, budget); err != nil {
+			return attempts, err
+		}
+		found, err := poll(sessionKey)
+		if err != nil {
+			// try again. fake error
+			if strings.Contains(err.Error(), "Rate limit has been exceeded") {
+				rateLimited++
+				continue
+			} else if strings.Contains(err.Error(), "no pricing option") {
+				e.logln("no legs found, trying again....")
+				noLegsFound++
+				if noLegsFound > e.MaxNoLegs {
+					return attempts, &LegError{
+						Reason:   FailNoItineraries,
+						Attempts: attempts,
+						Err:      fmt.Errorf("no legs found limit exceeded; breaking"),
+					}
+				}
+			} else {
+				return attempts, &LegError{
+					Reason:   FailPollError,
+					Attempts: attempts,
+					Err:      fmt.Errorf("error polling session: %s", err.Error()),
+				}
+			}
+		}
+		if found {
+			return attempts, nil
+		}
+
+		// ease up on rate limiting for testing
+		if err := backOff(ctx, e.RetryDelay); err != nil {
+			return attempts, err
+		}
+	}
+}
+
+// backOff waits d, or less if ctx is done first
+func backOff(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// searchBatch is a set of destinations priced off one session per traveler
+type searchBatch struct {
+	// SessionPlace is what the session gets created against: the one
+	// destination, or the city/country they all share
+	SessionPlace string
+	Destinations []Location
+}
+
+// batchDestinations groups destinations that can share a session. by is
+// "city" or "country"; anything else (or a destination with no city/country)
+// gets a session to itself. No batch is bigger than size
+func batchDestinations(destinations []Location, by string, size int) []searchBatch {
+	batches := []searchBatch{}
+	groups := map[string]int{}
+	for _, l := range destinations {
+		key := ""
+		switch by {
+		case "city":
+			key = l.CityID
+		case "country":
+			key = l.CountryID
+		}
+		// a city level place already is its own session place
+		if key == "" || key == l.PlaceID {
+			batches = append(batches, searchBatch{SessionPlace: l.PlaceID, Destinations: []Location{l}})
+			continue
+		}
+
+		i, ok := groups[key]
+		if !ok || (size > 0 && len(batches[i].Destinations) >= size) {
+			i = len(batches)
+			groups[key] = i
+			batches = append(batches, searchBatch{SessionPlace: key})
+		}
+		batches[i].Destinations = append(batches[i].Destinations, l)
+	}
+
+	// a group of one may as well search the airport itself
+	for i, b := range batches {
+		if len(b.Destinations) == 1 {
+			batches[i].SessionPlace = b.Destinations[0].PlaceID
+		}
+	}
+	return batches
+}
@@ -0,0 +1,160 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// fareProvider prices from a table of fares by home airport then destination,
+// and has nothing for anything not in it
+type fareProvider struct {
+	SkyScanner
+	catalog *Catalog
+	fares   map[string]map[string]float64
+	// polls is how many polls each home airport got
+	polls map[string]int
+}
+
+func newFareProvider(places []Location, fares map[string]map[string]float64) *fareProvider {
+	return &fareProvider{catalog: NewCatalog(places), fares: fares, polls: map[string]int{}}
+}
+
+func (p *fareProvider) Catalog() *Catalog { return p.catalog }
+
+func (p *fareProvider) InitSession(outboundDate, inboundDate, departureAirport, destinationAirport string) (string, error) {
+	return "session", nil
+}
+
+func (p *fareProvider) PollSession(sessionKey, departureAirport, destinationAirport, placeName string) (*PricingOption, error) {
+	p.polls[departureAirport]++
+	price, ok := p.fares[departureAirport][destinationAirport]
+	if !ok {
+		return nil, fmt.Errorf("no pricing option was found for this leg")
+	}
+	return &PricingOption{Price: price, Location: placeName, SrcAirport: departureAirport, DstAirport: destinationAirport}, nil
+}
+
+func (p *fareProvider) PollSessionMulti(sessionKey, departureAirport string, destinationAirports []string, placeNames map[string]string) (map[string]*PricingOption, error) {
+	p.polls[departureAirport]++
+	res := map[string]*PricingOption{}
+	for _, dst := range destinationAirports {
+		if price, ok := p.fares[departureAirport][dst]; ok {
+			res[dst] = &PricingOption{Price: price, Location: placeNames[dst], SrcAirport: departureAirport, DstAirport: dst}
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no pricing option was found for this leg")
+	}
+	return res, nil
+}
+
+func testEngine(ss SkyScanner) *Engine {
+	e := NewEngine(ss, NewRateLimiter(0))
+	e.RetryDelay = 0
+	e.MaxNoLegs = 0
+	e.Log = ioutil.Discard
+	return e
+}
+
+func TestRunOnlyBoundsOnCompleteTrips(t *testing.T) {
+	places := []Location{
+		{PlaceID: "AAA-sky", PlaceName: "Partial", CountryID: "US-sky", CityID: "AAAA-sky"},
+		{PlaceID: "BBB-sky", PlaceName: "Complete", CountryID: "US-sky", CityID: "BBBA-sky"},
+	}
+	// bob can't get to AAA at all, so its 50 isn't a trip, and BBB at 200
+	// is the best there is
+	ss := newFareProvider(places, map[string]map[string]float64{
+		"DEN-sky": {"AAA-sky": 50, "BBB-sky": 100},
+		"JFK-sky": {"BBB-sky": 100},
+	})
+	spec := &SearchSpec{
+		Travelers: map[string]*Traveler{
+			"alice": NewTraveler("alice", "DEN-sky"),
+			"bob":   NewTraveler("bob", "JFK-sky"),
+		},
+		OutboundDate: "2020-01-01",
+		InboundDate:  "2020-01-05",
+		Destinations: places,
+	}
+
+	result, err := testEngine(ss).Run(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CheapestKey != "BBB-sky" || result.Cheapest != 200 {
+		t.Errorf("cheapest is %s at %v, want BBB-sky at 200", result.CheapestKey, result.Cheapest)
+	}
+	if len(result.Pruned) != 0 {
+		t.Errorf("pruned %v against a trip missing a leg", result.Pruned)
+	}
+	if trip := result.Trips["BBB-sky"]; trip == nil || !trip.Complete() {
+		t.Errorf("BBB-sky should be complete, got %+v", trip)
+	}
+}
+
+func TestRunPrunesBetweenBatchPolls(t *testing.T) {
+	places := []Location{
+		{PlaceID: "AAA-sky", PlaceName: "First", CountryID: "US-sky"},
+		{PlaceID: "BBB-sky", PlaceName: "Dear", CountryID: "MX-sky"},
+		{PlaceID: "CCC-sky", PlaceName: "Cheap", CountryID: "MX-sky"},
+	}
+	// AAA sets the bar at 100. alice's 150 to BBB is over it before bob's
+	// poll, so bob's cheap BBB fare should never be asked for
+	ss := newFareProvider(places, map[string]map[string]float64{
+		"DEN-sky": {"AAA-sky": 50, "BBB-sky": 150, "CCC-sky": 60},
+		"JFK-sky": {"AAA-sky": 50, "BBB-sky": 10, "CCC-sky": 30},
+	})
+	spec := &SearchSpec{
+		Travelers: map[string]*Traveler{
+			"alice": NewTraveler("alice", "DEN-sky"),
+			"bob":   NewTraveler("bob", "JFK-sky"),
+		},
+		OutboundDate: "2020-01-01",
+		InboundDate:  "2020-01-05",
+		Destinations: places,
+		BatchBy:      "country",
+	}
+
+	result, err := testEngine(ss).Run(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pruned) != 1 || result.Pruned[0] != "BBB-sky" {
+		t.Errorf("pruned %v, want just BBB-sky", result.Pruned)
+	}
+	if got := result.Trips["BBB-sky"].Failures["bob"].Reason; got != FailPruned {
+		t.Errorf("bob's BBB-sky leg is %q, want %q", got, FailPruned)
+	}
+	if result.CheapestKey != "CCC-sky" || result.Cheapest != 90 {
+		t.Errorf("cheapest is %s at %v, want CCC-sky at 90", result.CheapestKey, result.Cheapest)
+	}
+}
+
+// rateLimitedProvider is rate limited on every browse
+type rateLimitedProvider struct {
+	*fareProvider
+}
+
+func (p rateLimitedProvider) BrowseQuotes(outboundDate, inboundDate, departureAirport, destination string) ([]Quote, error) {
+	return nil, fmt.Errorf("Rate limit has been exceeded")
+}
+
+func TestEstimateBackOffStopsWithTheContext(t *testing.T) {
+	e := testEngine(rateLimitedProvider{newFareProvider(nil, nil)})
+	e.RetryDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	began := time.Now()
+	_, err := e.Estimate(ctx, &SearchSpec{Travelers: map[string]*Traveler{"alice": NewTraveler("alice", "DEN-sky")}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline", err)
+	}
+	if time.Since(began) > 5*time.Second {
+		t.Errorf("took %v to notice the context was done", time.Since(began))
+	}
+}
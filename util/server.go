@@ -0,0 +1,389 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxRequestBody is plenty for a trip spec
+const maxRequestBody = 1 << 20
+
+// NewServer is the json api over a JobManager:
+//
+//	POST   /api/searches                        start a search, body is a SearchRequest
+//	GET    /api/searches                        every job, newest first
+//	GET    /api/searches/{id}                   status and progress
+//	DELETE /api/searches/{id}                   cancel it
+//	GET    /api/searches/{id}/events            progress as server sent events
+//	GET    /api/searches/{id}/results           ranked trips so far, as a json Report
+//	GET    /api/searches/{id}/trips/{placeId}   one trip in full
+//	GET    /api/searches/{id}/trips/{placeId}/share?policy=equal
+//	                                            who pays what for it, also takes
+//	                                            cap and weights=aj=2,dan=1
+//	GET    /api/searches/{id}/shortlist?n=5     the best complete trips, to vote on
+//	GET    /api/searches/{id}/ballots           every vote cast so far
+//	PUT    /api/searches/{id}/ballots/{name}    a traveler's vote, body is a Ballot
+//	GET    /api/searches/{id}/vote?method=borda the group's choice and why, also
+//	                                            takes n and cost_weight
+//	GET    /api/places?q=denver                 catalog search, for picking airports
+//	GET    /api/countries                       every country in the catalog
+//
+// and the planner page on /
+func NewServer(jobs *JobManager) http.Handler {
+	s := &server{jobs: jobs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/searches", s.searches)
+	mux.HandleFunc("/api/searches/", s.search)
+	mux.HandleFunc("/api/places", s.places)
+	mux.HandleFunc("/api/countries", s.countries)
+	mux.Handle("/", uiHandler())
+	return mux
+}
+
+type server struct {
+	jobs *JobManager
+}
+
+func (s *server) searches(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.jobs.List())
+	case http.MethodPost:
+		req := &SearchRequest{}
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("err reading search: %s", err.Error()))
+			return
+		}
+		job, err := s.jobs.Start(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Location", "/api/searches/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// everything under /api/searches/{id}
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/searches/"), "/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			job, ok := s.jobs.Get(id)
+			if !ok {
+				notFound(w, "search", id)
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+		case http.MethodDelete:
+			if !s.jobs.Cancel(id) {
+				notFound(w, "search", id)
+				return
+			}
+			job, _ := s.jobs.Get(id)
+			writeJSON(w, http.StatusOK, job)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+
+	case len(parts) == 2 && parts[1] == "events":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.events(w, r, id)
+
+	case len(parts) == 2 && parts[1] == "results":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		report, ok := s.jobs.Report(id)
+		if !ok {
+			notFound(w, "search", id)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+
+	case len(parts) == 3 && parts[1] == "trips":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		trip, ok := s.jobs.Trip(id, parts[2])
+		if !ok {
+			notFound(w, "trip", parts[2])
+			return
+		}
+		writeJSON(w, http.StatusOK, trip)
+
+	case len(parts) == 4 && parts[1] == "trips" && parts[3] == "share":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.share(w, r, id, parts[2])
+
+	case len(parts) == 2 && parts[1] == "shortlist":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		trips, ok := s.jobs.Shortlist(id, queryInt(r, "n"))
+		if !ok {
+			notFound(w, "search", id)
+			return
+		}
+		writeJSON(w, http.StatusOK, trips)
+
+	case len(parts) == 2 && parts[1] == "ballots":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		ballots, ok := s.jobs.Ballots(id)
+		if !ok {
+			notFound(w, "search", id)
+			return
+		}
+		writeJSON(w, http.StatusOK, ballots)
+
+	case len(parts) == 3 && parts[1] == "ballots":
+		if r.Method != http.MethodPut {
+			methodNotAllowed(w, http.MethodPut)
+			return
+		}
+		s.castBallot(w, r, id, parts[2])
+
+	case len(parts) == 2 && parts[1] == "vote":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		q := r.URL.Query()
+		name := q.Get("method")
+		if name == "" {
+			name = "borda"
+		}
+		costWeight := 0.0
+		if v := q.Get("cost_weight"); v != "" {
+			var err error
+			if costWeight, err = strconv.ParseFloat(v, 64); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("bad cost_weight %q", v))
+				return
+			}
+		}
+		method, err := NewVoteMethod(name, costWeight)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		res, ok, err := s.jobs.Vote(id, queryInt(r, "n"), method)
+		if !ok {
+			notFound(w, "search", id)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// castBallot takes traveler's ballot. The name in the path is the one that
+// counts, the body doesn't have to repeat it
+func (s *server) castBallot(w http.ResponseWriter, r *http.Request, id, traveler string) {
+	b := Ballot{}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("err reading ballot: %s", err.Error()))
+		return
+	}
+	if b.Traveler != "" && b.Traveler != traveler {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("ballot is for %q but was sent for %q", b.Traveler, traveler))
+		return
+	}
+	b.Traveler = traveler
+	ok, err := s.jobs.CastBallot(id, b)
+	if !ok {
+		notFound(w, "search", id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// share splits one of the job's trips by the policy in the query
+func (s *server) share(w http.ResponseWriter, r *http.Request, id, placeID string) {
+	q := r.URL.Query()
+	name := q.Get("policy")
+	if name == "" {
+		name = "equal"
+	}
+	most := 0.0
+	if v := q.Get("cap"); v != "" {
+		var err error
+		if most, err = strconv.ParseFloat(v, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad cap %q", v))
+			return
+		}
+	}
+	weights, err := ParseWeights(q.Get("weights"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	policy, err := NewSharePolicy(name, most, weights)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	trip, ok := s.jobs.Trip(id, placeID)
+	if !ok {
+		notFound(w, "trip", placeID)
+		return
+	}
+	cs, err := ShareCosts(trip, policy)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+// queryInt is a query param as an int, 0 if it's missing or junk
+func queryInt(r *http.Request, name string) int {
+	n, _ := strconv.Atoi(r.URL.Query().Get(name))
+	return n
+}
+
+// events streams a job as server sent events. First a status event with the
+// job as it stands, then each Event as it happens under its own type, then
+// another status once the job finishes. Stops early if the client goes away
+func (s *server) events(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	job, events, stop, ok := s.jobs.Watch(id)
+	if !ok {
+		notFound(w, "search", id)
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeEvent(w, "status", job); err != nil {
+		return
+	}
+	flusher.Flush()
+	if job.FinishedAt != nil {
+		return
+	}
+	for {
+		select {
+		case ev, open := <-events:
+			if !open {
+				// one last status so the client sees how it ended
+				if job, ok := s.jobs.Get(id); ok {
+					writeEvent(w, "status", job)
+					flusher.Flush()
+				}
+				return
+			}
+			if err := writeEvent(w, string(ev.Type), ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+func (s *server) places(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	writeJSON(w, http.StatusOK, s.jobs.Catalog().Search(r.URL.Query().Get("q"), limit))
+}
+
+func (s *server) countries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	seen := map[string]bool{}
+	countries := []string{}
+	for _, l := range s.jobs.Catalog().List() {
+		if l.CountryName != "" && !seen[l.CountryName] {
+			seen[l.CountryName] = true
+			countries = append(countries, l.CountryName)
+		}
+	}
+	sort.Strings(countries)
+	writeJSON(w, http.StatusOK, countries)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		fmt.Println("err writing response:", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func notFound(w http.ResponseWriter, what, id string) {
+	writeError(w, http.StatusNotFound, fmt.Errorf("no %s %q", what, id))
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
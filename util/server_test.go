@@ -0,0 +1,161 @@
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServer(t *testing.T, m *JobManager) *httptest.Server {
+	srv := httptest.NewServer(NewServer(m))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// getJSON gets path off srv into v, and the status
+func getJSON(t *testing.T, srv *httptest.Server, path string, v interface{}) int {
+	t.Helper()
+	res, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("%s isn't json: %s", path, err)
+	}
+	return res.StatusCode
+}
+
+// postSearch starts testRequest through the api
+func postSearch(t *testing.T, srv *httptest.Server) JobView {
+	t.Helper()
+	b, _ := json.Marshal(testRequest())
+	res, err := srv.Client().Post(srv.URL+"/api/searches", "application/json", strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	job := JobView{}
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusAccepted || res.Header.Get("Location") != "/api/searches/"+job.ID {
+		t.Fatalf("got %s at %q, want 202 and the job's location", res.Status, res.Header.Get("Location"))
+	}
+	return job
+}
+
+func TestServerSearchResults(t *testing.T) {
+	m := testJobs(newFareProvider(jobPlaces, jobFares))
+	srv := testServer(t, m)
+
+	job := postSearch(t, srv)
+	waitJob(t, m, job.ID)
+
+	status := JobView{}
+	if code := getJSON(t, srv, "/api/searches/"+job.ID, &status); code != http.StatusOK || status.Status != JobDone {
+		t.Errorf("status %d %+v, want done", code, status)
+	}
+	report := Report{}
+	if code := getJSON(t, srv, "/api/searches/"+job.ID+"/results", &report); code != http.StatusOK {
+		t.Fatalf("results %d", code)
+	}
+	if len(report.Trips) != 2 || report.Trips[0].Destination != "HAV-sky" || report.Trips[0].Cost != 270 {
+		t.Errorf("results %+v, want Havana first at 270", report.Trips)
+	}
+	trip := Trip{}
+	if code := getJSON(t, srv, "/api/searches/"+job.ID+"/trips/CUN-sky", &trip); code != http.StatusOK || trip.Total() != 300 {
+		t.Errorf("trip %d %+v, want Cancun at 300", code, trip)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	srv := testServer(t, testJobs(newFareProvider(jobPlaces, jobFares)))
+
+	cases := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/api/searches", `{"travelers": [], "nope": 1}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/searches", `{"travelers": []}`, http.StatusBadRequest},
+		{http.MethodGet, "/api/searches/nope", "", http.StatusNotFound},
+		{http.MethodGet, "/api/searches/nope/results", "", http.StatusNotFound},
+		{http.MethodGet, "/api/searches/nope/events", "", http.StatusNotFound},
+		{http.MethodPut, "/api/searches", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/searches/nope/results", "", http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, srv.URL+c.path, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := map[string]string{}
+		json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if res.StatusCode != c.want || body["error"] == "" {
+			t.Errorf("%s %s got %d %v, want %d with an error", c.method, c.path, res.StatusCode, body, c.want)
+		}
+	}
+}
+
+func TestServerEventsEndWithTheJob(t *testing.T) {
+	ss := &gatedProvider{newFareProvider(jobPlaces, jobFares), make(chan struct{})}
+	srv := testServer(t, testJobs(ss))
+	job := postSearch(t, srv)
+
+	client := srv.Client()
+	client.Timeout = 10 * time.Second
+	res, err := client.Get(srv.URL + "/api/searches/" + job.ID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type %q", ct)
+	}
+
+	events := []string{}
+	var last JobView
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, strings.TrimPrefix(line, "event: "))
+			// the first status means we're watching, let the search go
+			if len(events) == 1 {
+				close(ss.gate)
+			}
+		case strings.HasPrefix(line, "data: ") && events[len(events)-1] == "status":
+			last = JobView{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &last); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the stream ending on its own is the point, a hang times the client out
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("stream didn't end cleanly: %s", err)
+	}
+
+	got := strings.Join(events, ",")
+	// the first destination_started can go before we're watching, the
+	// search only waits at the poll
+	if !strings.HasPrefix(got, "status,") || !strings.HasSuffix(got, ",search_done,status") {
+		t.Errorf("events %s, want a status, the search, then a status", got)
+	}
+	if !strings.Contains(got, "leg_priced") || !strings.Contains(got, "new_best") {
+		t.Errorf("events %s are missing the legs", got)
+	}
+	if last.Status != JobDone || last.Cheapest == nil || last.Cheapest.Destination != "HAV-sky" {
+		t.Errorf("last status %+v, want done with Havana", last)
+	}
+}
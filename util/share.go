@@ -0,0 +1,351 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SharePolicy decides what each traveler pays toward a trip out of what
+// their own fare cost. Shares have to add back up to the total
+type SharePolicy interface {
+	Name() string
+	// Describe is the policy in a sentence, for the output
+	Describe() string
+	Shares(fares map[string]float64) map[string]float64
+}
+
+// SharePolicies is every policy NewSharePolicy knows
+func SharePolicies() []string {
+	return []string{"equal", "capped", "weighted", "own-up-to-average"}
+}
+
+// NewSharePolicy makes a policy by name. capped needs most, the cap, weighted needs
+// weights, by traveler; anyone left out of weights counts as 1
+func NewSharePolicy(name string, most float64, weights map[string]float64) (SharePolicy, error) {
+	switch name {
+	case "equal":
+		return EqualSplit{}, nil
+	case "capped":
+		if most <= 0 {
+			return nil, fmt.Errorf("capped needs a cap over 0")
+		}
+		return CappedSplit{Cap: most}, nil
+	case "weighted":
+		if len(weights) == 0 {
+			return nil, fmt.Errorf("weighted needs weights, ie aj=2,dan=1")
+		}
+		for name, w := range weights {
+			if w <= 0 {
+				return nil, fmt.Errorf("%s's weight has to be over 0, not %v", name, w)
+			}
+		}
+		return WeightedSplit{Weights: weights}, nil
+	case "own-up-to-average":
+		return OwnUpToAverage{}, nil
+	}
+	return nil, fmt.Errorf("don't know share policy %q, want one of %s", name, strings.Join(SharePolicies(), ", "))
+}
+
+// ParseWeights reads "aj=2,dan=1.5" into weights by traveler
+func ParseWeights(s string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad weight %q, want name=weight", part)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad weight %q: %s", part, err.Error())
+		}
+		weights[strings.TrimSpace(kv[0])] = w
+	}
+	return weights, nil
+}
+
+// EqualSplit is the whole thing divided evenly, however far anyone's coming
+type EqualSplit struct{}
+
+func (EqualSplit) Name() string { return "equal" }
+
+func (EqualSplit) Describe() string {
+	return "everybody pays the same share of the total"
+}
+
+func (EqualSplit) Shares(fares map[string]float64) map[string]float64 {
+	total := sumFares(fares)
+	shares := map[string]float64{}
+	for name := range fares {
+		shares[name] = total / float64(len(fares))
+	}
+	return shares
+}
+
+// CappedSplit has everybody pay their own fare, but nobody more than Cap.
+// What's over the cap is spread evenly over the ones under it, never taking
+// them past it either. If the cap's too low to cover the total between
+// everybody, what's left is split evenly
+type CappedSplit struct {
+	Cap float64
+}
+
+func (CappedSplit) Name() string { return "capped" }
+
+func (c CappedSplit) Describe() string {
+	return fmt.Sprintf("everybody pays their own fare up to $%.2f, and what's over that is shared by the ones under it", c.Cap)
+}
+
+func (c CappedSplit) Shares(fares map[string]float64) map[string]float64 {
+	shares := map[string]float64{}
+	overflow := 0.0
+	for name, f := range fares {
+		shares[name] = math.Min(f, c.Cap)
+		overflow += f - shares[name]
+	}
+	// fill the ones under the cap up evenly, a round at a time, since
+	// some hit it before the rest
+	for overflow > 0.005 {
+		under := []string{}
+		for name, s := range shares {
+			// under by a cent at least, float leftovers don't count
+			if s < c.Cap-0.005 {
+				under = append(under, name)
+			}
+		}
+		if len(under) == 0 {
+			for name := range shares {
+				shares[name] += overflow / float64(len(shares))
+			}
+			break
+		}
+		each := overflow / float64(len(under))
+		for _, name := range under {
+			add := math.Min(each, c.Cap-shares[name])
+			shares[name] += add
+			overflow -= add
+		}
+	}
+	return shares
+}
+
+// WeightedSplit divides the total in proportion to Weights, ie by income,
+// so someone on 2 pays twice what someone on 1 does
+type WeightedSplit struct {
+	Weights map[string]float64
+}
+
+func (WeightedSplit) Name() string { return "weighted" }
+
+func (WeightedSplit) Describe() string {
+	return "the total is split in proportion to everybody's weight"
+}
+
+func (w WeightedSplit) weight(name string) float64 {
+	if weight, ok := w.Weights[name]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (w WeightedSplit) Shares(fares map[string]float64) map[string]float64 {
+	total, weights := sumFares(fares), 0.0
+	for name := range fares {
+		weights += w.weight(name)
+	}
+	shares := map[string]float64{}
+	for name := range fares {
+		shares[name] = total * w.weight(name) / weights
+	}
+	return shares
+}
+
+// OwnUpToAverage has everybody pay their own fare up to the group's average,
+// and what the dear fares are over the average gets shared by everybody. The
+// ones coming from far still pay more, just not all of it
+type OwnUpToAverage struct{}
+
+func (OwnUpToAverage) Name() string { return "own-up-to-average" }
+
+func (OwnUpToAverage) Describe() string {
+	return "everybody pays their own fare up to the average, and what's over the average is split evenly"
+}
+
+func (OwnUpToAverage) Shares(fares map[string]float64) map[string]float64 {
+	average := sumFares(fares) / float64(len(fares))
+	overflow := 0.0
+	for _, f := range fares {
+		if f > average {
+			overflow += f - average
+		}
+	}
+	shares := map[string]float64{}
+	for name, f := range fares {
+		shares[name] = math.Min(f, average) + overflow/float64(len(fares))
+	}
+	return shares
+}
+
+func sumFares(fares map[string]float64) float64 {
+	total := 0.0
+	for _, f := range fares {
+		total += f
+	}
+	return total
+}
+
+// PersonShare is one traveler's side. They book their own Fare, so Net is
+// what they owe the rest, or are owed back when it's under 0
+type PersonShare struct {
+	Traveler string  `json:"traveler"`
+	Fare     float64 `json:"fare"`
+	Pays     float64 `json:"pays"`
+	Net      float64 `json:"net"`
+}
+
+// Transfer is one payment to settle up
+type Transfer struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+// CostShare is who pays what for a trip, and who pays who to get there
+type CostShare struct {
+	Destination string        `json:"destination"`
+	PlaceName   string        `json:"place_name"`
+	Policy      string        `json:"policy"`
+	Describe    string        `json:"describe"`
+	Total       float64       `json:"total"`
+	People      []PersonShare `json:"people"`
+	Transfers   []Transfer    `json:"transfers"`
+}
+
+// ShareCosts works out trip's cost shares under policy. Everybody needs a
+// fare, there's no splitting a trip some of the group can't get to
+func ShareCosts(trip *Trip, policy SharePolicy) (*CostShare, error) {
+	if !trip.Complete() {
+		return nil, fmt.Errorf("%s has no fare for %s yet, nothing to split", trip.Destination.PlaceName, strings.Join(trip.Missing, ", "))
+	}
+	fares := map[string]float64{}
+	for name, f := range trip.Legs {
+		fares[name] = f.Price
+	}
+	if len(fares) == 0 {
+		return nil, fmt.Errorf("%s has no fares to split", trip.Destination.PlaceName)
+	}
+	// a weight for someone not going is most likely a typo
+	if ws, ok := policy.(WeightedSplit); ok {
+		for name := range ws.Weights {
+			if _, ok := fares[name]; !ok {
+				return nil, fmt.Errorf("%q has a weight but isn't on the trip", name)
+			}
+		}
+	}
+
+	cs := &CostShare{
+		Destination: trip.Destination.PlaceID,
+		PlaceName:   trip.Destination.PlaceName,
+		Policy:      policy.Name(),
+		Describe:    policy.Describe(),
+		Total:       trip.Total(),
+		People:      []PersonShare{},
+		Transfers:   []Transfer{},
+	}
+	shares := roundShares(policy.Shares(fares), cs.Total)
+	for _, name := range trip.Travelers() {
+		cs.People = append(cs.People, PersonShare{
+			Traveler: name,
+			Fare:     fares[name],
+			Pays:     shares[name],
+			Net:      cents(shares[name] - fares[name]),
+		})
+	}
+	cs.Transfers = settle(cs.People)
+	return cs, nil
+}
+
+// roundShares puts shares in cents that still add up to total, the odd cents
+// going to whoever was rounded down the most
+func roundShares(shares map[string]float64, total float64) map[string]float64 {
+	// a hair over so 142.1 isn't 14209.99999 cents
+	floor := func(s float64) float64 { return math.Floor(s*100 + 1e-6) }
+	names := []string{}
+	rounded := map[string]float64{}
+	left := int64(math.Round(total * 100))
+	for name, s := range shares {
+		names = append(names, name)
+		rounded[name] = floor(s) / 100
+		left -= int64(floor(s))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := shares[names[i]]*100-floor(shares[names[i]]), shares[names[j]]*100-floor(shares[names[j]])
+		if ri != rj {
+			return ri > rj
+		}
+		return names[i] < names[j]
+	})
+	for i := 0; left > 0 && len(names) > 0; i, left = i+1, left-1 {
+		name := names[i%len(names)]
+		rounded[name] = cents(rounded[name] + 0.01)
+	}
+	return rounded
+}
+
+// settle pays off everybody owed out of everybody who owes, biggest first,
+// which keeps the number of payments down
+func settle(people []PersonShare) []Transfer {
+	type balance struct {
+		name   string
+		amount int64
+	}
+	owes, owed := []*balance{}, []*balance{}
+	for _, p := range people {
+		c := int64(math.Round(p.Net * 100))
+		switch {
+		case c > 0:
+			owes = append(owes, &balance{p.Traveler, c})
+		case c < 0:
+			owed = append(owed, &balance{p.Traveler, -c})
+		}
+	}
+	biggest := func(b []*balance) func(i, j int) bool {
+		return func(i, j int) bool {
+			if b[i].amount != b[j].amount {
+				return b[i].amount > b[j].amount
+			}
+			return b[i].name < b[j].name
+		}
+	}
+
+	transfers := []Transfer{}
+	for len(owes) > 0 && len(owed) > 0 {
+		sort.Slice(owes, biggest(owes))
+		sort.Slice(owed, biggest(owed))
+		from, to := owes[0], owed[0]
+		amount := from.amount
+		if to.amount < amount {
+			amount = to.amount
+		}
+		transfers = append(transfers, Transfer{From: from.name, To: to.name, Amount: float64(amount) / 100})
+		from.amount -= amount
+		to.amount -= amount
+		if from.amount == 0 {
+			owes = owes[1:]
+		}
+		if to.amount == 0 {
+			owed = owed[1:]
+		}
+	}
+	return transfers
+}
+
+func cents(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCappedSplitShares(t *testing.T) {
+	cases := []struct {
+		name  string
+		cap   float64
+		fares map[string]float64
+		want  map[string]float64
+	}{
+		{"nobody over", 300,
+			map[string]float64{"a": 100, "b": 200},
+			map[string]float64{"a": 100, "b": 200}},
+		{"spread evenly", 300,
+			map[string]float64{"a": 500, "b": 100, "c": 100},
+			map[string]float64{"a": 300, "b": 200, "c": 200}},
+		// b fills up in the first round, c takes the rest in the second
+		{"fills in rounds", 300,
+			map[string]float64{"a": 500, "b": 280, "c": 100},
+			map[string]float64{"a": 300, "b": 300, "c": 280}},
+		// everybody's at the cap with 100 still to go
+		{"cap too low", 250,
+			map[string]float64{"a": 400, "b": 200},
+			map[string]float64{"a": 300, "b": 300}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := CappedSplit{Cap: c.cap}.Shares(c.fares)
+			for name, want := range c.want {
+				if math.Abs(got[name]-want) > 0.001 {
+					t.Errorf("%s pays %v, want %v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundShares(t *testing.T) {
+	third := 100.0 / 3
+	cases := []struct {
+		name   string
+		shares map[string]float64
+		total  float64
+		want   map[string]float64
+	}{
+		// a tie on the remainder goes by name
+		{"thirds", map[string]float64{"a": third, "b": third, "c": third}, 100,
+			map[string]float64{"a": 33.34, "b": 33.33, "c": 33.33}},
+		{"most rounded down gets it", map[string]float64{"a": 10.004, "b": 10.006}, 20.01,
+			map[string]float64{"a": 10, "b": 10.01}},
+		// not 142.09 from float error
+		{"already cents", map[string]float64{"a": 142.1, "b": 57.9}, 200,
+			map[string]float64{"a": 142.1, "b": 57.9}},
+		{"two cents three ways", map[string]float64{"a": 0.02 / 3, "b": 0.02 / 3, "c": 0.02 / 3}, 0.02,
+			map[string]float64{"a": 0.01, "b": 0.01, "c": 0}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := roundShares(c.shares, c.total)
+			for name, want := range c.want {
+				if got[name] != want {
+					t.Errorf("%s pays %v, want %v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSettle(t *testing.T) {
+	people := []PersonShare{
+		{Traveler: "a", Net: 50},
+		{Traveler: "b", Net: 25},
+		{Traveler: "c", Net: -60},
+		{Traveler: "d", Net: -15},
+	}
+	// once a has paid c, d is owed more than c is
+	want := []Transfer{
+		{From: "a", To: "c", Amount: 50},
+		{From: "b", To: "d", Amount: 15},
+		{From: "b", To: "c", Amount: 10},
+	}
+	got := settle(people)
+	if len(got) != len(want) {
+		t.Fatalf("transfers %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transfer %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestShareCostsAddUpForEveryPolicy(t *testing.T) {
+	trip := NewTrip(Location{PlaceID: "CUN-sky", PlaceName: "Cancun"}, TripDates{Outbound: "2020-01-01", Inbound: "2020-01-05"})
+	for name, price := range map[string]float64{"alice": 333.33, "bob": 101.01, "carol": 57.5} {
+		trip.AddFare(name, Fare{Price: price})
+	}
+
+	for _, name := range SharePolicies() {
+		t.Run(name, func(t *testing.T) {
+			policy, err := NewSharePolicy(name, 150, map[string]float64{"alice": 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cs, err := ShareCosts(trip, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// everything in cents so float error can't hide anything
+			pays, nets := int64(0), int64(0)
+			balance := map[string]int64{}
+			for _, p := range cs.People {
+				pays += int64(math.Round(p.Pays * 100))
+				nets += int64(math.Round(p.Net * 100))
+				balance[p.Traveler] = int64(math.Round(p.Net * 100))
+			}
+			if total := int64(math.Round(cs.Total * 100)); pays != total {
+				t.Errorf("shares add up to %d cents, want %d", pays, total)
+			}
+			if nets != 0 {
+				t.Errorf("nets add up to %d cents, want 0", nets)
+			}
+			for _, tr := range cs.Transfers {
+				balance[tr.From] -= int64(math.Round(tr.Amount * 100))
+				balance[tr.To] += int64(math.Round(tr.Amount * 100))
+			}
+			for who, left := range balance {
+				if left != 0 {
+					t.Errorf("%s is %d cents out after the transfers", who, left)
+				}
+			}
+		})
+	}
+}
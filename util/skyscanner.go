@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"strings"
 	"time"
@@ -15,86 +16,171 @@ import (
 type SkyScanner interface {
 	PrettyPrint()
 	List() []Location
+	Catalog() *Catalog
 	GetLocation(location string) ([]Location, error)
 	InitSession(outboundDate, inboundDate, departureAirport string, destinationAirport string) (string, error)
 	PollSession(sessionKey, departureAirport, destinationAirport, placeName string) (*PricingOption, error)
-	CreateView() (string, error)
-	InitSessionCommercial(utid, outboundDate, inboundDate, departureAirport string, destinationAirport string) (string, error)
+	PollSessionMulti(sessionKey, departureAirport string, destinationAirports []string, placeNames map[string]string) (map[string]*PricingOption, error)
+	BrowseQuotes(outboundDate, inboundDate, departureAirport, destination string) ([]Quote, error)
+	CreateView(outboundDate, inboundDate, departureAirport, destinationAirport string) (*WebView, error)
+	InitSessionCommercial(view *WebView, outboundDate, inboundDate, departureAirport, destinationAirport, placeName string) ([]*PricingOption, error)
 }
 
 type skyScanner struct {
-	client   *http.Client
-	airports []Location
+	client  *http.Client
+	api     *RequestBuilder
+	web     *RequestBuilder
+	apiHost string
+	webHost string
+	catalog *Catalog
 }
 
-func NewSkyScanner(jsonLocation string) (SkyScanner, error) {
-	airportJSON, err := ioutil.ReadFile(jsonLocation)
+// APIKeyEnv is where the rapidapi key is looked for when it isn't given
+const APIKeyEnv = "RAPIDAPI_KEY"
+
+// Config is what a SkyScanner needs to talk to the api
+type Config struct {
+	// APIKey is the rapidapi key. Without one every rapidapi call fails, the
+	// website flow doesn't use it
+	APIKey string
+}
+
+func NewSkyScanner(jsonLocation string, config Config) (SkyScanner, error) {
+	// the web flow hands out cookies on the view page that the search wants back
+	jar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	locations := &LocationWrapper{}
-	err = json.Unmarshal(airportJSON, &locations)
-	if err != nil {
-		return nil, err
+	return newSkyScanner(jsonLocation, config, &http.Client{
+		Timeout: 10 * time.Second,
+		Jar:     jar,
+	})
+}
+
+// an empty jsonLocation starts with an empty catalog, for when we're the ones
+// building it
+func newSkyScanner(jsonLocation string, config Config, client *http.Client) (*skyScanner, error) {
+	catalog := NewCatalog(nil)
+	if jsonLocation != "" {
+		var err error
+		catalog, err = LoadCatalog(jsonLocation)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &skyScanner{
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-		airports: locations.Places,
+		client:  client,
+		api:     rapidAPIRequests(config.APIKey),
+		web:     webRequests(webHost),
+		apiHost: "https://" + rapidAPIHost,
+		webHost: webHost,
+		catalog: catalog,
 	}, nil
 }
 
-func (s *skyScanner) InitSessionCommercial(utid, outboundDate, inboundDate, departureAirport string, destinationAirport string) (string, error) {
-	sessionURI := "https://www.skyscanner.de/conductor/v1/fps3/search/?geo_schema=skyscanner&carrier_schema=skyscanner&response_include=query;deeplink;segment;stats;fqs;pqs"
+// CreateView loads the results page for a route the way a browser would and
+// scrapes the ids the private API needs off of it
+func (s *skyScanner) CreateView(outboundDate, inboundDate, departureAirport, destinationAirport string) (*WebView, error) {
+	route := WebRoute{
+		Origin:       departureAirport,
+		Destination:  destinationAirport,
+		OutboundDate: outboundDate,
+		InboundDate:  inboundDate,
+	}
+	if err := route.validate(); err != nil {
+		return nil, err
+	}
+
+	req, err := s.web.With(Headers(map[string]string{
+		"accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+	})).New(http.MethodGet, s.webHost+route.ViewPath(), nil)
+	if err != nil {
+		return nil, err
+	}
 
-	req := newSessionRequest(sessionURI)
-	res, err := http.DefaultClient.Do(req)
+	res, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("err on view creation request: %s", err.Error())
+		return nil, fmt.Errorf("err on view creation request: %s", err.Error())
 	}
 
 	defer res.Body.Close()
 
-	body, _ := ioutil.ReadAll(res.Body)
-	fmt.Println("body:", string(body))
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("err reading view: %s", err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("view creation returned %d", res.StatusCode)
+	}
 
-	return "", nil
+	return ParseWebView(body)
 }
 
-func (s *skyScanner) CreateView() (string, error) {
-	viewURL := "https://www.skyscanner.de/transport/flights/nyca/wasa/191216/191223/?adults=1&children=0&adultsv2=1&childrenv2=&infants=0&cabinclass=economy&rtn=1&preferdirects=false&outboundaltsenabled=false&inboundaltsenabled=false&ref=home#/"
-	req := newAuthedMethod(http.MethodGet, viewURL, &bytes.Buffer{})
+// InitSessionCommercial runs a search against the private fps3 endpoint with
+// the ids from CreateView. Unlike the rapidapi there's no session to poll, the
+// priced itineraries come straight back, cheapest first
+func (s *skyScanner) InitSessionCommercial(view *WebView, outboundDate, inboundDate, departureAirport, destinationAirport, placeName string) ([]*PricingOption, error) {
+	if view == nil {
+		return nil, fmt.Errorf("no view; call CreateView first")
+	}
+
+	route := WebRoute{
+		Origin:       departureAirport,
+		Destination:  destinationAirport,
+		OutboundDate: outboundDate,
+		InboundDate:  inboundDate,
+	}
+	if err := route.validate(); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(newFPS3Request(route))
+	if err != nil {
+		return nil, fmt.Errorf("err marshaling fps3 request: %s", err.Error())
+	}
+
+	req, err := s.web.With(
+		WebSession(view.UTID, view.ViewID),
+		Referer(s.webHost+route.ViewPath()),
+	).New(http.MethodPost, s.webHost+fps3SearchURI, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("err on view creation request: %s", err.Error())
+		return nil, fmt.Errorf("err on fps3 search request: %s", err.Error())
 	}
 
 	defer res.Body.Close()
 
-	body, _ := ioutil.ReadAll(res.Body)
-	fmt.Println("body:", string(body))
-	// p := &PollResponse{}
-	// err = json.Unmarshal(body, &p)
-	// if err != nil {
-	// 	return nil, fmt.Errorf("error unmarshaling poll response: %s", err.Error())
-	// }
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("err reading fps3 response: %s", err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fps3 search returned %d: %s", res.StatusCode, string(body))
+	}
 
-	return "", nil
+	return ParseFPS3Response(body, route, placeName)
 }
 
 // accept date as 2020-01-01
 func (s *skyScanner) InitSession(outboundDate, inboundDate, departureAirport string, destinationAirport string) (string, error) {
-	pollURL := "https://skyscanner-skyscanner-flight-search-v1.p.rapidapi.com/apiservices/pricing/v1.0"
+	pollURL := s.apiHost + "/apiservices/pricing/v1.0"
 
 	payload := strings.NewReader(fmt.Sprintf("inboundDate=%s&cabinClass=economy&children=0&infants=0&country=US&currency=USD&locale=en-US&originPlace=%s&destinationPlace=%s&outboundDate=%s&adults=1", inboundDate, departureAirport, destinationAirport, outboundDate))
 
-	req := newAuthedMethodFromReader(http.MethodPost, pollURL, payload)
+	req, err := s.api.New(http.MethodPost, pollURL, payload)
+	if err != nil {
+		return "", err
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := s.client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("err on request: %s", err.Error())
 	}
@@ -119,55 +205,222 @@ func (s *skyScanner) InitSession(outboundDate, inboundDate, departureAirport str
 }
 
 // PollSession can sort by price, a src airport, and an _array_ of dst airports
-// with this, we can sift through a large result set in-memory with 1 http call
+// with this, we can sift through a large result set in-memory with 1 http call.
+// PollSessionMulti is the one that actually does that
 func (s *skyScanner) PollSession(sessionKey, departureAirport, destinationAirport, placeName string) (*PricingOption, error) {
+	p, err := s.poll(sessionKey, departureAirport, []string{destinationAirport}, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.Itineraries) > 0 {
+		itin := p.Itineraries[0]
+		if len(itin.PricingOptions) > 0 {
+			bestPrice := itin.PricingOptions[0]
+			bestPrice.Location = placeName
+			bestPrice.SrcAirport = departureAirport
+			bestPrice.DstAirport = destinationAirport
+			flights := p.flights()
+			bestPrice.Outbound = flights[itin.OutboundLegID]
+			bestPrice.Inbound = flights[itin.InboundLegID]
+			return bestPrice, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no pricing option was found for this leg")
+}
+
+// PollSessionMulti is PollSession for a session made against a city or country,
+// filtered down to many destination airports in one call. The results are fanned
+// back out to the cheapest option per destination; destinations nobody had a
+// price for are left out. placeNames maps destination PlaceID to its name
+func (s *skyScanner) PollSessionMulti(sessionKey, departureAirport string, destinationAirports []string, placeNames map[string]string) (map[string]*PricingOption, error) {
+	// every destination needs room to show up in the first page
+	pageSize := 10 * len(destinationAirports)
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	p, err := s.poll(sessionKey, departureAirport, destinationAirports, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, d := range destinationAirports {
+		wanted[d] = true
+	}
+
+	flights := p.flights()
+
+	// itineraries come back sorted by price, so the first one per destination
+	// is the cheapest
+	res := map[string]*PricingOption{}
+	for _, itin := range p.Itineraries {
+		outbound := flights[itin.OutboundLegID]
+		if outbound == nil {
+			continue
+		}
+		dst := outbound.To
+		if !wanted[dst] || res[dst] != nil || len(itin.PricingOptions) == 0 {
+			continue
+		}
+		bestPrice := itin.PricingOptions[0]
+		bestPrice.Location = placeNames[dst]
+		bestPrice.SrcAirport = departureAirport
+		bestPrice.DstAirport = dst
+		bestPrice.Outbound = outbound
+		bestPrice.Inbound = flights[itin.InboundLegID]
+		res[dst] = bestPrice
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no pricing option was found for this leg")
+	}
+	return res, nil
+}
+
+// flights is every leg in the response by its id, with the places as our
+// PlaceIDs and the flight numbers the way the carrier shows them
+func (p *PollResponse) flights() map[string]*Flight {
+	places := map[int]string{}
+	for _, place := range p.Places {
+		if place.Code != "" {
+			places[place.ID] = strings.ToUpper(place.Code) + "-sky"
+		}
+	}
+	carriers := map[int]*pollCarrier{}
+	for _, c := range p.Carriers {
+		carriers[c.ID] = c
+	}
+
+	res := map[string]*Flight{}
+	for _, leg := range p.Legs {
+		f := &Flight{
+			From:      places[leg.OriginStation],
+			To:        places[leg.DestinationStation],
+			Departure: leg.Departure,
+			Arrival:   leg.Arrival,
+			Minutes:   leg.Duration,
+		}
+		if len(leg.Carriers) > 0 && carriers[leg.Carriers[0]] != nil {
+			f.Carrier = carriers[leg.Carriers[0]].Name
+		}
+		for _, n := range leg.FlightNumbers {
+			code := ""
+			if c := carriers[n.CarrierID]; c != nil {
+				code = c.DisplayCode
+				if code == "" {
+					code = c.Code
+				}
+			}
+			f.Numbers = append(f.Numbers, strings.TrimSpace(code+" "+n.FlightNumber))
+		}
+		res[leg.ID] = f
+	}
+	return res
+}
+
+func (s *skyScanner) poll(sessionKey, departureAirport string, destinationAirports []string, pageSize int) (*PollResponse, error) {
+	q := url.Values{}
+	q.Set("sortType", "price")
+	q.Set("sortOrder", "asc")
+	q.Set("originAirports", departureAirport)
+	q.Set("destinationAirports", strings.Join(destinationAirports, ";"))
+	q.Set("pageIndex", "0")
+	q.Set("pageSize", fmt.Sprintf("%d", pageSize))
+
+	pollUrl := fmt.Sprintf("%s/apiservices/pricing/uk2/v1.0/%s?%s", s.apiHost, sessionKey, q.Encode())
+	initReq, err := s.api.New(http.MethodGet, pollUrl, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	pollUrl := fmt.Sprintf("https://skyscanner-skyscanner-flight-search-v1.p.rapidapi.com/apiservices/pricing/uk2/v1.0/%s?sortType=price&sortOrder=asc&originAirports=%s&destinationAirports=%s&pageIndex=0&pageSize=10", sessionKey, departureAirport, destinationAirport)
-	fmt.Println("pollurl:", pollUrl)
-	initReq := newAuthedMethod(http.MethodGet, pollUrl, &bytes.Buffer{})
 	res, err := s.client.Do(initReq)
 	if err != nil {
 		return nil, err
 	}
 
 	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("err reading poll response: %s", err.Error())
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("Rate limit has been exceeded")
+	}
+	// the session has nothing for us, same as a poll with no itineraries
+	if res.StatusCode == http.StatusNotFound || len(bytes.TrimSpace(body)) == 0 {
+		return nil, fmt.Errorf("no pricing option was found for this leg")
+	}
 
 	p := &PollResponse{}
 	err = json.Unmarshal(body, &p)
+	if err == nil && p.ValidationErrs != nil && p.ValidationErrs.Message != "" {
+		return nil, fmt.Errorf("poll response saw validation err: %s", p.ValidationErrs.Message)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("poll returned %d: %s", res.StatusCode, string(body))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling poll response: %s", err.Error())
 	}
 
-	if p.ValidationErrs != nil && p.ValidationErrs.Message != "" {
-		return nil, fmt.Errorf("poll response saw validation err: %s", err.Error())
+	return p, nil
+}
+
+// BrowseQuotes gets cached cheapest prices from departureAirport. destination
+// can be AnywhereDestination, which is the whole point: one call gets a price
+// for everywhere skyscanner has one for. These are cached and can be days old
+func (s *skyScanner) BrowseQuotes(outboundDate, inboundDate, departureAirport, destination string) ([]Quote, error) {
+	browseURL := fmt.Sprintf("%s/apiservices/browsequotes/v1.0/US/USD/en-US/%s/%s/%s/%s", s.apiHost, url.PathEscape(departureAirport), url.PathEscape(destination), outboundDate, inboundDate)
+	req, err := s.api.New(http.MethodGet, browseURL, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(p.Itineraries) > 0 {
-		itin := p.Itineraries[0]
-		if len(itin.PricingOptions) > 0 {
-			bestPrice := itin.PricingOptions[0]
-			bestPrice.Location = placeName
-			bestPrice.SrcAirport = departureAirport
-			bestPrice.DstAirport = destinationAirport
-			return bestPrice, nil
-		}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("err on browse request: %s", err.Error())
 	}
 
-	return nil, fmt.Errorf("no pricing option was found for this leg")
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("err reading browse response: %s", err.Error())
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("Rate limit has been exceeded")
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("browse returned %d: %s", res.StatusCode, string(body))
+	}
+
+	return ParseBrowseQuotes(body, departureAirport)
 }
 
 // GetLocation get airport codes for use in polling from a semantic string, like "Denver" || "Washington, DC"
 func (s *skyScanner) GetLocation(location string) ([]Location, error) {
 	fmt.Printf("finding skyscanner locations for city: %s\n", location)
-	baseURL := "https://skyscanner-skyscanner-flight-search-v1.p.rapidapi.com/apiservices/autosuggest/v1.0/UK/GBP/en-GB/?query="
-	req := newAuthedMethod(http.MethodGet, fmt.Sprintf("%s%s", baseURL, location), &bytes.Buffer{})
+	baseURL := s.apiHost + "/apiservices/autosuggest/v1.0/UK/GBP/en-GB/?query="
+	req, err := s.api.New(http.MethodGet, fmt.Sprintf("%s%s", baseURL, url.QueryEscape(location)), nil)
+	if err != nil {
+		return nil, err
+	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("autosuggest returned %d", resp.StatusCode)
+	}
+
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -190,60 +443,17 @@ func (s *skyScanner) GetLocation(location string) ([]Location, error) {
 }
 
 func (s *skyScanner) PrettyPrint() {
-	for _, l := range s.airports {
+	for _, l := range s.catalog.List() {
 		fmt.Printf("[ %s ] ID [ %s ] CountryID [ %s ] RegionID [ %s ] CityID [ %s ] CountryName [ %s ] \n", l.PlaceName, l.PlaceID, l.CountryID, l.RegionID, l.CityID, l.CountryName)
 	}
 }
 
 func (s *skyScanner) List() []Location {
-	return s.airports
-}
-
-func newSessionRequest(url string) *http.Request {
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
-	req.Header.Set("accept", "application/json")
-	req.Header.Set("accept-encoding", "gzip, deflate, br")
-	req.Header.Set("cache-control", "no-cache")
-	req.Header.Set("content-type", "application/json")
-	req.Header.Set("origin", "https://www.skyscanner.net")
-	req.Header.Set("pragma", "no-cache")
-	req.Header.Set("referer", "https://www.skyscanner.net/transport/flights/nyca/wasa/191217/191224/?adults=1&children=0&adultsv2=1&childrenv2=&infants=0&cabinclass=economy&rtn=1&preferdirects=false&outboundaltsenabled=false&inboundaltsenabled=false&ref=home")
-	req.Header.Set("user-agent", "Mozilla/5.0 (X11; U; Linux i686) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.94 Safari/537.36 OPR/46.0.2137.58")
-	req.Header.Set("x-skyscanner-channelid", "website")
-	req.Header.Set("x-skyscanner-devicedetection-ismobile", "false")
-	req.Header.Set("x-skyscanner-devicedetection-istablet", "false")
-	req.Header.Set("x-skyscanner-traveller-context", "dac2aaf8-723d-4d2c-bcd9-cfca01a33b73")
-	req.Header.Set("x-skyscanner-utid", "dac2aaf8-723d-4d2c-bcd9-cfca01a33b73")
-	req.Header.Set("x-skyscanner-viewid", "368174fc-cedd-445f-8151-c7cc77b7b763")
-
-	return req
-}
-
-// auto-apply auth headers
-func newAuthedMethod(method, url string, data *bytes.Buffer) *http.Request {
-
-	req, _ := http.NewRequest(method, url, data)
-	req.Header.Set("x-rapidapi-host", "skyscanner-skyscanner-flight-search-v1.p.rapidapi.com")
-	req.Header.Set("x-rapidapi-key", "GJcM6vJ5FImshFOb5Gfv0Ccq4COSp15iGqnjsnsyF2kQrqfXDu")
-
-	if method == http.MethodPost {
-		req.Header.Set("content-type", "application/x-www-form-urlencoded")
-	}
-	return req
+	return s.catalog.List()
 }
 
-// auto-apply auth headers
-func newAuthedMethodFromReader(method, url string, data *strings.Reader) *http.Request {
-
-	req, _ := http.NewRequest(method, url, data)
-	req.Header.Set("x-rapidapi-host", "skyscanner-skyscanner-flight-search-v1.p.rapidapi.com")
-	req.Header.Set("x-rapidapi-key", "GJcM6vJ5FImshFOb5Gfv0Ccq4COSp15iGqnjsnsyF2kQrqfXDu")
-
-	if method == http.MethodPost {
-		req.Header.Set("content-type", "application/x-www-form-urlencoded")
-	}
-	return req
-
+func (s *skyScanner) Catalog() *Catalog {
+	return s.catalog
 }
 
 /* delete probly
@@ -273,7 +483,7 @@ func (s *skyScanner) InitSessionSave(departureAirport string, destinationAirport
 	}
 
 	baseURL := "https://skyscanner-skyscanner-flight-search-v1.p.rapidapi.com/apiservices/pricing/v1.0"
-	initReq := newAuthedMethod(http.MethodPost, baseURL, &bytes.Buffer{})
+	initReq, err := s.api.New(http.MethodPost, baseURL, body)
 
 	resp, err := s.client.Do(initReq)
 	if err != nil {
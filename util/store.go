@@ -0,0 +1,636 @@
+package util
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store keeps every search that's been run in one bolt database file:
+//
+//	runs        id: what was asked for, when it ran and how it went
+//	trips       id: the trips, best first, as of the last checkpoint
+//	legs/<id>   every leg priced or failed, one a key, in the order it happened
+//
+// values are json like the results files. The file is only open for as long
+// as one read or write takes, bolt only lets one process have it for writing,
+// so a search, a watch and runs list can all share it. See RunRecorder for
+// how a running search keeps its writes short
+type Store struct {
+	path string
+	// mu is one open at a time from this process, bolt locks the file per open
+	mu sync.Mutex
+}
+
+var (
+	runsBucket  = []byte("runs")
+	tripsBucket = []byte("trips")
+	legsBucket  = []byte("legs")
+)
+
+// how long to wait on another process that has the store open before giving up
+const storeTimeout = 10 * time.Second
+
+// DefaultStorePath is ~/.flight-finder/runs.db, so every command finds the
+// same runs wherever it's run from
+func DefaultStorePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".flight-finder", "runs.db")
+	}
+	return filepath.Join(home, ".flight-finder", "runs.db")
+}
+
+// NewRunID is a fresh id for a run, or a watch
+func NewRunID() (string, error) {
+	return newUUID()
+}
+
+// OpenStore keeps runs in the database at path, making it if need be
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("err opening run store: %s", err.Error())
+	}
+	s := &Store{path: path}
+	err := s.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{runsBucket, tripsBucket, legsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path is the database file the runs are in
+func (s *Store) Path() string {
+	return s.path
+}
+
+func (s *Store) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: storeTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("err opening run store %s: %s", s.path, err.Error())
+	}
+	return db, nil
+}
+
+// update runs fn in a write transaction, with the file open just for it
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	if err := db.Update(fn); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// view is update for reading, other readers can have the file at the same time
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// Run is one search as the store remembers it
+type Run struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	// Watch is the watch this run was one check of, if any
+	Watch      string       `json:"watch,omitempty"`
+	Status     JobStatus    `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Spec       RunSpec      `json:"spec"`
+	Planned    int          `json:"planned"`
+	Searched   int          `json:"searched"`
+	Calls      int          `json:"calls"`
+	Cheapest   *JobCheapest `json:"cheapest,omitempty"`
+}
+
+// RunSpec is the SearchSpec a run was started with, destinations by id
+type RunSpec struct {
+	Travelers    map[string]string `json:"travelers"`
+	Outbound     string            `json:"outbound"`
+	Inbound      string            `json:"inbound"`
+	Destinations []string          `json:"destinations"`
+	Anywhere     bool              `json:"anywhere,omitempty"`
+	Top          int               `json:"top,omitempty"`
+	BatchBy      string            `json:"batch_by,omitempty"`
+	BatchSize    int               `json:"batch_size,omitempty"`
+	Budget       int               `json:"budget,omitempty"`
+	Slack        float64           `json:"slack,omitempty"`
+	GroundCost   float64           `json:"ground_cost,omitempty"`
+	CostTable    string            `json:"cost_table,omitempty"`
+}
+
+// SearchSpec turns the stored spec back into one to search with, looking the
+// destinations up in catalog
+func (rs RunSpec) SearchSpec(catalog *Catalog) (*SearchSpec, error) {
+	spec := &SearchSpec{
+		Travelers:    map[string]*Traveler{},
+		OutboundDate: rs.Outbound,
+		InboundDate:  rs.Inbound,
+		Destinations: []Location{},
+		Anywhere:     rs.Anywhere,
+		TopK:         rs.Top,
+		BatchBy:      rs.BatchBy,
+		BatchSize:    rs.BatchSize,
+		Budget:       rs.Budget,
+		PlanSlack:    rs.Slack,
+		GroundCost:   rs.GroundCost,
+		CostTable:    rs.CostTable,
+	}
+	if rs.CostTable != "" {
+		table, err := LoadCostTable(rs.CostTable)
+		if err != nil {
+			return nil, err
+		}
+		spec.Costs = table.Components()
+	}
+	for name, home := range rs.Travelers {
+		spec.Travelers[name] = NewTraveler(name, home)
+	}
+	for _, id := range rs.Destinations {
+		l, ok := catalog.Place(id)
+		if !ok {
+			return nil, fmt.Errorf("destination %s isn't in the catalog", id)
+		}
+		spec.Destinations = append(spec.Destinations, l)
+	}
+	return spec, nil
+}
+
+// NewRunSpec is spec as it gets stored. Travelers go in as name: home
+func NewRunSpec(spec *SearchSpec) RunSpec {
+	rs := RunSpec{
+		Travelers:    map[string]string{},
+		Outbound:     spec.OutboundDate,
+		Inbound:      spec.InboundDate,
+		Destinations: []string{},
+		Anywhere:     spec.Anywhere,
+		Top:          spec.TopK,
+		BatchBy:      spec.BatchBy,
+		BatchSize:    spec.BatchSize,
+		Budget:       spec.Budget,
+		Slack:        spec.PlanSlack,
+		GroundCost:   spec.GroundCost,
+		CostTable:    spec.CostTable,
+	}
+	for name, t := range spec.Travelers {
+		rs.Travelers[name] = t.LocationCode
+	}
+	for _, l := range spec.Destinations {
+		rs.Destinations = append(rs.Destinations, l.PlaceID)
+	}
+	return rs
+}
+
+// LegRecord is one traveler's leg to one destination at the time it was
+// searched. Either Fare or Failure is set
+type LegRecord struct {
+	Time        time.Time   `json:"time"`
+	Destination string      `json:"destination"`
+	PlaceName   string      `json:"place_name"`
+	Traveler    string      `json:"traveler"`
+	Fare        *Fare       `json:"fare,omitempty"`
+	Failure     *LegFailure `json:"failure,omitempty"`
+}
+
+// RunRecorder writes one run into the store as it goes. It's a Subscriber,
+// for the legs, and its Checkpoint fits Engine.Checkpoint. Legs are queued and
+// written a batch at a time, every legFlushEvery and at each checkpoint, so a
+// search never waits on the disk and the file is free for other commands in
+// between
+type RunRecorder struct {
+	store *Store
+
+	// mu guards run and pending, and nothing holds it over I/O
+	mu      sync.Mutex
+	run     Run
+	pending [][]byte
+	// writing keeps batches going in in the order they were queued
+	writing sync.Mutex
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// how often queued legs get written while a search runs
+const legFlushEvery = 2 * time.Second
+
+// Begin starts recording a new run. command is what started it, "search" or
+// "serve" and so on. An empty id gets a new one
+func (s *Store) Begin(id, command string, spec *SearchSpec) (*RunRecorder, error) {
+	return s.begin(Run{ID: id, Command: command}, spec)
+}
+
+func (s *Store) begin(run Run, spec *SearchSpec) (*RunRecorder, error) {
+	id := run.ID
+	if id == "" {
+		var err error
+		if id, err = newUUID(); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkRunID(id); err != nil {
+		return nil, err
+	}
+	run.ID = id
+	run.Status = JobRunning
+	run.StartedAt = time.Now().UTC()
+	run.Spec = NewRunSpec(spec)
+	b, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	err = s.update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(runsBucket)
+		if runs.Get([]byte(id)) != nil {
+			return fmt.Errorf("there's already a run %s", id)
+		}
+		if _, err := tx.Bucket(legsBucket).CreateBucket([]byte(id)); err != nil {
+			return err
+		}
+		return runs.Put([]byte(id), b)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("err starting run %s: %s", id, err.Error())
+	}
+	r := &RunRecorder{
+		store:   s,
+		run:     run,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go r.flushLegs()
+	return r, nil
+}
+
+// ID is the run's id
+func (r *RunRecorder) ID() string {
+	return r.run.ID
+}
+
+// Event queues priced and failed legs for the run's log. Everything else only
+// moves the counters along
+func (r *RunRecorder) Event(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Planned, r.run.Searched, r.run.Calls = ev.Planned, ev.Searched, ev.Calls
+	if ev.Type != EventLegPriced && ev.Type != EventLegFailed {
+		return
+	}
+	b, err := json.Marshal(LegRecord{
+		Time:        ev.Time,
+		Destination: ev.Destination,
+		PlaceName:   ev.PlaceName,
+		Traveler:    ev.Traveler,
+		Fare:        ev.Fare,
+		Failure:     ev.Failure,
+	})
+	if err != nil {
+		return
+	}
+	r.pending = append(r.pending, b)
+}
+
+// flushLegs writes the queued legs every legFlushEvery until Finish
+func (r *RunRecorder) flushLegs() {
+	defer close(r.stopped)
+	tick := time.NewTicker(legFlushEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			// a lost leg only costs us some history, it's not worth
+			// stopping for, and they're tried again next time
+			if err := r.write(nil, false); err != nil {
+				fmt.Println("err recording legs:", err.Error())
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// legKey is big endian so the legs come back out in the order they went in
+func legKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Checkpoint saves the legs and trips so far, ranked, and the run's progress
+func (r *RunRecorder) Checkpoint(result *SearchResult) {
+	if err := r.write(result, true); err != nil {
+		fmt.Println("err saving run:", err.Error())
+	}
+}
+
+// Finish saves the last of the run and closes it out. err is what the search
+// returned, ctx what it ran under, to tell failed from canceled
+func (r *RunRecorder) Finish(ctx context.Context, result *SearchResult, err error) error {
+	close(r.stop)
+	<-r.stopped
+
+	r.mu.Lock()
+	finished := time.Now().UTC()
+	r.run.FinishedAt = &finished
+	r.run.Status = FinishedStatus(ctx, err)
+	if err != nil && r.run.Status == JobFailed {
+		r.run.Error = err.Error()
+	}
+	r.mu.Unlock()
+	return r.write(result, true)
+}
+
+// write puts the queued legs in, and with saveRun the run and its trips too,
+// all in one go so they never disagree. The snapshot is taken under mu, the
+// writing is done without it
+func (r *RunRecorder) write(result *SearchResult, saveRun bool) error {
+	r.writing.Lock()
+	defer r.writing.Unlock()
+
+	r.mu.Lock()
+	legs := r.pending
+	r.pending = nil
+	run, trips, err := r.snapshot(result, saveRun)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(legs) == 0 && run == nil {
+		return nil
+	}
+
+	err = r.store.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(legsBucket).Bucket([]byte(r.run.ID))
+		if bucket == nil {
+			return fmt.Errorf("run %s is gone", r.run.ID)
+		}
+		for _, b := range legs {
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(legKey(seq), b); err != nil {
+				return err
+			}
+		}
+		if trips != nil {
+			if err := tx.Bucket(tripsBucket).Put([]byte(r.run.ID), trips); err != nil {
+				return err
+			}
+		}
+		if run != nil {
+			return tx.Bucket(runsBucket).Put([]byte(r.run.ID), run)
+		}
+		return nil
+	})
+	if err != nil {
+		// back on the front of the queue for the next write
+		r.mu.Lock()
+		r.pending = append(legs, r.pending...)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// snapshot is the run, and the trips out of result, as json. Nothing when
+// saveRun is false. mu has to be held
+func (r *RunRecorder) snapshot(result *SearchResult, saveRun bool) (run, trips []byte, err error) {
+	if !saveRun {
+		return nil, nil, nil
+	}
+	if result != nil {
+		r.run.Planned, r.run.Searched, r.run.Calls = result.Planned, result.Searched, result.Calls
+		r.run.Cheapest = nil
+		if trip, ok := result.Trips[result.CheapestKey]; ok {
+			r.run.Cheapest = &JobCheapest{
+				Destination: result.CheapestKey,
+				PlaceName:   trip.Destination.PlaceName,
+				Total:       result.Cheapest,
+			}
+		}
+		if trips, err = json.Marshal(RankTrips(result.Trips)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if run, err = json.Marshal(r.run); err != nil {
+		return nil, nil, err
+	}
+	return run, trips, nil
+}
+
+// FinishedStatus is how a search that returned err under ctx went
+func FinishedStatus(ctx context.Context, err error) JobStatus {
+	switch {
+	case ctx.Err() != nil:
+		return JobCanceled
+	case err != nil:
+		return JobFailed
+	default:
+		return JobDone
+	}
+}
+
+// RankTrips is every trip best first: the ones everybody can make, cheapest
+// first, then the rest the same way
+func RankTrips(trips map[string]*Trip) []*Trip {
+	complete, partial := Trips{}, Trips{}
+	for _, t := range trips {
+		if t.Complete() {
+			complete = append(complete, t)
+		} else {
+			partial = append(partial, t)
+		}
+	}
+	sort.Sort(complete)
+	sort.Sort(partial)
+	return append([]*Trip(complete), partial...)
+}
+
+// Runs is every run in the store, newest first
+func (s *Store) Runs() ([]*Run, error) {
+	runs := []*Run{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).ForEach(func(k, v []byte) error {
+			run := &Run{}
+			// one bad run shouldn't hide the rest
+			if err := json.Unmarshal(v, run); err != nil {
+				return nil
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+// Run is the run with id, or the only one whose id starts with it
+func (s *Store) Run(id string) (*Run, error) {
+	if run, err := s.readRun(id); err == nil {
+		return run, nil
+	}
+	runs, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	var found *Run
+	for _, run := range runs {
+		if !strings.HasPrefix(run.ID, id) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("more than one run starts with %q", id)
+		}
+		found = run
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no run %q", id)
+	}
+	return found, nil
+}
+
+// Previous is the last run before run for the same people on the same dates,
+// what run's prices are worth comparing against. nil if there isn't one
+func (s *Store) Previous(run *Run) (*Run, error) {
+	runs, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range runs {
+		if r.StartedAt.Before(run.StartedAt) && r.Spec.sameTrip(run.Spec) {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// sameTrip is true when both specs are the same people flying on the same
+// dates, whatever else differs
+func (rs RunSpec) sameTrip(other RunSpec) bool {
+	if rs.Outbound != other.Outbound || rs.Inbound != other.Inbound || len(rs.Travelers) != len(other.Travelers) {
+		return false
+	}
+	for name, home := range rs.Travelers {
+		if other.Travelers[name] != home {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRunID turns away ids no run could have been given, the same for every
+// lookup by id
+func checkRunID(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return fmt.Errorf("no run %q", id)
+	}
+	return nil
+}
+
+func (s *Store) readRun(id string) (*Run, error) {
+	if err := checkRunID(id); err != nil {
+		return nil, err
+	}
+	run := &Run{}
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket).Get([]byte(id))
+		if b == nil {
+			return fmt.Errorf("no run %q", id)
+		}
+		if err := json.Unmarshal(b, run); err != nil {
+			return fmt.Errorf("err reading run %s: %s", id, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Trips is a run's trips, best first. Empty if it never got as far as one
+func (s *Store) Trips(id string) ([]*Trip, error) {
+	if err := checkRunID(id); err != nil {
+		return nil, err
+	}
+	trips := []*Trip{}
+	err := s.view(func(tx *bolt.Tx) error {
+		if tx.Bucket(runsBucket).Get([]byte(id)) == nil {
+			return fmt.Errorf("no run %q", id)
+		}
+		b := tx.Bucket(tripsBucket).Get([]byte(id))
+		if b == nil {
+			return nil
+		}
+		if err := json.Unmarshal(b, &trips); err != nil {
+			return fmt.Errorf("err reading trips for run %s: %s", id, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trips, nil
+}
+
+// Legs is every leg a run searched, in the order it searched them
+func (s *Store) Legs(id string) ([]LegRecord, error) {
+	if err := checkRunID(id); err != nil {
+		return nil, err
+	}
+	legs := []LegRecord{}
+	err := s.view(func(tx *bolt.Tx) error {
+		if tx.Bucket(runsBucket).Get([]byte(id)) == nil {
+			return fmt.Errorf("no run %q", id)
+		}
+		bucket := tx.Bucket(legsBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			leg := LegRecord{}
+			if err := json.Unmarshal(v, &leg); err != nil {
+				return fmt.Errorf("err reading legs for run %s: %s", id, err.Error())
+			}
+			legs = append(legs, leg)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return legs, nil
+}
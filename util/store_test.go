@@ -0,0 +1,169 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testSpec() *SearchSpec {
+	return &SearchSpec{
+		Travelers: map[string]*Traveler{
+			"alice": NewTraveler("alice", "DEN-sky"),
+			"bob":   NewTraveler("bob", "JFK-sky"),
+		},
+		OutboundDate: "2020-01-01",
+		InboundDate:  "2020-01-05",
+		Destinations: []Location{{PlaceID: "CUN-sky", PlaceName: "Cancun"}},
+	}
+}
+
+func TestStoreRecordsARun(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "runs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := store.Begin("", "search", testSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cun := Location{PlaceID: "CUN-sky", PlaceName: "Cancun"}
+	trip := NewTrip(cun, TripDates{Outbound: "2020-01-01", Inbound: "2020-01-05"})
+	for i, name := range []string{"bob", "alice"} {
+		fare := Fare{Price: float64(100 * (i + 1)), To: "CUN-sky"}
+		trip.AddFare(name, fare)
+		rec.Event(Event{Type: EventLegPriced, Time: time.Now(), Destination: "CUN-sky", Traveler: name, Fare: &fare})
+	}
+	// only legs are kept, the rest just moves the counters
+	rec.Event(Event{Type: EventDestinationStarted, Destination: "CUN-sky", Planned: 1, Searched: 1, Calls: 2})
+	result := &SearchResult{Trips: map[string]*Trip{"CUN-sky": trip}, CheapestKey: "CUN-sky", Cheapest: 300, Planned: 1, Searched: 1, Calls: 2}
+	if err := rec.Finish(context.Background(), result, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := store.Run(rec.ID()[:8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != JobDone || run.Cheapest == nil || run.Cheapest.Total != 300 || run.Spec.Travelers["bob"] != "JFK-sky" {
+		t.Errorf("got run %+v", run)
+	}
+	trips, err := store.Trips(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trips) != 1 || trips[0].Total() != 300 {
+		t.Errorf("got trips %+v", trips)
+	}
+	legs, err := store.Legs(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(legs) != 2 || legs[0].Traveler != "bob" || legs[1].Traveler != "alice" {
+		t.Errorf("legs should be bob then alice, got %+v", legs)
+	}
+}
+
+func TestStoreConcurrentRuns(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "runs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		rec, err := store.Begin("", "serve", testSpec())
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				fare := &Fare{Price: float64(j)}
+				rec.Event(Event{Type: EventLegPriced, Destination: fmt.Sprintf("D%d", j), Traveler: "alice", Fare: fare})
+			}
+			rec.Finish(context.Background(), nil, nil)
+		}()
+	}
+	wg.Wait()
+
+	runs, err := store.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 4 {
+		t.Fatalf("got %d runs, want 4", len(runs))
+	}
+	for _, run := range runs {
+		legs, err := store.Legs(run.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(legs) != 10 || legs[9].Destination != "D9" {
+			t.Errorf("run %s has %d legs, want 10 in order", run.ID, len(legs))
+		}
+	}
+}
+
+func TestStoreRejectsBadRunIDs(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "runs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", ".", "..", "../runs", `a\b`, "nope"} {
+		if _, err := store.Trips(id); err == nil {
+			t.Errorf("Trips(%q) should fail", id)
+		}
+		if _, err := store.Legs(id); err == nil {
+			t.Errorf("Legs(%q) should fail", id)
+		}
+		if _, err := store.Run(id); err == nil && id != "" {
+			t.Errorf("Run(%q) should fail", id)
+		}
+	}
+	if _, err := store.Begin("../x", "search", testSpec()); err == nil {
+		t.Errorf("Begin should turn away ../x")
+	}
+}
+
+func TestRecorderQueuesLegsUntilCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := store.Begin("", "search", testSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Event(Event{Type: EventLegPriced, Destination: "CUN-sky", Traveler: "alice", Fare: &Fare{Price: 100}})
+
+	// another process, as far as bolt can tell, can still get at the file
+	other, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs, err := other.Runs(); err != nil || len(runs) != 1 {
+		t.Fatalf("got %d runs and %v while a run was going", len(runs), err)
+	}
+	if legs, _ := other.Legs(rec.ID()); len(legs) != 0 {
+		t.Errorf("got %d legs before a checkpoint, want them still queued", len(legs))
+	}
+
+	rec.Checkpoint(nil)
+	if legs, _ := other.Legs(rec.ID()); len(legs) != 1 {
+		t.Errorf("got %d legs after a checkpoint, want 1", len(legs))
+	}
+	rec.Event(Event{Type: EventLegFailed, Destination: "CUN-sky", Traveler: "bob", Failure: &LegFailure{Reason: FailRateLimited}})
+	if err := rec.Finish(context.Background(), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if legs, _ := other.Legs(rec.ID()); len(legs) != 2 || legs[1].Traveler != "bob" {
+		t.Errorf("got legs %+v after finishing, want alice then bob", legs)
+	}
+}
@@ -0,0 +1,185 @@
+package util
+
+import (
+	"math"
+	"sort"
+)
+
+// TripDates is when a trip is
+type TripDates struct {
+	Outbound string `json:"outbound"`
+	Inbound  string `json:"inbound"`
+}
+
+// Fare is one traveler's flight on a trip. A Local fare isn't a flight at
+// all, they're already there and Price is whatever the ground transport costs
+type Fare struct {
+	Price float64 `json:"price"`
+	From  string  `json:"from"`
+	To    string  `json:"to"`
+	Link  string  `json:"link"`
+	Local bool    `json:"local,omitempty"`
+	// Outbound and Inbound are the flights themselves, nil when the fare
+	// didn't come with them, ie scraped fares and older runs
+	Outbound *Flight `json:"outbound,omitempty"`
+	Inbound  *Flight `json:"inbound,omitempty"`
+}
+
+// Flight is one way of a fare. Departure and Arrival are wall clock at From
+// and To, the way skyscanner gives them, see ParseLocalTime
+type Flight struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Departure string `json:"departure"`
+	Arrival   string `json:"arrival"`
+	// Minutes is door to door, stops and all
+	Minutes int    `json:"minutes,omitempty"`
+	Carrier string `json:"carrier,omitempty"`
+	// Numbers is every flight on the way, ie "UA 1234", more than one when
+	// it stops
+	Numbers []string `json:"numbers,omitempty"`
+}
+
+// TravelMinutes is the flights there and back, false when the fare didn't
+// come with them. Locals don't fly, so it's 0 for them
+func (f Fare) TravelMinutes() (int, bool) {
+	if f.Local {
+		return 0, true
+	}
+	if f.Outbound == nil || f.Inbound == nil || f.Outbound.Minutes == 0 || f.Inbound.Minutes == 0 {
+		return 0, false
+	}
+	return f.Outbound.Minutes + f.Inbound.Minutes, true
+}
+
+// Trip is everybody going to one destination. Legs is keyed by traveler
+// name, and anyone without a fare is in Missing, with why in Failures
+type Trip struct {
+	Destination Location              `json:"destination"`
+	Dates       TripDates             `json:"dates"`
+	Legs        map[string]Fare       `json:"legs"`
+	Missing     []string              `json:"missing"`
+	Failures    map[string]LegFailure `json:"failures,omitempty"`
+	// Costs is what it costs once there, lodging and the like, see
+	// CostComponent. Empty without a cost table
+	Costs []CostItem `json:"costs,omitempty"`
+	// Score is what trips are ranked by, lower is better. It's Cost, the
+	// legs and Costs, in cents precision
+	Score float64 `json:"score"`
+}
+
+func NewTrip(destination Location, dates TripDates) *Trip {
+	return &Trip{
+		Destination: destination,
+		Dates:       dates,
+		Legs:        map[string]Fare{},
+		Missing:     []string{},
+		Failures:    map[string]LegFailure{},
+	}
+}
+
+// Add records traveler's fare from a pricing option
+func (t *Trip) Add(traveler string, p *PricingOption) {
+	t.AddFare(traveler, fareFrom(p))
+}
+
+func fareFrom(p *PricingOption) Fare {
+	return Fare{
+		Price:    p.Price,
+		From:     p.SrcAirport,
+		To:       p.DstAirport,
+		Link:     p.Deeplink,
+		Outbound: p.Outbound,
+		Inbound:  p.Inbound,
+	}
+}
+
+// AddFare records traveler's fare
+func (t *Trip) AddFare(traveler string, f Fare) {
+	t.Legs[traveler] = f
+	delete(t.Failures, traveler)
+	t.Score = t.Cost()
+}
+
+// Fail records why traveler has no fare
+func (t *Trip) Fail(traveler string, f LegFailure) {
+	if t.Failures == nil {
+		t.Failures = map[string]LegFailure{}
+	}
+	t.Failures[traveler] = f
+}
+
+// Total is the sum of the legs we have
+func (t *Trip) Total() float64 {
+	var sum float64
+	for _, f := range t.Legs {
+		sum += f.Price
+	}
+	return math.Round(sum*100) / 100
+}
+
+// GroundTotal is the sum of Costs
+func (t *Trip) GroundTotal() float64 {
+	var sum float64
+	for _, c := range t.Costs {
+		sum += c.Amount
+	}
+	return math.Round(sum*100) / 100
+}
+
+// Cost is the whole trip, fares and what it costs on the ground
+func (t *Trip) Cost() float64 {
+	return math.Round((t.Total()+t.GroundTotal())*100) / 100
+}
+
+// Complete is true when every traveler has a fare
+func (t *Trip) Complete() bool {
+	return len(t.Missing) == 0
+}
+
+// Travelers is everyone with a leg, sorted
+func (t *Trip) Travelers() []string {
+	names := make([]string, 0, len(t.Legs))
+	for name := range t.Legs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// finish works out who's missing once every traveler has been searched, and
+// what the trip costs on the ground. With no cost components the trip keeps
+// the costs it has, so retrying a trip without its cost table doesn't lose them
+func (t *Trip) finish(spec *SearchSpec) {
+	t.Missing = []string{}
+	for name := range spec.Travelers {
+		if _, ok := t.Legs[name]; !ok {
+			t.Missing = append(t.Missing, name)
+			if _, ok := t.Failures[name]; !ok {
+				t.Fail(name, LegFailure{Reason: FailNotSearched})
+			}
+		}
+	}
+	sort.Strings(t.Missing)
+	if spec.Costs == nil {
+		t.Score = t.Cost()
+		return
+	}
+	t.AddCosts(spec.Costs)
+}
+
+// Trips sorts by Score, destination to break ties
+type Trips []*Trip
+
+func (t Trips) Len() int {
+	return len(t)
+}
+func (t Trips) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
+}
+func (t Trips) Less(i, j int) bool {
+	if t[i].Score != t[j].Score {
+		return t[i].Score < t[j].Score
+	}
+	return t[i].Destination.PlaceID < t[j].Destination.PlaceID
+}
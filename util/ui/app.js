@@ -0,0 +1,242 @@
+// the planner page. everything goes through the json api, see server.go
+
+const $ = (id) => document.getElementById(id);
+let current = null; // the search being watched
+let timer = null;
+let events = null; // the EventSource for current
+
+async function api(method, path, body) {
+  const res = await fetch(path, {
+    method,
+    headers: body ? { "Content-Type": "application/json" } : {},
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  const data = await res.json();
+  if (!res.ok) throw new Error(data.error || res.statusText);
+  return data;
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k === "class") node.className = v;
+    else node.setAttribute(k, v);
+  }
+  for (const c of children) node.append(c);
+  return node;
+}
+
+function list(value) {
+  return value.split(",").map((s) => s.trim()).filter(Boolean);
+}
+
+function money(n) {
+  return "$" + n.toFixed(2);
+}
+
+// travelers live in the browser so nobody has to edit main.go
+function loadTravelers() {
+  const saved = JSON.parse(localStorage.getItem("travelers") || "[]");
+  if (saved.length === 0) saved.push({ name: "", location_code: "" });
+  saved.forEach(addTraveler);
+}
+
+function saveTravelers() {
+  localStorage.setItem("travelers", JSON.stringify(readTravelers()));
+}
+
+function readTravelers() {
+  return [...$("travelers").tBodies[0].rows]
+    .map((row) => ({
+      name: row.querySelector(".name").value.trim(),
+      location_code: row.querySelector(".home").value.trim(),
+    }))
+    .filter((t) => t.name || t.location_code);
+}
+
+function addTraveler(t) {
+  const name = el("input", { type: "text", class: "name", placeholder: "name" });
+  const home = el("input", { type: "text", class: "home", list: "airports", placeholder: "DEN-sky" });
+  name.value = t.name || "";
+  home.value = t.location_code || "";
+  home.addEventListener("input", () => suggestAirports(home.value));
+  const remove = el("button", { type: "button" }, "remove");
+  const row = el("tr", {}, el("td", {}, name), el("td", {}, home), el("td", {}, remove));
+  remove.addEventListener("click", () => {
+    row.remove();
+    saveTravelers();
+  });
+  row.addEventListener("change", saveTravelers);
+  $("travelers").tBodies[0].append(row);
+}
+
+let suggesting = null;
+async function suggestAirports(q) {
+  if (q.length < 2 || q.endsWith("-sky")) return;
+  clearTimeout(suggesting);
+  suggesting = setTimeout(async () => {
+    const places = await api("GET", "/api/places?q=" + encodeURIComponent(q));
+    $("airports").replaceChildren(
+      ...places.map((p) => el("option", { value: p.PlaceId }, `${p.PlaceName}, ${p.CountryName}`))
+    );
+  }, 200);
+}
+
+async function loadCountries() {
+  const countries = await api("GET", "/api/countries");
+  $("country-list").replaceChildren(...countries.map((c) => el("option", { value: c })));
+}
+
+function readRequest() {
+  return {
+    travelers: readTravelers(),
+    outbound: $("outbound").value,
+    inbound: $("inbound").value,
+    filter: {
+      countries: list($("countries").value),
+      exclude_countries: list($("exclude-countries").value),
+      deny: list($("deny").value),
+      where: $("where").value.trim(),
+    },
+    anywhere: $("anywhere").checked,
+    top: Number($("top").value) || 0,
+    budget: Number($("budget").value) || 0,
+    ground_cost: Number($("ground-cost").value) || 0,
+  };
+}
+
+async function start() {
+  $("error").hidden = true;
+  try {
+    const job = await api("POST", "/api/searches", readRequest());
+    watch(job.id);
+    loadJobs();
+  } catch (err) {
+    $("error").textContent = err.message;
+    $("error").hidden = false;
+  }
+}
+
+function watch(id) {
+  current = id;
+  $("job").hidden = false;
+  $("trips").replaceChildren();
+  clearInterval(timer);
+  if (events) events.close();
+  refresh();
+
+  // follow along as it happens, or poll if the stream won't have us
+  events = new EventSource(`/api/searches/${id}/events`);
+  events.addEventListener("status", (e) => {
+    const job = JSON.parse(e.data);
+    showJob(job);
+    if (job.status !== "queued" && job.status !== "running") {
+      events.close();
+      refresh();
+    }
+  });
+  for (const type of ["leg_priced", "leg_failed", "new_best", "destination_started"]) {
+    events.addEventListener(type, (e) => progress(JSON.parse(e.data)));
+  }
+  events.addEventListener("rate_limit_pause", (e) => {
+    const ev = JSON.parse(e.data);
+    $("job-detail").textContent = `Waiting ${Math.ceil(ev.wait_ms / 1000)}s on the rate limit...`;
+  });
+  events.onerror = () => {
+    events.close();
+    timer = setInterval(refresh, 2000);
+  };
+}
+
+// events come in far faster than the trips are worth redrawing
+let pending = null;
+function progress(ev) {
+  $("job-progress").max = ev.planned || 1;
+  $("job-progress").value = ev.searched;
+  clearTimeout(pending);
+  pending = setTimeout(refresh, 500);
+}
+
+async function refresh() {
+  if (!current) return;
+  const job = await api("GET", "/api/searches/" + current);
+  showJob(job);
+  showReport(await api("GET", `/api/searches/${current}/results`));
+  if (job.status !== "queued" && job.status !== "running") {
+    clearInterval(timer);
+    loadJobs();
+  }
+}
+
+function showJob(job) {
+  $("job-status").textContent = job.status;
+  const p = job.progress;
+  $("job-progress").max = p.planned || 1;
+  $("job-progress").value = p.searched;
+  let detail = `${p.searched} of ${p.planned || "?"} destinations, ${p.calls} api calls`;
+  if (job.cheapest) detail += `. Cheapest so far: ${job.cheapest.place_name} at ${money(job.cheapest.total)}`;
+  if (job.error) detail += `. ${job.error}`;
+  $("job-detail").textContent = detail;
+  $("cancel").hidden = job.status !== "running" && job.status !== "queued";
+}
+
+function showReport(report) {
+  $("trips").replaceChildren(
+    ...report.trips.map((trip, i) => {
+      const title = `${i + 1}. ${trip.place_name} (${trip.destination}): ${money(trip.cost)}` +
+        (trip.complete ? "" : ` without ${trip.missing.join(", ")}`);
+      const wins = trip.wins.length ? `best ${trip.wins.map((w) => w.replace(/_/g, " ")).join(", ")}` : "";
+      const ground = trip.ground.length
+        ? `${money(trip.total)} in fares, ` + trip.ground.map((c) => `${c.name} ${money(c.amount)} (${c.detail})`).join(", ")
+        : "";
+      const front = trip.pareto && report.trips.filter((t) => t.pareto).length > 1 ? "nothing beats it on everything" : "";
+      const rows = trip.legs.map((leg) =>
+        el("tr", {},
+          el("td", {}, leg.traveler),
+          el("td", { class: "fare" }, money(leg.fare)),
+          el("td", {}, leg.local ? (leg.fare ? `${leg.from} → ${leg.to} by ground` : "already there") : `${leg.from} → ${leg.to}`),
+          el("td", {}, leg.link ? el("a", { href: leg.link, target: "_blank", rel: "noopener" }, "book") : "")
+        )
+      );
+      const failures = trip.failures.map((f) =>
+        el("tr", { class: "missing" },
+          el("td", {}, f.traveler),
+          el("td", {}, ""),
+          el("td", {}, `no fare: ${f.reason.replace(/_/g, " ")}` + (f.attempts ? ` after ${f.attempts} tries` : "")),
+          el("td", {}, "")
+        )
+      );
+      return el("div", { class: trip.complete ? "trip" : "trip partial" },
+        el("h3", {}, title),
+        ground ? el("p", { class: "ground" }, ground) : "",
+        wins || front ? el("p", { class: "wins" }, [front, wins].filter(Boolean).join(", ")) : "",
+        el("table", {}, ...rows, ...failures)
+      );
+    })
+  );
+}
+
+async function loadJobs() {
+  const jobs = await api("GET", "/api/searches");
+  $("history").hidden = jobs.length === 0;
+  $("jobs").replaceChildren(
+    ...jobs.map((job) => {
+      let text = `${new Date(job.created_at).toLocaleString()}: ${job.status}`;
+      if (job.cheapest) text += `, best ${job.cheapest.place_name} ${money(job.cheapest.total)}`;
+      const li = el("li", {}, text);
+      li.addEventListener("click", () => watch(job.id));
+      return li;
+    })
+  );
+}
+
+$("add-traveler").addEventListener("click", () => addTraveler({}));
+$("start").addEventListener("click", start);
+$("cancel").addEventListener("click", async () => {
+  await api("DELETE", "/api/searches/" + current);
+  refresh();
+});
+
+loadTravelers();
+loadCountries();
+loadJobs();
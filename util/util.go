@@ -4,10 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"os"
-	"os/signal"
+	"path/filepath"
 	"sort"
-	"strings"
 	"time"
 )
 
@@ -25,9 +23,44 @@ func NewTraveler(name, location string) *Traveler {
 }
 
 type PollResponse struct {
-	ValidationErrs *validationErrs `json:"ValidationErrors`
-	// omitted: Query, status, legs, etc
-	Itineraries []*Itinerary `json:"Itineraries"`
+	ValidationErrs *validationErrs `json:"ValidationErrors"`
+	// omitted: Query, status, segments, agents, etc
+	Itineraries []*Itinerary   `json:"Itineraries"`
+	Legs        []*pollLeg     `json:"Legs"`
+	Places      []*pollPlace   `json:"Places"`
+	Carriers    []*pollCarrier `json:"Carriers"`
+}
+
+// enough of a leg to tell where an itinerary goes, when, and on what
+type pollLeg struct {
+	ID                 string `json:"Id"`
+	OriginStation      int    `json:"OriginStation"`
+	DestinationStation int    `json:"DestinationStation"`
+	// wall clock at each end, ie "2020-01-19T10:10:00"
+	Departure     string              `json:"Departure"`
+	Arrival       string              `json:"Arrival"`
+	Duration      int                 `json:"Duration"`
+	Carriers      []int               `json:"Carriers"`
+	FlightNumbers []*pollFlightNumber `json:"FlightNumbers"`
+}
+
+type pollFlightNumber struct {
+	FlightNumber string `json:"FlightNumber"`
+	CarrierID    int    `json:"CarrierId"`
+}
+
+type pollCarrier struct {
+	ID          int    `json:"Id"`
+	Code        string `json:"Code"`
+	DisplayCode string `json:"DisplayCode"`
+	Name        string `json:"Name"`
+}
+
+type pollPlace struct {
+	ID   int    `json:"Id"`
+	Code string `json:"Code"`
+	Type string `json:"Type"`
+	Name string `json:"Name"`
 }
 
 type validationErrs struct {
@@ -35,23 +68,40 @@ type validationErrs struct {
 }
 
 type Itinerary struct {
-	PricingOptions []*PricingOption `json:"PricingOptions`
+	OutboundLegID  string           `json:"OutboundLegId"`
+	InboundLegID   string           `json:"InboundLegId"`
+	PricingOptions []*PricingOption `json:"PricingOptions"`
 }
 
 type PricingOption struct {
 	Price      float64 `json:"Price"`
 	Deeplink   string  `json:"DeeplinkUrl"`
 	Location   string  `json:"Location"`
-	SrcAirport string  `json:"SrcAirport`
-	DstAirport string  `json:"DstAirport`
+	SrcAirport string  `json:"SrcAirport"`
+	DstAirport string  `json:"DstAirport"`
+	// the flights there and back, when the poll had the legs
+	Outbound *Flight `json:"outbound,omitempty"`
+	Inbound  *Flight `json:"inbound,omitempty"`
 }
 
-type Trips [][]*PricingOption
-
 // go is so goddamn stupid sometimes
-func IterTripsAndPrint(trips [][]*PricingOption) {
+func IterTripsAndPrint(trips []*Trip) {
 	for _, trip := range trips {
-		fmt.Printf("\nLOCATION: %s\nTOTAL PRICE: $%f\n\n", trip[0].Location, SumPricingOptList(trip))
+		fmt.Printf("\nLOCATION: %s\nTOTAL PRICE: $%f\n", trip.Destination.PlaceName, trip.Total())
+		for _, name := range trip.Travelers() {
+			leg := trip.Legs[name]
+			fmt.Printf("  %s: $%.2f %s -> %s\n", name, leg.Price, leg.From, leg.To)
+		}
+		if len(trip.Costs) > 0 {
+			for _, c := range trip.Costs {
+				fmt.Printf("  %s: $%.2f, %s\n", c.Name, c.Amount, c.Detail)
+			}
+			fmt.Printf("ALL IN: $%f\n", trip.Cost())
+		}
+		if len(trip.Missing) > 0 {
+			fmt.Printf("  no fare for: %v\n", trip.Missing)
+		}
+		fmt.Println()
 	}
 }
 
@@ -65,19 +115,13 @@ func SumPricingOptList(p []*PricingOption) float64 {
 	return sum
 }
 
-// sort option for pricing option list so we can output results nicely
-func (t Trips) Len() int {
-	return len(t)
-}
-func (t Trips) Swap(i, j int) {
-	t[i], t[j] = t[j], t[i]
-}
-func (t Trips) Less(i, j int) bool {
-	return SumPricingOptList(t[i]) < SumPricingOptList(t[j])
-}
-
+// LocationWrapper is both the autosuggest response and the catalog file
+// format. The api only ever sends Places; the rest is ours, see CatalogMeta
 type LocationWrapper struct {
-	Places []Location `json:"Places"`
+	SchemaVersion int        `json:"SchemaVersion,omitempty"`
+	BuiltAt       time.Time  `json:"BuiltAt,omitempty"`
+	Source        string     `json:"Source,omitempty"`
+	Places        []Location `json:"Places"`
 }
 type Location struct {
 	PlaceID     string `json:"PlaceId"`
@@ -86,9 +130,37 @@ type Location struct {
 	RegionID    string `json:"RegionId"`
 	CityID      string `json:"CityId"`
 	CountryName string `json:"CountryName"`
+
+	// filled in from an offline geo dataset, see MergeGeo. skyscanner doesn't
+	// give us any of this
+	Latitude    float64 `json:"Latitude,omitempty"`
+	Longitude   float64 `json:"Longitude,omitempty"`
+	ElevationFt int     `json:"ElevationFt,omitempty"`
+	TimeZone    string  `json:"TimeZone,omitempty"`
+
+	// Provenance is where this entry came from. nil for anything loaded out of
+	// a pre versioning catalog
+	Provenance *Provenance `json:"Provenance,omitempty"`
+}
+
+// Provenance records how a catalog entry got there
+type Provenance struct {
+	// Source is what produced it, ie "rapidapi autosuggest"
+	Source string `json:"Source"`
+	// Query is what we asked the source for, ie the line out of util/airports
+	Query   string    `json:"Query,omitempty"`
+	AddedAt time.Time `json:"AddedAt"`
+	// GeoSource is set once coordinates have been merged in
+	GeoSource string `json:"GeoSource,omitempty"`
+}
+
+// HasCoords is false for places we have no geo data for. 0,0 is in the ocean
+// so it doubles as "unset"
+func (l Location) HasCoords() bool {
+	return l.Latitude != 0 || l.Longitude != 0
 }
 
-// get locations. dont really need this anymore with NewSkyscanner
+// get locations. dont really need this anymore with NewSkyscanner or LoadCatalog
 func Locations() (*LocationWrapper, error) {
 	airports, err := ioutil.ReadFile("./util/airports.json")
 	if err != nil {
@@ -107,84 +179,6 @@ func Locations() (*LocationWrapper, error) {
 	return allAirports, nil
 }
 
-// non-go way of reading file line by line, to output json
-// make api calls from semantic location strings and get an airport location json back
-func GetLocationsJSON(ss SkyScanner) (*LocationWrapper, error) {
-	airports, err := ioutil.ReadFile("./util/airports")
-	if err != nil {
-		return nil, err
-	}
-
-	allAirports := &LocationWrapper{
-		Places: []Location{},
-	}
-
-	sigChan := make(chan os.Signal)
-	signal.Notify(sigChan, os.Interrupt)
-	go func() {
-		select {
-		case s := <-sigChan:
-			fmt.Printf("caught signal: %v, cleaning up\n", s)
-			b, err := json.Marshal(allAirports)
-			if err != nil {
-				fmt.Printf("err: %v\n", err)
-				os.Exit(1)
-			}
-
-			err = ioutil.WriteFile("airports.json", b, 0644)
-			if err != nil {
-				fmt.Println("err writing to file:", err.Error())
-				os.Exit(1)
-			}
-
-			fmt.Println("successfully cleaned up, exiting")
-			os.Exit(0)
-		}
-	}()
-
-	airportsString := strings.Split(string(airports), "\n")
-	for _, s := range airportsString {
-		if s == "" {
-			continue
-		}
-
-		l, err := ss.GetLocation(s)
-		if err != nil {
-			fmt.Printf("[ ERROR ] could not find location %s: %v\n", s, err)
-			continue
-		}
-
-		// fucking rate limiting bullshit
-		time.Sleep(1250 * time.Millisecond)
-
-		allAirports.Places = append(allAirports.Places, l...)
-	}
-
-	return allAirports, nil
-}
-
-func InitLocations(ss SkyScanner) (*LocationWrapper, error) {
-	locations, err := GetLocationsJSON(ss)
-	if err != nil {
-		fmt.Printf("err: %v\n", err)
-		os.Exit(1)
-	}
-
-	b, err := json.Marshal(locations)
-	if err != nil {
-		fmt.Printf("err: %v\n", err)
-		os.Exit(1)
-	}
-
-	err = ioutil.WriteFile("airports.json", b, 0644)
-	if err != nil {
-		fmt.Println("err writing to file:", err.Error())
-		os.Exit(1)
-	}
-
-	return nil, nil
-}
-
 func FilterJSON(locations []Location, filters ...string) *LocationWrapper {
 	res := &LocationWrapper{
 		Places: []Location{},
@@ -201,16 +195,28 @@ func FilterJSON(locations []Location, filters ...string) *LocationWrapper {
 	return res
 }
 
-func WriteResultsToFile(travelers map[string]*Traveler, itineraries map[string][]*PricingOption) {
+const (
+	ResultsViableFile    = "results-viable.json"
+	ResultsNonViableFile = "results-non-viable.json"
+)
+
+// WriteResultsToFile writes trips to ./results-viable.json if everybody has a
+// fare, ./results-non-viable.json if not, cheapest first
+func WriteResultsToFile(trips map[string]*Trip) {
+	WriteResultsToDir(".", trips)
+}
+
+// WriteResultsToDir is WriteResultsToFile somewhere other than ./
+func WriteResultsToDir(dir string, trips map[string]*Trip) {
 
 	// sort and write EVERY time bc this thing takes forever, and a write is cheap
 	viableTrips := Trips{}
 	nonViableTrips := Trips{}
-	for _, p := range itineraries {
-		if len(p) == len(travelers) {
-			viableTrips = append(viableTrips, p)
+	for _, t := range trips {
+		if t.Complete() {
+			viableTrips = append(viableTrips, t)
 		} else {
-			nonViableTrips = append(nonViableTrips, p)
+			nonViableTrips = append(nonViableTrips, t)
 		}
 	}
 
@@ -227,43 +233,47 @@ func WriteResultsToFile(travelers map[string]*Traveler, itineraries map[string][
 		fmt.Println("error marshaling json:", err.Error())
 	}
 
-	err = ioutil.WriteFile("./results-viable.json", bytesViableTrips, 0644)
+	err = ioutil.WriteFile(filepath.Join(dir, ResultsViableFile), bytesViableTrips, 0644)
 	if err != nil {
 		fmt.Println("error writing to file:", err.Error())
 	}
 
-	err = ioutil.WriteFile("./results-non-viable.json", bytesNonViableTrips, 0644)
+	err = ioutil.WriteFile(filepath.Join(dir, ResultsNonViableFile), bytesNonViableTrips, 0644)
 	if err != nil {
 		fmt.Println("error writing to file:", err.Error())
 	}
 }
 
-// pretty print the results
-func OutputResults() {
-	viableTripsJSON, err := ioutil.ReadFile("../results-viable.json")
+// ReadResults reads back what WriteResultsToFile wrote into dir
+func ReadResults(dir string) (viable, nonViable []*Trip, err error) {
+	viable, err = readTrips(filepath.Join(dir, ResultsViableFile))
 	if err != nil {
-		fmt.Println("error reading file:", err.Error())
-		return
+		return nil, nil, err
 	}
-
-	nonViableTripsJSON, err := ioutil.ReadFile("../results-non-viable.json")
+	nonViable, err = readTrips(filepath.Join(dir, ResultsNonViableFile))
 	if err != nil {
-		fmt.Println("error reading file:", err.Error())
-		return
+		return nil, nil, err
 	}
+	return viable, nonViable, nil
+}
 
-	viable := [][]*PricingOption{}
-	nonViable := [][]*PricingOption{}
-
-	err = json.Unmarshal(viableTripsJSON, &viable)
+func readTrips(path string) ([]*Trip, error) {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		fmt.Println("error unmarshaling:", err.Error())
-		return
+		return nil, err
 	}
+	trips := []*Trip{}
+	if err := json.Unmarshal(b, &trips); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s (results from an older version need searching again): %s", path, err.Error())
+	}
+	return trips, nil
+}
 
-	err = json.Unmarshal(nonViableTripsJSON, &nonViable)
+// pretty print the results in dir
+func OutputResults(dir string) {
+	viable, nonViable, err := ReadResults(dir)
 	if err != nil {
-		fmt.Println("error unmarshaling:", err.Error())
+		fmt.Println("error reading results:", err.Error())
 		return
 	}
 
@@ -0,0 +1,411 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// DefaultShortlist is how many trips go to a vote when nobody says
+const DefaultShortlist = 5
+
+// DefaultCostWeight is how much cost counts against votes for cost-weighted,
+// half and half
+const DefaultCostWeight = 0.5
+
+// Ballot is one traveler's say on a shortlist. Ranking is the trips they'd go
+// on, best first, by PlaceId or name, and anything left off they can live
+// with but don't want. Vetoes are trips they won't go on at all, and one veto
+// takes a trip out for everybody
+type Ballot struct {
+	Traveler string   `json:"traveler"`
+	Ranking  []string `json:"ranking"`
+	Vetoes   []string `json:"vetoes,omitempty"`
+}
+
+// VoteMethod turns ballots into points per trip, more is better. trips are
+// the ones nobody vetoed, and each ballot's ranking only has those, as
+// PlaceIDs
+type VoteMethod interface {
+	Name() string
+	// Rule is how points are worked out, for the explanation
+	Rule(trips int) string
+	Points(trips []*Trip, ballots []Ballot) map[string]float64
+}
+
+var voteMethods = map[string]VoteMethod{
+	"borda":         Borda{},
+	"approval":      Approval{},
+	"cost-weighted": CostWeighted{Weight: DefaultCostWeight},
+}
+
+// RegisterVoteMethod adds a way of counting votes, or replaces one
+func RegisterVoteMethod(m VoteMethod) {
+	voteMethods[m.Name()] = m
+}
+
+// VoteMethods is every method NewVoteMethod knows, sorted
+func VoteMethods() []string {
+	names := []string{}
+	for name := range voteMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewVoteMethod looks a method up by name. costWeight, if set, is how much
+// cost counts for cost-weighted, 0 to 1
+func NewVoteMethod(name string, costWeight float64) (VoteMethod, error) {
+	m, ok := voteMethods[name]
+	if !ok {
+		return nil, fmt.Errorf("don't know vote method %q, want one of %s", name, strings.Join(VoteMethods(), ", "))
+	}
+	if cw, ok := m.(CostWeighted); ok && costWeight != 0 {
+		if costWeight < 0 || costWeight > 1 {
+			return nil, fmt.Errorf("cost weight has to be between 0 and 1, not %v", costWeight)
+		}
+		cw.Weight = costWeight
+		m = cw
+	}
+	return m, nil
+}
+
+// Borda gives each trip n-1 points for being first on a ballot, n-2 for
+// second and so on, nothing for being left off
+type Borda struct{}
+
+func (Borda) Name() string { return "borda" }
+
+func (Borda) Rule(trips int) string {
+	return fmt.Sprintf("Borda count: each ballot gives its first pick %s, one less for each after that, and nothing to trips it leaves off. Ties go to the cheaper trip", pointsLabel(float64(trips-1)))
+}
+
+func (Borda) Points(trips []*Trip, ballots []Ballot) map[string]float64 {
+	points := zeroPoints(trips)
+	for _, b := range ballots {
+		for i, id := range b.Ranking {
+			points[id] += float64(len(trips) - 1 - i)
+		}
+	}
+	return points
+}
+
+// Approval gives a trip a point for every ballot that ranks it at all, the
+// order doesn't matter
+type Approval struct{}
+
+func (Approval) Name() string { return "approval" }
+
+func (Approval) Rule(trips int) string {
+	return "Approval: a trip gets a point from every ballot that ranks it, in any place. Ties go to the cheaper trip"
+}
+
+func (Approval) Points(trips []*Trip, ballots []Ballot) map[string]float64 {
+	points := zeroPoints(trips)
+	for _, b := range ballots {
+		for _, id := range b.Ranking {
+			points[id]++
+		}
+	}
+	return points
+}
+
+// CostWeighted mixes how well a trip does on Borda, as a share of the most
+// it could get, with how cheap it is, as the cheapest total over its own.
+// Weight is cost's share, so 0 is Borda and 1 is just the cheapest
+type CostWeighted struct {
+	Weight float64
+}
+
+func (CostWeighted) Name() string { return "cost-weighted" }
+
+func (c CostWeighted) Rule(trips int) string {
+	return fmt.Sprintf("Cost weighted: %.0f%% Borda points out of the most a trip could get, %.0f%% the cheapest trip's total over this one's, out of 100",
+		(1-c.Weight)*100, c.Weight*100)
+}
+
+func (c CostWeighted) Points(trips []*Trip, ballots []Ballot) map[string]float64 {
+	borda := Borda{}.Points(trips, ballots)
+	most := float64(len(ballots) * (len(trips) - 1))
+	cheapest := 0.0
+	for _, t := range trips {
+		if cheapest == 0 || t.Cost() < cheapest {
+			cheapest = t.Cost()
+		}
+	}
+
+	points := map[string]float64{}
+	for _, t := range trips {
+		id := t.Destination.PlaceID
+		pref, cost := 0.0, 1.0
+		if most > 0 {
+			pref = borda[id] / most
+		} else {
+			// nobody voted, so it's all cost
+			pref = 1
+		}
+		if t.Cost() > 0 {
+			cost = cheapest / t.Cost()
+		}
+		points[id] = math.Round(10000*((1-c.Weight)*pref+c.Weight*cost)) / 100
+	}
+	return points
+}
+
+func zeroPoints(trips []*Trip) map[string]float64 {
+	points := map[string]float64{}
+	for _, t := range trips {
+		points[t.Destination.PlaceID] = 0
+	}
+	return points
+}
+
+// Shortlist is the best n complete trips out of ranked, the ones worth
+// voting on
+func Shortlist(ranked []*Trip, n int) []*Trip {
+	if n <= 0 {
+		n = DefaultShortlist
+	}
+	res := []*Trip{}
+	for _, t := range ranked {
+		if len(res) == n {
+			break
+		}
+		if t.Complete() {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// VoteTally is how one trip did
+type VoteTally struct {
+	Destination string `json:"destination"`
+	PlaceName   string `json:"place_name"`
+	// Total is the whole trip, fares and ground costs, see Trip.Cost
+	Total  float64 `json:"total"`
+	Points float64 `json:"points"`
+	// Ranks is where each traveler put it once vetoed trips are out, 1 is
+	// first. Vetoed trips have none
+	Ranks    map[string]int `json:"ranks,omitempty"`
+	VetoedBy []string       `json:"vetoed_by,omitempty"`
+}
+
+// VoteResult is the group's choice and how it got there. Tallies is every
+// shortlisted trip, the choice first and vetoed ones last
+type VoteResult struct {
+	Method      string      `json:"method"`
+	Choice      *VoteTally  `json:"choice"`
+	Tallies     []VoteTally `json:"tallies"`
+	Voted       []string    `json:"voted"`
+	NotVoted    []string    `json:"not_voted"`
+	Explanation []string    `json:"explanation"`
+}
+
+// Explain is the explanation as a paragraph
+func (r *VoteResult) Explain() string {
+	return strings.Join(r.Explanation, ". ") + "."
+}
+
+// Vote picks one of shortlist with ballots, counted by method. travelers is
+// everybody going, so anyone who didn't vote can be named. Ballot entries
+// that aren't on the shortlist are left out and said so, since the
+// shortlist can move under a ballot while a search is still running
+func Vote(shortlist []*Trip, travelers []string, ballots []Ballot, method VoteMethod) (*VoteResult, error) {
+	if len(shortlist) == 0 {
+		return nil, fmt.Errorf("nothing on the shortlist to vote on")
+	}
+	going := map[string]bool{}
+	for _, name := range travelers {
+		going[name] = true
+	}
+
+	res := &VoteResult{Method: method.Name(), Voted: []string{}, NotVoted: []string{}, Explanation: []string{}}
+	tallies := map[string]*VoteTally{}
+	for _, t := range shortlist {
+		tallies[t.Destination.PlaceID] = &VoteTally{
+			Destination: t.Destination.PlaceID,
+			PlaceName:   t.Destination.PlaceName,
+			Total:       t.Cost(),
+			Ranks:       map[string]int{},
+		}
+	}
+
+	seen := map[string]bool{}
+	counted := []Ballot{}
+	offList := []string{}
+	for _, b := range ballots {
+		if !going[b.Traveler] {
+			return nil, fmt.Errorf("%q isn't on this trip", b.Traveler)
+		}
+		if seen[b.Traveler] {
+			return nil, fmt.Errorf("%s voted twice", b.Traveler)
+		}
+		seen[b.Traveler] = true
+
+		clean := Ballot{Traveler: b.Traveler, Ranking: []string{}}
+		ranked := map[string]bool{}
+		for _, ref := range b.Ranking {
+			t := findTrip(shortlist, ref)
+			if t == nil {
+				offList = append(offList, fmt.Sprintf("%s ranked %s", b.Traveler, ref))
+				continue
+			}
+			id := t.Destination.PlaceID
+			if ranked[id] {
+				continue
+			}
+			ranked[id] = true
+			clean.Ranking = append(clean.Ranking, id)
+		}
+		for _, ref := range b.Vetoes {
+			t := findTrip(shortlist, ref)
+			if t == nil {
+				offList = append(offList, fmt.Sprintf("%s vetoed %s", b.Traveler, ref))
+				continue
+			}
+			tallies[t.Destination.PlaceID].VetoedBy = append(tallies[t.Destination.PlaceID].VetoedBy, b.Traveler)
+		}
+		counted = append(counted, clean)
+	}
+	for _, name := range travelers {
+		if seen[name] {
+			res.Voted = append(res.Voted, name)
+		} else {
+			res.NotVoted = append(res.NotVoted, name)
+		}
+	}
+	sort.Strings(res.Voted)
+	sort.Strings(res.NotVoted)
+
+	// vetoed trips are out, and come off everybody's ranking so the rest
+	// score as if they were never there
+	open := []*Trip{}
+	for _, t := range shortlist {
+		if len(tallies[t.Destination.PlaceID].VetoedBy) == 0 {
+			open = append(open, t)
+		}
+	}
+	// Ranks come from what's left, so a veto moves everybody's picks up
+	for i, b := range counted {
+		ranking := []string{}
+		for _, id := range b.Ranking {
+			if len(tallies[id].VetoedBy) == 0 {
+				ranking = append(ranking, id)
+				tallies[id].Ranks[b.Traveler] = len(ranking)
+			}
+		}
+		counted[i].Ranking = ranking
+	}
+	if len(open) > 0 {
+		for id, p := range method.Points(open, counted) {
+			tallies[id].Points = p
+		}
+	}
+
+	for _, t := range shortlist {
+		res.Tallies = append(res.Tallies, *tallies[t.Destination.PlaceID])
+	}
+	sort.SliceStable(res.Tallies, func(i, j int) bool {
+		a, b := res.Tallies[i], res.Tallies[j]
+		if (len(a.VetoedBy) == 0) != (len(b.VetoedBy) == 0) {
+			return len(a.VetoedBy) == 0
+		}
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Total != b.Total {
+			return a.Total < b.Total
+		}
+		return a.Destination < b.Destination
+	})
+	if len(open) > 0 {
+		choice := res.Tallies[0]
+		res.Choice = &choice
+	}
+
+	res.explain(method, len(open), offList)
+	return res, nil
+}
+
+func (r *VoteResult) explain(method VoteMethod, open int, offList []string) {
+	say := func(format string, args ...interface{}) {
+		r.Explanation = append(r.Explanation, fmt.Sprintf(format, args...))
+	}
+	if open > 0 {
+		say("%s", method.Rule(open))
+	}
+	if len(r.Voted) == 0 {
+		say("Nobody voted, so it comes down to cost")
+	} else {
+		s := fmt.Sprintf("%s voted", joinNames(r.Voted))
+		if len(r.NotVoted) > 0 {
+			s += fmt.Sprintf(", %s didn't", joinNames(r.NotVoted))
+		}
+		say("%s", s)
+	}
+	for _, o := range offList {
+		say("%s, which isn't on the shortlist, so it doesn't count", o)
+	}
+	for _, t := range r.Tallies {
+		if len(t.VetoedBy) > 0 {
+			say("%s is out, vetoed by %s", t.PlaceName, joinNames(t.VetoedBy))
+		}
+	}
+
+	if r.Choice == nil {
+		say("Every trip on the shortlist was vetoed, so there's no choice. Widen the shortlist or talk it out")
+		return
+	}
+	c := r.Choice
+	s := fmt.Sprintf("%s wins with %s at $%.2f for everybody", c.PlaceName, pointsLabel(c.Points), c.Total)
+	firsts := []string{}
+	for name, rank := range c.Ranks {
+		if rank == 1 {
+			firsts = append(firsts, name)
+		}
+	}
+	sort.Strings(firsts)
+	if len(firsts) > 0 {
+		s += fmt.Sprintf(", first pick for %s", joinNames(firsts))
+	}
+	say("%s", s)
+
+	if len(r.Tallies) > 1 && len(r.Tallies[1].VetoedBy) == 0 {
+		next := r.Tallies[1]
+		switch {
+		case next.Points == c.Points && next.Total == c.Total:
+			say("%s tied on points and price, so it came down to PlaceId", next.PlaceName)
+		case next.Points == c.Points:
+			say("%s tied on points at $%.2f, so the cheaper one wins", next.PlaceName, next.Total)
+		default:
+			say("Next was %s with %s at $%.2f", next.PlaceName, pointsLabel(next.Points), next.Total)
+		}
+	}
+}
+
+func pointsLabel(p float64) string {
+	if p == 1 {
+		return "1 point"
+	}
+	return fmt.Sprintf("%g points", p)
+}
+
+func joinNames(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// findTrip is the trip ref names, by PlaceId or name
+func findTrip(trips []*Trip, ref string) *Trip {
+	for _, t := range trips {
+		if strings.EqualFold(t.Destination.PlaceID, ref) || strings.EqualFold(t.Destination.PlaceName, ref) {
+			return t
+		}
+	}
+	return nil
+}
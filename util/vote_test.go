@@ -0,0 +1,48 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func voteTrip(id, name string, fare float64) *Trip {
+	t := NewTrip(Location{PlaceID: id, PlaceName: name}, TripDates{Outbound: "2020-01-01", Inbound: "2020-01-05"})
+	t.AddFare("alice", Fare{Price: fare})
+	t.AddFare("bob", Fare{Price: fare})
+	return t
+}
+
+func TestVoteRanksAfterVetoes(t *testing.T) {
+	shortlist := []*Trip{
+		voteTrip("CUN-sky", "Cancun", 100),
+		voteTrip("HAV-sky", "Havana", 150),
+		voteTrip("PUJ-sky", "Punta Cana", 200),
+	}
+	ballots := []Ballot{
+		{Traveler: "alice", Ranking: []string{"Cancun", "Havana", "Punta Cana"}},
+		{Traveler: "bob", Ranking: []string{"Havana", "Punta Cana"}, Vetoes: []string{"CUN-sky"}},
+	}
+
+	res, err := Vote(shortlist, []string{"alice", "bob"}, ballots, Borda{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Choice == nil || res.Choice.Destination != "HAV-sky" {
+		t.Fatalf("choice %+v, want Havana", res.Choice)
+	}
+	// with Cancun out Havana is first for both of them
+	if res.Choice.Ranks["alice"] != 1 || res.Choice.Ranks["bob"] != 1 {
+		t.Errorf("Havana ranks %v, want first for alice and bob", res.Choice.Ranks)
+	}
+	for _, tally := range res.Tallies {
+		if tally.Destination == "CUN-sky" && len(tally.Ranks) != 0 {
+			t.Errorf("vetoed Cancun still has ranks %v", tally.Ranks)
+		}
+		if tally.Destination == "PUJ-sky" && (tally.Ranks["alice"] != 2 || tally.Ranks["bob"] != 2) {
+			t.Errorf("Punta Cana ranks %v, want second for both", tally.Ranks)
+		}
+	}
+	if !strings.Contains(res.Explain(), "Havana wins with 2 points at $300.00 for everybody, first pick for alice and bob") {
+		t.Errorf("explanation %q", res.Explain())
+	}
+}
@@ -0,0 +1,301 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AlertKind is why a watch spoke up
+type AlertKind string
+
+const (
+	// a trip's cost went under the watch's threshold
+	AlertBelowThreshold AlertKind = "below_threshold"
+	// a trip's cost is the lowest the watch has seen it
+	AlertNewLow AlertKind = "new_low"
+)
+
+// Alert is a fare drop worth telling the group about. Only complete trips,
+// everybody priced, get alerts, since that's the only total worth booking on
+type Alert struct {
+	Kind     AlertKind `json:"kind"`
+	Time     time.Time `json:"time"`
+	WatchID  string    `json:"watch_id"`
+	RunID    string    `json:"run_id"`
+	Outbound string    `json:"outbound"`
+	Inbound  string    `json:"inbound"`
+	// Cost is the trip now, fares and ground costs, see Trip.Cost, and
+	// Total the fares alone. Previous is the cost last check and Best the
+	// lowest before this one, both 0 the first time it's seen
+	Cost      float64    `json:"cost"`
+	Total     float64    `json:"total"`
+	Previous  float64    `json:"previous,omitempty"`
+	Best      float64    `json:"best,omitempty"`
+	Threshold float64    `json:"threshold,omitempty"`
+	Trip      ReportTrip `json:"trip"`
+}
+
+// Summary is the alert in a line, for subjects and titles
+func (a Alert) Summary() string {
+	switch a.Kind {
+	case AlertBelowThreshold:
+		return fmt.Sprintf("%s is down to $%.2f for everybody, under $%.2f", a.Trip.PlaceName, a.Cost, a.Threshold)
+	default:
+		return fmt.Sprintf("%s is down to $%.2f for everybody, the lowest yet", a.Trip.PlaceName, a.Cost)
+	}
+}
+
+// Notification is the alert as it gets sent: the summary, how it's moved and
+// the trip with everybody's fare and where to book it
+func (a Alert) Notification() Notification {
+	detail := fmt.Sprintf("%s to %s", a.Outbound, a.Inbound)
+	if a.Cost != a.Total {
+		detail += fmt.Sprintf(", $%.2f of it fares", a.Total)
+	}
+	if a.Previous > 0 {
+		detail += fmt.Sprintf(", was $%.2f last check", a.Previous)
+	}
+	if a.Best > 0 {
+		detail += fmt.Sprintf(", best before was $%.2f", a.Best)
+	}
+	return Notification{
+		Kind:     NotifyFareAlert,
+		Title:    a.Summary(),
+		Detail:   detail,
+		Time:     a.Time,
+		RunID:    a.RunID,
+		Outbound: a.Outbound,
+		Inbound:  a.Inbound,
+		Trips:    []ReportTrip{a.Trip},
+	}
+}
+
+// PricePoint is one price at one check. For a trip Price is its Cost and
+// Fares the fares alone, for a leg Price is the fare
+type PricePoint struct {
+	Time  time.Time `json:"time"`
+	RunID string    `json:"run_id"`
+	Price float64   `json:"price"`
+	Fares float64   `json:"fares,omitempty"`
+}
+
+// PriceHistory is everything a watch has priced, oldest first
+type PriceHistory struct {
+	WatchID string `json:"watch_id"`
+	// Trips is each destination's cost, complete trips only
+	Trips map[string][]PricePoint `json:"trips"`
+	// Legs is every fare found, by destination then traveler
+	Legs map[string]map[string][]PricePoint `json:"legs"`
+}
+
+// WatchHistory pulls a watch's prices together out of its runs
+func (s *Store) WatchHistory(watchID string) (*PriceHistory, error) {
+	runs, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	h := &PriceHistory{
+		WatchID: watchID,
+		Trips:   map[string][]PricePoint{},
+		Legs:    map[string]map[string][]PricePoint{},
+	}
+	// Runs is newest first
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.Watch != watchID {
+			continue
+		}
+		trips, err := s.Trips(run.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range trips {
+			if t.Complete() {
+				h.Trips[t.Destination.PlaceID] = append(h.Trips[t.Destination.PlaceID], PricePoint{Time: run.StartedAt, RunID: run.ID, Price: t.Cost(), Fares: t.Total()})
+			}
+		}
+		legs, err := s.Legs(run.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, leg := range legs {
+			if leg.Fare == nil {
+				continue
+			}
+			if h.Legs[leg.Destination] == nil {
+				h.Legs[leg.Destination] = map[string][]PricePoint{}
+			}
+			h.Legs[leg.Destination][leg.Traveler] = append(h.Legs[leg.Destination][leg.Traveler], PricePoint{Time: leg.Time, RunID: run.ID, Price: leg.Fare.Price})
+		}
+	}
+	return h, nil
+}
+
+// Last and Best are a destination's latest cost and its lowest, 0 if it's
+// never been complete
+func (h *PriceHistory) Last(destination string) float64 {
+	points := h.Trips[destination]
+	if len(points) == 0 {
+		return 0
+	}
+	return points[len(points)-1].Price
+}
+
+func (h *PriceHistory) Best(destination string) float64 {
+	best := 0.0
+	for _, p := range h.Trips[destination] {
+		if best == 0 || p.Price < best {
+			best = p.Price
+		}
+	}
+	return best
+}
+
+// Destinations is every destination with a cost, sorted
+func (h *PriceHistory) Destinations() []string {
+	ids := []string{}
+	for id := range h.Trips {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Watcher searches the same trip over and over and raises alerts when it
+// gets cheaper. Each check is a run in Store, tagged with ID, which is where
+// the price history comes from, so a watch picks up where it left off after a
+// restart
+type Watcher struct {
+	ID     string
+	Engine *Engine
+	Store  *Store
+	// Spec is what gets searched each time, usually the best few
+	// destinations of an earlier run
+	Spec *SearchSpec
+	// Every is the time between checks, Checks how many to do. 0 checks is
+	// until ctx is done
+	Every  time.Duration
+	Checks int
+	// Threshold, if set, alerts on any trip whose cost goes under it. Trips
+	// going under their own lowest so far alert regardless
+	Threshold float64
+	Notifier  Notifier
+	// OnCheck, if set, hears about each check once it's done
+	OnCheck func(run *Run, alerts []Alert)
+}
+
+// Run checks every Every until it's done Checks of them or ctx is done. The
+// first check is straight away
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.ID == "" {
+		id, err := newUUID()
+		if err != nil {
+			return err
+		}
+		w.ID = id
+	}
+	for i := 0; w.Checks == 0 || i < w.Checks; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(w.Every):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if err := w.Check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Check searches once, records it and sends any alerts
+func (w *Watcher) Check(ctx context.Context) error {
+	history, err := w.Store.WatchHistory(w.ID)
+	if err != nil {
+		return err
+	}
+
+	rec, err := w.Store.begin(Run{Command: "watch", Watch: w.ID}, w.Spec)
+	if err != nil {
+		return err
+	}
+	engine := *w.Engine
+	engine.Subscriber = rec
+	if w.Engine.Subscriber != nil {
+		engine.Subscriber = Subscribers{w.Engine.Subscriber, rec}
+	}
+	engine.Checkpoint = rec.Checkpoint
+
+	result, searchErr := engine.Run(ctx, w.Spec)
+	if err := rec.Finish(ctx, result, searchErr); err != nil {
+		return err
+	}
+	run, err := w.Store.Run(rec.ID())
+	if err != nil {
+		return err
+	}
+	// a search that stopped part way still priced something, so it still
+	// counts, but the error is worth hearing about
+	if searchErr != nil && ctx.Err() == nil {
+		fmt.Printf("watch check stopped early: %v\n", searchErr)
+	}
+
+	alerts := w.alerts(history, run, result)
+	for _, a := range alerts {
+		if w.Notifier == nil {
+			continue
+		}
+		if err := w.Notifier.Notify(ctx, a.Notification()); err != nil {
+			fmt.Printf("err sending alert: %v\n", err)
+		}
+	}
+	if w.OnCheck != nil {
+		w.OnCheck(run, alerts)
+	}
+	return nil
+}
+
+// alerts compares the check's complete trips to what the watch had seen
+// before. Going under the threshold only alerts on the way down, not every
+// check it stays there
+func (w *Watcher) alerts(history *PriceHistory, run *Run, result *SearchResult) []Alert {
+	alerts := []Alert{}
+	if result == nil {
+		return alerts
+	}
+	report := NewReport(w.Spec.Travelers, result.Trips, w.Spec.OutboundDate, w.Spec.InboundDate)
+	for _, trip := range report.Trips {
+		if !trip.Complete {
+			continue
+		}
+		a := Alert{
+			Time:      time.Now().UTC(),
+			WatchID:   w.ID,
+			RunID:     run.ID,
+			Outbound:  w.Spec.OutboundDate,
+			Inbound:   w.Spec.InboundDate,
+			Cost:      trip.Cost,
+			Total:     trip.Total,
+			Previous:  history.Last(trip.Destination),
+			Best:      history.Best(trip.Destination),
+			Threshold: w.Threshold,
+			Trip:      trip,
+		}
+		switch {
+		case w.Threshold > 0 && trip.Cost <= w.Threshold && (a.Previous == 0 || a.Previous > w.Threshold):
+			a.Kind = AlertBelowThreshold
+		case a.Best > 0 && trip.Cost < a.Best:
+			a.Kind = AlertNewLow
+		default:
+			continue
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
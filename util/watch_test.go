@@ -0,0 +1,80 @@
+package util
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// fixedCost is a ground cost per destination, whatever the trip
+type fixedCost map[string]float64
+
+func (fixedCost) Name() string { return "lodging" }
+
+func (c fixedCost) Cost(trip *Trip) (CostItem, bool) {
+	amount, ok := c[trip.Destination.PlaceID]
+	return CostItem{Name: "lodging", Amount: amount}, ok
+}
+
+func TestWatchAlertsOnCost(t *testing.T) {
+	places := []Location{
+		{PlaceID: "CUN-sky", PlaceName: "Cancun", CountryID: "MX-sky", CityID: "CUNA-sky"},
+		{PlaceID: "HAV-sky", PlaceName: "Havana", CountryID: "CU-sky", CityID: "HAVA-sky"},
+	}
+	// Cancun has the cheaper fares, but once everybody's slept there it's
+	// Havana that costs less
+	ss := newFareProvider(places, map[string]map[string]float64{
+		"DEN-sky": {"CUN-sky": 100, "HAV-sky": 150},
+		"JFK-sky": {"CUN-sky": 100, "HAV-sky": 150},
+	})
+	store, err := OpenStore(filepath.Join(t.TempDir(), "runs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &SearchSpec{
+		Travelers: map[string]*Traveler{
+			"alice": NewTraveler("alice", "DEN-sky"),
+			"bob":   NewTraveler("bob", "JFK-sky"),
+		},
+		OutboundDate: "2020-01-01",
+		InboundDate:  "2020-01-05",
+		Destinations: places,
+		Costs:        []CostComponent{fixedCost{"CUN-sky": 300, "HAV-sky": 50}},
+	}
+
+	alerts := []Alert{}
+	w := &Watcher{
+		Engine:    testEngine(ss),
+		Store:     store,
+		Spec:      spec,
+		Checks:    1,
+		Threshold: 400,
+		OnCheck:   func(run *Run, a []Alert) { alerts = a },
+	}
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancun's 200 in fares is under the threshold, its 500 all in isn't
+	if len(alerts) != 1 || alerts[0].Trip.Destination != "HAV-sky" {
+		t.Fatalf("alerts %+v, want just Havana", alerts)
+	}
+	a := alerts[0]
+	if a.Kind != AlertBelowThreshold || a.Cost != 350 || a.Total != 300 {
+		t.Errorf("alert %s cost %v total %v, want below_threshold at 350 with 300 of fares", a.Kind, a.Cost, a.Total)
+	}
+	if want := "Havana is down to $350.00 for everybody, under $400.00"; a.Summary() != want {
+		t.Errorf("summary %q, want %q", a.Summary(), want)
+	}
+
+	h, err := store.WatchHistory(w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Last("CUN-sky") != 500 || h.Last("HAV-sky") != 350 {
+		t.Errorf("history has Cancun at %v and Havana at %v, want 500 and 350", h.Last("CUN-sky"), h.Last("HAV-sky"))
+	}
+	if p := h.Trips["CUN-sky"]; len(p) != 1 || p[0].Fares != 200 {
+		t.Errorf("Cancun's history %+v should keep its 200 of fares", p)
+	}
+}
@@ -0,0 +1,214 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// everything in here is for the private skyscanner.com flow described in the
+// README: load the results page like a browser would, scrape the utid/viewId
+// out of the html, then hit the conductor fps3 endpoint with them
+
+const (
+	webHost       = "https://www.skyscanner.net"
+	fps3SearchURI = "/g/conductor/v1/fps3/search/?geo_schema=skyscanner&carrier_schema=skyscanner&response_include=query;deeplink;segment;stats;fqs;pqs"
+)
+
+var (
+	utidRegexp   = regexp.MustCompile(`"utid"\s*:\s*"([0-9a-fA-F-]{36})"`)
+	viewIDRegexp = regexp.MustCompile(`"viewId"\s*:\s*"([0-9a-fA-F-]{36})"`)
+)
+
+// WebView is the pair of ids the website hands out per page view. Every
+// request against the private API has to carry them
+type WebView struct {
+	UTID   string `json:"utid"`
+	ViewID string `json:"viewId"`
+}
+
+// ParseWebView pulls the utid and viewId out of a results page
+func ParseWebView(html []byte) (*WebView, error) {
+	utid := utidRegexp.FindSubmatch(html)
+	if utid == nil {
+		return nil, fmt.Errorf("no utid found in view html")
+	}
+
+	viewID := viewIDRegexp.FindSubmatch(html)
+	if viewID == nil {
+		return nil, fmt.Errorf("no viewId found in view html")
+	}
+
+	return &WebView{
+		UTID:   string(utid[1]),
+		ViewID: string(viewID[1]),
+	}, nil
+}
+
+// WebRoute is a round trip in the shape the website wants it. Places are the
+// same ids the rapidapi uses ("DEN-sky"), dates are 2020-01-01
+type WebRoute struct {
+	Origin       string
+	Destination  string
+	OutboundDate string
+	InboundDate  string
+}
+
+func (r WebRoute) validate() error {
+	if r.Origin == "" || r.Destination == "" {
+		return fmt.Errorf("route needs an origin and a destination")
+	}
+	if _, err := time.Parse("2006-01-02", r.OutboundDate); err != nil {
+		return fmt.Errorf("bad outbound date %q: %s", r.OutboundDate, err.Error())
+	}
+	if _, err := time.Parse("2006-01-02", r.InboundDate); err != nil {
+		return fmt.Errorf("bad inbound date %q: %s", r.InboundDate, err.Error())
+	}
+	return nil
+}
+
+// ViewPath is the results page path, ie /transport/flights/den/iad/200119/200126/
+func (r WebRoute) ViewPath() string {
+	q := url.Values{}
+	q.Set("adults", "1")
+	q.Set("children", "0")
+	q.Set("adultsv2", "1")
+	q.Set("infants", "0")
+	q.Set("cabinclass", "economy")
+	q.Set("rtn", "1")
+	q.Set("preferdirects", "false")
+	q.Set("outboundaltsenabled", "false")
+	q.Set("inboundaltsenabled", "false")
+	q.Set("ref", "home")
+
+	return fmt.Sprintf("/transport/flights/%s/%s/%s/%s/?%s",
+		strings.ToLower(webPlaceCode(r.Origin)),
+		strings.ToLower(webPlaceCode(r.Destination)),
+		webDate(r.OutboundDate),
+		webDate(r.InboundDate),
+		q.Encode(),
+	)
+}
+
+// rapidapi ids are "DEN-sky", the website just wants "DEN"
+func webPlaceCode(placeID string) string {
+	return strings.ToUpper(strings.TrimSuffix(placeID, "-sky"))
+}
+
+// 2020-01-19 -> 200119. callers validate first
+func webDate(date string) string {
+	t, _ := time.Parse("2006-01-02", date)
+	return t.Format("060102")
+}
+
+type fps3Leg struct {
+	Origin                     string `json:"origin"`
+	Destination                string `json:"destination"`
+	Date                       string `json:"date"`
+	AddAlternativeOrigins      bool   `json:"add_alternative_origins"`
+	AddAlternativeDestinations bool   `json:"add_alternative_destinations"`
+}
+
+type fps3Request struct {
+	Market        string    `json:"market"`
+	Currency      string    `json:"currency"`
+	Locale        string    `json:"locale"`
+	CabinClass    string    `json:"cabin_class"`
+	PreferDirects bool      `json:"prefer_directs"`
+	TripType      string    `json:"trip_type"`
+	Legs          []fps3Leg `json:"legs"`
+	Adults        int       `json:"adults"`
+	ChildAges     []int     `json:"child_ages"`
+}
+
+func newFPS3Request(r WebRoute) fps3Request {
+	origin := webPlaceCode(r.Origin)
+	destination := webPlaceCode(r.Destination)
+	return fps3Request{
+		Market:     "US",
+		Currency:   "USD",
+		Locale:     "en-US",
+		CabinClass: "economy",
+		TripType:   "return",
+		Legs: []fps3Leg{
+			{Origin: origin, Destination: destination, Date: r.OutboundDate},
+			{Origin: destination, Destination: origin, Date: r.InboundDate},
+		},
+		Adults:    1,
+		ChildAges: []int{},
+	}
+}
+
+// omitted: query, legs, segments, places, carriers, agents
+type fps3Response struct {
+	Itineraries []struct {
+		ID             string `json:"id"`
+		PricingOptions []struct {
+			AgentIDs []string `json:"agent_ids"`
+			Price    struct {
+				Amount       *float64 `json:"amount"`
+				UpdateStatus string   `json:"update_status"`
+			} `json:"price"`
+			Items []struct {
+				AgentID string `json:"agent_id"`
+				URL     string `json:"url"`
+			} `json:"items"`
+		} `json:"pricing_options"`
+	} `json:"itineraries"`
+}
+
+// ParseFPS3Response turns a conductor search response into one PricingOption
+// per itinerary (its cheapest price), cheapest first. Unpriced itineraries are
+// dropped
+func ParseFPS3Response(body []byte, r WebRoute, placeName string) ([]*PricingOption, error) {
+	res := &fps3Response{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, fmt.Errorf("error unmarshaling fps3 response: %s", err.Error())
+	}
+
+	options := []*PricingOption{}
+	for _, itin := range res.Itineraries {
+		var best *PricingOption
+		for _, po := range itin.PricingOptions {
+			if po.Price.Amount == nil {
+				continue
+			}
+			if best != nil && best.Price <= *po.Price.Amount {
+				continue
+			}
+
+			deeplink := ""
+			if len(po.Items) > 0 {
+				deeplink = po.Items[0].URL
+				if strings.HasPrefix(deeplink, "/") {
+					deeplink = webHost + deeplink
+				}
+			}
+
+			best = &PricingOption{
+				Price:      *po.Price.Amount,
+				Deeplink:   deeplink,
+				Location:   placeName,
+				SrcAirport: r.Origin,
+				DstAirport: r.Destination,
+			}
+		}
+		if best != nil {
+			options = append(options, best)
+		}
+	}
+
+	if len(options) == 0 {
+		return nil, fmt.Errorf("no pricing option was found for this leg")
+	}
+
+	sort.SliceStable(options, func(i, j int) bool {
+		return options[i].Price < options[j].Price
+	})
+
+	return options, nil
+}
@@ -0,0 +1,145 @@
+package util
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	fixtureUTID   = "e508f0bb-33f2-4eaa-8fac-ee0d6a732b10"
+	fixtureViewID = "79e58113-943a-4f48-8602-f9e3c6485e91"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseWebView(t *testing.T) {
+	view, err := ParseWebView(readFixture(t, "view.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.UTID != fixtureUTID || view.ViewID != fixtureViewID {
+		t.Errorf("got %+v", view)
+	}
+
+	for name, html := range map[string]string{
+		"no utid":   `<script>{"viewId": "` + fixtureViewID + `"}</script>`,
+		"no viewId": `<script>{"utid": "` + fixtureUTID + `"}</script>`,
+		"bad utid":  `<script>{"utid": "nope", "viewId": "` + fixtureViewID + `"}</script>`,
+	} {
+		if _, err := ParseWebView([]byte(html)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestParseFPS3Response(t *testing.T) {
+	route := WebRoute{Origin: "DEN-sky", Destination: "CUN-sky", OutboundDate: "2020-01-19", InboundDate: "2020-01-26"}
+	options, err := ParseFPS3Response(readFixture(t, "fps3.json"), route, "Cancún")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// three itineraries, one still pending with no price, so two options,
+	// each at its cheapest agent, cheapest first
+	want := []float64{157.2, 238.96}
+	if len(options) != len(want) {
+		t.Fatalf("got %d options, want %d", len(options), len(want))
+	}
+	for i, o := range options {
+		if o.Price != want[i] {
+			t.Errorf("option %d: price %v, want %v", i, o.Price, want[i])
+		}
+		if o.SrcAirport != "DEN-sky" || o.DstAirport != "CUN-sky" || o.Location != "Cancún" {
+			t.Errorf("option %d: got %+v", i, o)
+		}
+		if !strings.HasPrefix(o.Deeplink, webHost+"/transport_deeplink/") {
+			t.Errorf("option %d: relative deeplink not made absolute: %s", i, o.Deeplink)
+		}
+	}
+
+	if _, err := ParseFPS3Response([]byte(`{"itineraries": []}`), route, ""); err == nil {
+		t.Error("no itineraries: want an error")
+	}
+	if _, err := ParseFPS3Response([]byte(`{`), route, ""); err == nil {
+		t.Error("bad json: want an error")
+	}
+}
+
+// recordingTransport keeps every request on the way to the fixtures
+type recordingTransport struct {
+	next     http.RoundTripper
+	requests []*http.Request
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requests = append(r.requests, req)
+	return r.next.RoundTrip(req)
+}
+
+func TestCreateViewThenInitSessionCommercial(t *testing.T) {
+	rec := &recordingTransport{next: &fixtureTransport{dir: "testdata"}}
+	ss, err := newSkyScanner("", Config{}, &http.Client{Transport: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := ss.CreateView("2020-01-19", "2020-01-26", "DEN-sky", "CUN-sky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.UTID != fixtureUTID || view.ViewID != fixtureViewID {
+		t.Fatalf("got view %+v", view)
+	}
+
+	options, err := ss.InitSessionCommercial(view, "2020-01-19", "2020-01-26", "DEN-sky", "CUN-sky", "Cancún")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) == 0 || options[0].Price != 157.2 {
+		t.Fatalf("got %+v", options)
+	}
+
+	if len(rec.requests) != 2 {
+		t.Fatalf("made %d requests, want the view and the search", len(rec.requests))
+	}
+	page, search := rec.requests[0], rec.requests[1]
+	if page.Method != http.MethodGet || !strings.HasPrefix(page.URL.Path, "/transport/flights/den/cun/200119/200126/") {
+		t.Errorf("view request: %s %s", page.Method, page.URL)
+	}
+	if search.Method != http.MethodPost || !strings.Contains(search.URL.Path, "/conductor/v1/fps3/search") {
+		t.Errorf("search request: %s %s", search.Method, search.URL)
+	}
+	// the search has to carry the ids scraped off the page
+	for header, want := range map[string]string{
+		"x-skyscanner-utid":   fixtureUTID,
+		"x-skyscanner-viewid": fixtureViewID,
+		"referer":             webHost + "/transport/flights/den/cun/200119/200126/",
+	} {
+		if got := search.Header.Get(header); !strings.HasPrefix(got, want) {
+			t.Errorf("search %s: got %q, want %q", header, got, want)
+		}
+	}
+	if search.Header.Get("x-rapidapi-key") != "" {
+		t.Error("the website flow shouldn't send the rapidapi key")
+	}
+}
+
+func TestInitSessionCommercialNeedsAView(t *testing.T) {
+	ss, err := newSkyScanner("", Config{}, &http.Client{Transport: &fixtureTransport{dir: "testdata"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.InitSessionCommercial(nil, "2020-01-19", "2020-01-26", "DEN-sky", "CUN-sky", ""); err == nil {
+		t.Error("want an error without a view")
+	}
+}
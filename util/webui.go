@@ -0,0 +1,21 @@
+package util
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// the planner page, see ui/. it only talks to the json api
+//
+//go:embed ui
+var uiFiles embed.FS
+
+func uiHandler() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		// can only happen if the embed line above is wrong
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
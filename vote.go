@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// vote settles where the group goes: it shortlists a run's best trips and
+// counts everybody's ballots from a file. Without -ballots it prints the
+// shortlist and a ballot to fill in
+func runVote(args []string) {
+	fs := flag.NewFlagSet("vote", flag.ExitOnError)
+	db := fs.String("db", util.DefaultStorePath(), "where runs are kept")
+	runID := fs.String("run", "", "run to vote on, default the latest search")
+	top := fs.Int("top", util.DefaultShortlist, "how many of the run's best complete trips go on the shortlist")
+	method := fs.String("method", "borda", "how votes are counted: "+strings.Join(util.VoteMethods(), "|"))
+	costWeight := fs.Float64("cost-weight", util.DefaultCostWeight, "for cost-weighted, how much cost counts against votes, 0 to 1")
+	ballotsPath := fs.String("ballots", "", "json file of ballots, [{\"traveler\": ..., \"ranking\": [...], \"vetoes\": [...]}]")
+	fs.Parse(args)
+
+	m, err := util.NewVoteMethod(*method, *costWeight)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(2)
+	}
+
+	store, err := util.OpenStore(*db)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	run := pickRun(store, *runID)
+	trips, err := store.Trips(run.ID)
+	if err != nil {
+		fmt.Printf("err reading trips: %v\n", err)
+		os.Exit(1)
+	}
+	shortlist := util.Shortlist(trips, *top)
+	if len(shortlist) == 0 {
+		fmt.Printf("run %s has no trips that work for everybody to vote on\n", shortID(run.ID))
+		os.Exit(1)
+	}
+	travelers := []string{}
+	for name := range run.Spec.Travelers {
+		travelers = append(travelers, name)
+	}
+	sort.Strings(travelers)
+
+	if *ballotsPath == "" {
+		printShortlist(run, shortlist, travelers)
+		return
+	}
+
+	b, err := ioutil.ReadFile(*ballotsPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	ballots := []util.Ballot{}
+	if err := json.Unmarshal(b, &ballots); err != nil {
+		fmt.Printf("err reading ballots: %v\n", err)
+		os.Exit(1)
+	}
+	res, err := util.Vote(shortlist, travelers, ballots, m)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("run %s, %s to %s, %s vote\n\n", shortID(run.ID), run.Spec.Outbound, run.Spec.Inbound, res.Method)
+	for i, t := range res.Tallies {
+		line := fmt.Sprintf("%d. %s (%s): $%.2f, %g points", i+1, t.PlaceName, t.Destination, t.Total, t.Points)
+		if len(t.VetoedBy) > 0 {
+			line = fmt.Sprintf("-- %s (%s): $%.2f, vetoed by %s", t.PlaceName, t.Destination, t.Total, strings.Join(t.VetoedBy, ", "))
+		}
+		fmt.Println(line)
+	}
+	fmt.Println()
+	if res.Choice != nil {
+		fmt.Printf("the group's going to %s\n", res.Choice.PlaceName)
+	}
+	for _, e := range res.Explanation {
+		fmt.Printf("  %s\n", e)
+	}
+}
+
+func printShortlist(run *util.Run, shortlist []*util.Trip, travelers []string) {
+	fmt.Printf("run %s, %s to %s, shortlist:\n\n", shortID(run.ID), run.Spec.Outbound, run.Spec.Inbound)
+	ids := []string{}
+	for i, t := range shortlist {
+		fmt.Printf("%d. %s (%s): $%.2f\n", i+1, t.Destination.PlaceName, t.Destination.PlaceID, t.Cost())
+		ids = append(ids, t.Destination.PlaceID)
+	}
+
+	ballots := []util.Ballot{}
+	for _, name := range travelers {
+		ballots = append(ballots, util.Ballot{Traveler: name, Ranking: ids, Vetoes: []string{}})
+	}
+	b, _ := json.MarshalIndent(ballots, "", "  ")
+	fmt.Printf("\neverybody reorders their ranking, drops what they don't want and vetoes what they won't do, then:\n")
+	fmt.Printf("  flight-finder vote -run %s -ballots ballots.json\n\nballots.json:\n%s\n", shortID(run.ID), b)
+}
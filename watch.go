@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/abgordon/flight-finder/util"
+)
+
+// watch re-prices the best few destinations of an earlier run on a schedule
+// and says so when the group total drops. watch history <id> shows what it's
+// seen
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	airports := fs.String("airports", defaultAirportsJSON, "path to the location catalog")
+	db := fs.String("db", util.DefaultStorePath(), "where runs are kept")
+	from := fs.String("from", "", "run whose best destinations get watched, default the latest search")
+	id := fs.String("id", "", "carry on an existing watch instead of starting one")
+	top := fs.Int("top", 5, "how many of -from's best destinations to watch")
+	every := fs.Duration("every", 24*time.Hour, "time between checks")
+	checks := fs.Int("checks", 0, "stop after this many checks, 0 to keep going")
+	threshold := fs.Float64("threshold", 0, "alert when a trip's cost for everybody, fares and ground costs, goes under this")
+	budget := fs.Int("budget", 0, "most api calls each check can make, 0 is no limit")
+	verbose := fs.Bool("v", false, "log every call like search does")
+	fixtures := fs.String("fixtures", "", "answer every request from saved responses in this dir instead of the API")
+	apiKey := apiKeyFlag(fs)
+	notify := repeatFlag{}
+	fs.Var(&notify, "notify", "where alerts go, repeatable: "+strings.Join(util.NotifierSchemes(), "|")+" urls, default stdout")
+	fs.Parse(args)
+
+	store, err := util.OpenStore(*db)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if fs.NArg() > 0 {
+		if fs.Arg(0) != "history" || fs.NArg() != 2 {
+			fmt.Println("usage: flight-finder watch [flags] | watch history <id>")
+			os.Exit(2)
+		}
+		printWatchHistory(store, fs.Arg(1))
+		return
+	}
+
+	if len(notify) == 0 {
+		notify = repeatFlag{"stdout"}
+	}
+	notifier := newNotifier(notify)
+
+	ss := newProvider(*airports, *fixtures, *apiKey)
+	spec, watchID := watchSpec(store, ss.Catalog(), *from, *id, *top)
+	spec.Budget = *budget
+
+	engine := newEngine(ss, *fixtures)
+	if !*verbose {
+		engine.Log = ioutil.Discard
+	}
+
+	w := &util.Watcher{
+		ID:        watchID,
+		Engine:    engine,
+		Store:     store,
+		Spec:      spec,
+		Every:     *every,
+		Checks:    *checks,
+		Threshold: *threshold,
+		Notifier:  notifier,
+		OnCheck: func(run *util.Run, alerts []util.Alert) {
+			cheapest := "nothing complete"
+			if run.Cheapest != nil {
+				cheapest = fmt.Sprintf("cheapest %s $%.2f", run.Cheapest.PlaceName, run.Cheapest.Total)
+			}
+			fmt.Printf("%s checked %d destinations in %d calls, %s, %d alerts\n",
+				time.Now().Format("2006-01-02 15:04"), run.Searched, run.Calls, cheapest, len(alerts))
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if watchID == "" {
+		// Run makes one, but it's worth saying up front
+		if w.ID, err = util.NewRunID(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("watching %d destinations every %s as watch %s\n", len(spec.Destinations), *every, w.ID)
+	if err := w.Run(ctx); err != nil {
+		fmt.Printf("watch stopped: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// watchSpec is what a watch searches: the same as its last check when
+// carrying one on, otherwise -from's travelers and dates with its top
+// destinations
+func watchSpec(store *util.Store, catalog *util.Catalog, from, watchID string, top int) (*util.SearchSpec, string) {
+	runs, err := store.Runs()
+	if err != nil {
+		fmt.Printf("err reading runs: %v\n", err)
+		os.Exit(1)
+	}
+
+	if watchID != "" {
+		for _, run := range runs {
+			if strings.HasPrefix(run.Watch, watchID) {
+				spec, err := run.Spec.SearchSpec(catalog)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				return spec, run.Watch
+			}
+		}
+		fmt.Printf("no watch %q\n", watchID)
+		os.Exit(1)
+	}
+
+	var base *util.Run
+	if from != "" {
+		if base, err = store.Run(from); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	} else {
+		for _, run := range runs {
+			if run.Watch == "" {
+				base = run
+				break
+			}
+		}
+		if base == nil {
+			fmt.Println("no searches to watch yet, run one first or give -from")
+			os.Exit(1)
+		}
+	}
+
+	trips, err := store.Trips(base.ID)
+	if err != nil {
+		fmt.Printf("err reading trips: %v\n", err)
+		os.Exit(1)
+	}
+	if top > 0 && len(trips) > top {
+		trips = trips[:top]
+	}
+	if len(trips) == 0 {
+		fmt.Printf("run %s has no trips to watch\n", base.ID)
+		os.Exit(1)
+	}
+
+	rs := base.Spec
+	rs.Destinations = nil
+	// the destinations are picked already, no estimating needed
+	rs.Anywhere = false
+	spec, err := rs.SearchSpec(catalog)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	for _, t := range trips {
+		spec.Destinations = append(spec.Destinations, t.Destination)
+	}
+	return spec, ""
+}
+
+func printWatchHistory(store *util.Store, id string) {
+	runs, err := store.Runs()
+	if err != nil {
+		fmt.Printf("err reading runs: %v\n", err)
+		os.Exit(1)
+	}
+	watchID := ""
+	for _, run := range runs {
+		if run.Watch != "" && strings.HasPrefix(run.Watch, id) {
+			watchID = run.Watch
+			break
+		}
+	}
+	if watchID == "" {
+		fmt.Printf("no watch %q\n", id)
+		os.Exit(1)
+	}
+
+	h, err := store.WatchHistory(watchID)
+	if err != nil {
+		fmt.Printf("err reading history: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("watch %s\n", watchID)
+	for _, dst := range h.Destinations() {
+		points := h.Trips[dst]
+		fmt.Printf("\n%s: now $%.2f, lowest $%.2f over %d checks\n", dst, h.Last(dst), h.Best(dst), len(points))
+		for _, p := range points {
+			fmt.Printf("  %s  $%.2f", p.Time.Local().Format("2006-01-02 15:04"), p.Price)
+			if p.Fares != p.Price {
+				fmt.Printf("  ($%.2f fares)", p.Fares)
+			}
+			fmt.Println()
+		}
+	}
+}